@@ -0,0 +1,84 @@
+package cchunker
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/restic/chunker"
+)
+
+// anchorSplitter snaps the cut points of a content defined splitter back
+// to the end of the last record in the chunk, records being ended by the
+// AnchorDelim byte sequence, so every chunk holds whole records. The
+// partial record after the snapped cut is carried over to the front of
+// the next chunk, so a chunk is at most MaxSize bytes plus the carried
+// over part of the record, and can be longer than MaxSize even when every
+// record is shorter. A chunk without a delimiter is joined to the next,
+// so a record longer than MaxSize is always in a chunk longer than
+// MaxSize. Snapping back can also leave chunks shorter than MinSize.
+// Records are content, so the snapped cut points are still content
+// defined.
+type anchorSplitter struct {
+	splitter splitter
+	delim    []byte
+	eof      bool
+
+	start   uint64
+	data    []byte
+	pending []byte
+}
+
+func newAnchorSplitter(r io.Reader, p Params) *anchorSplitter {
+	delim := p.AnchorDelim
+	p.AnchorDelim = nil
+	return &anchorSplitter{
+		splitter: p.newChunker(r),
+		delim:    delim,
+	}
+}
+
+func (s *anchorSplitter) Next(buf []byte) (chunker.Chunk, error) {
+	for {
+		if s.eof {
+			if len(s.pending) == 0 {
+				return chunker.Chunk{}, io.EOF
+			}
+			// The last record may have no delimiter.
+			s.data, s.pending = s.pending, s.data[:0]
+			return s.emit(buf, len(s.data), 0), nil
+		}
+
+		chunk, err := s.splitter.Next(buf)
+		if err == io.EOF {
+			s.eof = true
+			continue
+		}
+		if err != nil {
+			return chunk, err
+		}
+
+		s.data = append(s.data[:0], s.pending...)
+		s.data = append(s.data, chunk.Data...)
+		i := bytes.LastIndex(s.data, s.delim)
+		if i < 0 {
+			s.data, s.pending = s.pending, s.data
+			continue
+		}
+		end := i + len(s.delim)
+		s.pending = append(s.pending[:0], s.data[end:]...)
+		return s.emit(buf, end, chunk.Cut), nil
+	}
+}
+
+// emit returns the first n bytes of data as the next chunk, copied into
+// buf as callers may still be using the data of the previous chunk.
+func (s *anchorSplitter) emit(buf []byte, n int, cut uint64) chunker.Chunk {
+	c := chunker.Chunk{
+		Start:  uint(s.start),
+		Length: uint(n),
+		Cut:    cut,
+		Data:   append(buf[:0], s.data[:n]...),
+	}
+	s.start += uint64(n)
+	return c
+}
@@ -0,0 +1,70 @@
+package cchunker
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// anchoredChunks returns the chunks of data cut into fixed size chunks of
+// size bytes, snapped to the end of lines.
+func anchoredChunks(t *testing.T, size uint, data string) []string {
+	t.Helper()
+	params := FixedParams(size)
+	params.AnchorDelim = []byte("\n")
+	s := params.newChunker(strings.NewReader(data))
+	var chunks []string
+	for {
+		c, err := s.Next(nil)
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, string(c.Data))
+	}
+}
+
+func TestAnchorSplitterCarriesRecords(t *testing.T) {
+	// Every record is shorter than the 10 byte max size, but the part of
+	// the record cut through is carried over to the next chunk.
+	data := strings.Repeat("aaaaa\n", 20)
+	chunks := anchoredChunks(t, 10, data)
+	if strings.Join(chunks, "") != data {
+		t.Fatal("chunks don't concatenate to the input")
+	}
+	longer := false
+	for _, c := range chunks {
+		if !strings.HasSuffix(c, "\n") {
+			t.Fatalf("chunk %q doesn't end with a whole record", c)
+		}
+		if len(c) > 10+5 {
+			t.Fatalf("chunk %q is longer than the max size and a carried over record", c)
+		}
+		longer = longer || len(c) > 10
+	}
+	if !longer {
+		t.Fatal("expected a chunk longer than the max size")
+	}
+}
+
+func TestAnchorSplitterOversizedRecord(t *testing.T) {
+	record := strings.Repeat("b", 35) + "\n"
+	data := "aaaa\n" + record + "cccc\n"
+	chunks := anchoredChunks(t, 10, data)
+	if strings.Join(chunks, "") != data {
+		t.Fatal("chunks don't concatenate to the input")
+	}
+	found := false
+	for _, c := range chunks {
+		if strings.Contains(c, record) {
+			found = len(c) > 10
+		} else if strings.Contains(c, "b") {
+			t.Fatalf("chunk %q splits the long record", c)
+		}
+	}
+	if !found {
+		t.Fatalf("long record is not whole in an oversized chunk: %q", chunks)
+	}
+}
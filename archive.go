@@ -0,0 +1,454 @@
+package cchunker
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveMagic starts every archive stream, so a stream in some other
+// format is rejected rather than misread.
+const archiveMagic = "cchunker archive v1\n"
+
+// Archive entry types.
+const (
+	ArchiveFile    = "file"
+	ArchiveDir     = "dir"
+	ArchiveSymlink = "symlink"
+)
+
+// ArchiveEntry is the header of a single entry of an archive stream.
+//
+// An archive stream is archiveMagic followed by each entry in turn, an
+// entry being its header as a line of JSON, then for files exactly Size
+// bytes of content. Nothing depends on when or in what order the tree
+// was read, so archiving an unchanged tree gives an identical stream and
+// a changed file only changes the bytes of its own entry, which keeps
+// the chunks of the rest of the tree shared.
+type ArchiveEntry struct {
+	// Path is slash separated and relative to the archived directory,
+	// which is itself the first entry with the path ".".
+	Path   string `json:"path"`
+	Type   string `json:"type"`
+	Mode   uint32 `json:"mode"`
+	Size   int64  `json:"size,omitempty"`
+	Target string `json:"target,omitempty"`
+
+	// The extended metadata, only recorded with ArchiveOptions.Metadata.
+	// ACLs are recorded as the system.posix_acl_* xattrs they are stored
+	// in on Linux.
+	UID     *uint32           `json:"uid,omitempty"`
+	GID     *uint32           `json:"gid,omitempty"`
+	MtimeNs *int64            `json:"mtime_ns,omitempty"`
+	Xattrs  map[string][]byte `json:"xattrs,omitempty"`
+}
+
+// ArchiveOptions control what WriteArchive records about each entry.
+type ArchiveOptions struct {
+	// Metadata records the ownership, modification time and xattrs of
+	// each entry. It makes restores faithful, but a tree that was only
+	// touched or chowned no longer gives an identical stream.
+	Metadata bool
+	// Warnings gets a line for each fifo, socket or device skipped, as
+	// the archive format can't hold them. Nil discards the warnings.
+	Warnings io.Writer
+}
+
+// readArchiveMetadata fills in the extended metadata of entry from the
+// file at p.
+func readArchiveMetadata(p string, info fs.FileInfo, entry *ArchiveEntry) error {
+	uid, gid, ok := fileOwner(info)
+	if ok {
+		entry.UID = &uid
+		entry.GID = &gid
+	}
+	mtime := info.ModTime().UnixNano()
+	entry.MtimeNs = &mtime
+	xattrs, err := listXattrs(p)
+	if err != nil {
+		return err
+	}
+	if len(xattrs) != 0 {
+		entry.Xattrs = xattrs
+	}
+	return nil
+}
+
+// restoreArchiveMetadata applies the extended metadata of entry to the
+// extracted file at p, except the modification time of directories,
+// which changes as they are filled.
+func restoreArchiveMetadata(p string, entry *ArchiveEntry) error {
+	for _, name := range sortedKeys(entry.Xattrs) {
+		err := setXattr(p, name, entry.Xattrs[name])
+		if err != nil {
+			return err
+		}
+	}
+	// Like tar, ownership is only restored when running as root, as
+	// others can't give files away.
+	if entry.UID != nil && entry.GID != nil && os.Geteuid() == 0 {
+		err := os.Lchown(p, int(*entry.UID), int(*entry.GID))
+		if err != nil {
+			return err
+		}
+		// chown clears the setuid and setgid bits.
+		if entry.Type != ArchiveSymlink {
+			err = os.Chmod(p, fileMode(entry.Mode))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if entry.MtimeNs != nil && entry.Type != ArchiveDir {
+		return setMtime(p, time.Unix(0, *entry.MtimeNs))
+	}
+	return nil
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// archiveMode returns the unix permission bits of m.
+func archiveMode(m fs.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return mode
+}
+
+// fileMode is the inverse of archiveMode.
+func fileMode(mode uint32) fs.FileMode {
+	m := fs.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+// WriteArchive walks the directory tree at dir in lexical order, writing
+// each entry to out as an archive stream. Symlinks are archived as links
+// and not followed, other special files are skipped with a warning to
+// opts.Warnings.
+func WriteArchive(out io.Writer, dir string, opts ArchiveOptions) error {
+	w := bufio.NewWriter(out)
+	_, err := w.WriteString(archiveMagic)
+	if err != nil {
+		return fmt.Errorf("error writing archive: %s", err)
+	}
+
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		entry := ArchiveEntry{
+			Path: filepath.ToSlash(rel),
+			Mode: archiveMode(info.Mode()),
+		}
+		switch {
+		case info.Mode().IsRegular():
+			entry.Type = ArchiveFile
+			entry.Size = info.Size()
+		case info.IsDir():
+			entry.Type = ArchiveDir
+		case info.Mode()&fs.ModeSymlink != 0:
+			entry.Type = ArchiveSymlink
+			entry.Target, err = os.Readlink(p)
+			if err != nil {
+				return err
+			}
+		default:
+			if opts.Warnings != nil {
+				_, err = fmt.Fprintf(opts.Warnings, "skipping %s: unsupported file type %s\n", p, info.Mode().Type())
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if opts.Metadata {
+			err = readArchiveMetadata(p, info, &entry)
+			if err != nil {
+				return err
+			}
+		}
+		err = writeArchiveHeader(w, entry)
+		if err != nil {
+			return err
+		}
+		if entry.Type == ArchiveFile {
+			return writeArchiveFile(w, p, entry.Size)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error archiving %s: %s", dir, err)
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return fmt.Errorf("error writing archive: %s", err)
+	}
+	return nil
+}
+
+func writeArchiveHeader(w io.Writer, entry ArchiveEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// writeArchiveFile copies exactly size bytes of the file at p, as the
+// header already written promised that many.
+func writeArchiveFile(w io.Writer, p string, size int64) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.CopyN(w, f, size)
+	if err == io.EOF {
+		return fmt.Errorf("%s: file shrank from %d to %d bytes while being archived", p, size, n)
+	}
+	return err
+}
+
+// ArchiveReader reads the entries of an archive stream.
+type ArchiveReader struct {
+	r         *bufio.Reader
+	seeker    io.Seeker
+	offset    int64
+	remaining int64
+	// contents is the offset of the contents of the current entry.
+	contents int64
+}
+
+// NewArchiveReader returns a reader of the archive stream r. If r is an
+// io.ReadSeeker, file contents that aren't read are seeked over.
+func NewArchiveReader(r io.Reader) (*ArchiveReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(archiveMagic))
+	_, err := io.ReadFull(br, magic)
+	if err != nil || string(magic) != archiveMagic {
+		return nil, errors.New("input is not a cchunker archive")
+	}
+	ar := &ArchiveReader{r: br, offset: int64(len(magic))}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		ar.seeker = rs
+	}
+	return ar, nil
+}
+
+// IsArchive reports whether the stream r starts like an archive.
+func IsArchive(r io.ReaderAt) bool {
+	magic := make([]byte, len(archiveMagic))
+	_, err := r.ReadAt(magic, 0)
+	return err == nil && string(magic) == archiveMagic
+}
+
+// Offset returns the offset in the stream of the contents of the entry
+// last returned by Next.
+func (ar *ArchiveReader) Offset() int64 {
+	return ar.contents
+}
+
+// skip skips the rest of the contents of the current entry.
+func (ar *ArchiveReader) skip() error {
+	buffered := int64(ar.r.Buffered())
+	if ar.seeker != nil && ar.remaining > buffered {
+		_, err := ar.seeker.Seek(ar.remaining-buffered, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		ar.r.Reset(ar.seeker.(io.Reader))
+	} else {
+		_, err := io.CopyN(io.Discard, ar.r, ar.remaining)
+		if err != nil {
+			return err
+		}
+	}
+	ar.offset += ar.remaining
+	ar.remaining = 0
+	return nil
+}
+
+// Next returns the header of the next entry, skipping any content of the
+// previous entry not yet read. At the end of the archive it returns
+// io.EOF.
+func (ar *ArchiveReader) Next() (*ArchiveEntry, error) {
+	if ar.remaining != 0 {
+		err := ar.skip()
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive: %s", err)
+		}
+	}
+	line, err := ar.r.ReadBytes('\n')
+	ar.offset += int64(len(line))
+	if err == io.EOF && len(line) == 0 {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive: %s", err)
+	}
+	var entry ArchiveEntry
+	err = json.Unmarshal(line, &entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive entry: %s", err)
+	}
+	if !validArchivePath(entry.Path) {
+		return nil, fmt.Errorf("invalid archive entry path %q", entry.Path)
+	}
+	if entry.Size < 0 || (entry.Type != ArchiveFile && entry.Size != 0) {
+		return nil, fmt.Errorf("invalid archive entry size for %q", entry.Path)
+	}
+	ar.remaining = entry.Size
+	ar.contents = ar.offset
+	return &entry, nil
+}
+
+// Read reads the content of the current entry.
+func (ar *ArchiveReader) Read(buf []byte) (int, error) {
+	if ar.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(buf)) > ar.remaining {
+		buf = buf[:ar.remaining]
+	}
+	n, err := ar.r.Read(buf)
+	ar.offset += int64(n)
+	ar.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// validArchivePath reports whether p stays within the directory an
+// archive is extracted to.
+func validArchivePath(p string) bool {
+	if p == "." {
+		return true
+	}
+	return p != "" && !path.IsAbs(p) && path.Clean(p) == p && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// ExtractArchive recreates the tree of the archive stream r in the
+// directory dir.
+func ExtractArchive(r io.Reader, dir string) error {
+	ar, err := NewArchiveReader(r)
+	if err != nil {
+		return err
+	}
+
+	// Directory permissions are applied last, so read only directories
+	// can still be filled.
+	type dirMode struct {
+		path  string
+		mode  fs.FileMode
+		mtime *int64
+	}
+	var dirs []dirMode
+	// An entry beneath an extracted symlink would be written wherever the
+	// link points, so those are refused.
+	symlinks := make(map[string]bool)
+
+	for {
+		entry, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for parent := path.Dir(entry.Path); parent != "."; parent = path.Dir(parent) {
+			if symlinks[parent] {
+				return fmt.Errorf("invalid archive entry path %q beneath a symlink", entry.Path)
+			}
+		}
+		p := filepath.Join(dir, filepath.FromSlash(entry.Path))
+		switch entry.Type {
+		case ArchiveDir:
+			err = os.MkdirAll(p, 0700)
+			dirs = append(dirs, dirMode{p, fileMode(entry.Mode), entry.MtimeNs})
+		case ArchiveSymlink:
+			err = os.Symlink(entry.Target, p)
+			symlinks[entry.Path] = true
+		case ArchiveFile:
+			err = extractArchiveFile(ar, p, fileMode(entry.Mode))
+		default:
+			err = fmt.Errorf("unknown archive entry type %q", entry.Type)
+		}
+		if err == nil {
+			err = restoreArchiveMetadata(p, entry)
+		}
+		if err != nil {
+			return fmt.Errorf("error extracting %s: %s", entry.Path, err)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i -= 1 {
+		err = os.Chmod(dirs[i].path, dirs[i].mode)
+		if err == nil && dirs[i].mtime != nil {
+			err = setMtime(dirs[i].path, time.Unix(0, *dirs[i].mtime))
+		}
+		if err != nil {
+			return fmt.Errorf("error extracting: %s", err)
+		}
+	}
+	return nil
+}
+
+func extractArchiveFile(r io.Reader, p string, mode fs.FileMode) error {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Chmod(p, mode)
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+package cchunker
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+func fileOwner(info fs.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
+
+func setMtime(p string, mtime time.Time) error {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return err
+	}
+	// Symlink times can't be set without following them here.
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return nil
+	}
+	return os.Chtimes(p, time.Time{}, mtime)
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package cchunker
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileOwner returns the user and group owning the file of info.
+func fileOwner(info fs.FileInfo) (uint32, uint32, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return st.Uid, st.Gid, true
+}
+
+// setMtime sets the modification time of the file at p, without
+// following symlinks. Access times aren't archived, so it is set too.
+func setMtime(p string, mtime time.Time) error {
+	t := unix.NsecToTimespec(mtime.UnixNano())
+	ts := []unix.Timespec{t, t}
+	err := unix.UtimesNanoAt(unix.AT_FDCWD, p, ts, unix.AT_SYMLINK_NOFOLLOW)
+	if err != nil {
+		return &fs.PathError{Op: "utimes", Path: p, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+//go:build unix
+
+package cchunker
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestWriteArchiveSkipsFifos(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "a"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = syscall.Mkfifo(filepath.Join(dir, "fifo"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out, warnings bytes.Buffer
+	err = WriteArchive(&out, dir, ArchiveOptions{Warnings: &warnings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(warnings.String(), "skipping "+filepath.Join(dir, "fifo")) {
+		t.Fatalf("warnings %q, expected the fifo to be skipped", warnings.String())
+	}
+
+	ar, err := NewArchiveReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for {
+		entry, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, entry.Path)
+	}
+	if strings.Join(paths, " ") != ". a" {
+		t.Fatalf("archived %q, expected the directory and file", paths)
+	}
+}
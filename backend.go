@@ -0,0 +1,95 @@
+package cchunker
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Backend stores chunk data keyed by chunk id.
+type Backend interface {
+	// Has reports if the chunk with the given id is present.
+	Has(id string) (bool, error)
+	// Put stores data as the chunk with the given id.
+	Put(id string, data []byte) error
+	// Get returns the data of the chunk with the given id.
+	Get(id string) ([]byte, error)
+}
+
+// SequencedBackend is a Backend able to pass the SequenceID of a chunk on to
+// the service storing it, so the service can deduplicate the requests of a
+// retried run. BackendProcessor uses PutSequenced for chunks with an id.
+type SequencedBackend interface {
+	Backend
+	// PutSequenced stores data as the chunk with the given id, as Put,
+	// recording its sequence id.
+	PutSequenced(id, sequenceID string, data []byte) error
+}
+
+// shardedName returns the ab/cd/abcd... name of the chunk with the given id.
+func shardedName(id string) string {
+	if len(id) < 4 {
+		return id
+	}
+	return id[0:2] + "/" + id[2:4] + "/" + id
+}
+
+var backends = map[string]func(u *url.URL) (Backend, error){}
+
+// OpenBackend opens the backend described by rawURL, e.g. s3://bucket/prefix.
+func OpenBackend(rawURL string) (Backend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend url: %s", err)
+	}
+	open, ok := backends[u.Scheme]
+	if !ok {
+		var schemes []string
+		for scheme := range backends {
+			schemes = append(schemes, scheme+"://")
+		}
+		sort.Strings(schemes)
+		return nil, fmt.Errorf("unknown backend %q, expected one of %s", rawURL, strings.Join(schemes, ", "))
+	}
+	return open(u)
+}
+
+// BackendProcessor returns a Processor that puts each chunk not already
+// present in b, keyed by its hex digest, writing a "hash size offset" line
+// per chunk.
+func BackendProcessor(b Backend, hashName string) (Processor, error) {
+	_, err := NewHash(hashName)
+	if err != nil {
+		return nil, err
+	}
+
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		h, _ := NewHash(hashName)
+		h.Write(c.Data)
+		id := fmt.Sprintf("%x", h.Sum(nil))
+
+		present, err := b.Has(id)
+		if err != nil {
+			return fmt.Errorf("error checking for chunk %s: %s", id, err)
+		}
+		if !present {
+			sb, ok := b.(SequencedBackend)
+			if ok && c.SequenceID != "" {
+				err = sb.PutSequenced(id, c.SequenceID, c.Data)
+			} else {
+				err = b.Put(id, c.Data)
+			}
+			if err != nil {
+				return fmt.Errorf("error putting chunk %s: %s", id, err)
+			}
+		}
+
+		err = writeManifestLine(out, id, c)
+		if err != nil {
+			return fmt.Errorf("error writing manifest line: %s", err)
+		}
+		return nil
+	}), nil
+}
@@ -0,0 +1,177 @@
+package cchunker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"sync"
+)
+
+// bloomFilterMagic starts a Bloom filter written by writeTo.
+var bloomFilterMagic = []byte("CCBLOOM1")
+
+// bloomFilter is an in memory set that may report false positives but
+// never false negatives.
+type bloomFilter struct {
+	mu   sync.RWMutex
+	bits []uint64
+	m    uint64
+	k    int
+}
+
+// newBloomFilter returns a filter sized to hold n items with the given
+// false positive rate.
+func newBloomFilter(n int, falsePositiveRate float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// positions calls f with each bit position of s, using double hashing.
+// The hash is unseeded so a filter written to a file means the same when
+// read back.
+func (b *bloomFilter) positions(s string, f func(pos uint64)) {
+	h := fnv.New128a()
+	io.WriteString(h, s)
+	sum := h.Sum(nil)
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16]) | 1
+	for i := 0; i < b.k; i++ {
+		f((h1 + uint64(i)*h2) % b.m)
+	}
+}
+
+func (b *bloomFilter) add(s string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.positions(s, func(pos uint64) {
+		b.bits[pos/64] |= 1 << (pos % 64)
+	})
+}
+
+func (b *bloomFilter) mayContain(s string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	present := true
+	b.positions(s, func(pos uint64) {
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			present = false
+		}
+	})
+	return present
+}
+
+// Has reports if s may be in the filter, making it a HashSet.
+func (b *bloomFilter) Has(s string) bool {
+	return b.mayContain(s)
+}
+
+// writeTo writes the filter as the magic, the bit count and hash count as
+// big endian uint64s, then the bits as big endian uint64 words.
+func (b *bloomFilter) writeTo(w io.Writer) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	bw := bufio.NewWriter(w)
+	bw.Write(bloomFilterMagic)
+	var buf [8]byte
+	for _, v := range append([]uint64{b.m, uint64(b.k)}, b.bits...) {
+		binary.BigEndian.PutUint64(buf[:], v)
+		bw.Write(buf[:])
+	}
+	return bw.Flush()
+}
+
+// readBloomFilter reads a filter written by writeTo, after its magic.
+func readBloomFilter(r io.Reader) (*bloomFilter, error) {
+	var header [16]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return nil, fmt.Errorf("error reading bloom filter: %s", err)
+	}
+	m := binary.BigEndian.Uint64(header[0:8])
+	k := binary.BigEndian.Uint64(header[8:16])
+	if m == 0 || k == 0 || k > 64 || m > 1<<40 {
+		return nil, fmt.Errorf("invalid bloom filter with %d bits and %d hashes", m, k)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bloom filter: %s", err)
+	}
+	words := (m + 63) / 64
+	if uint64(len(data)) != words*8 {
+		return nil, fmt.Errorf("bloom filter of %d bits has %d bytes of bits, expected %d", m, len(data), words*8)
+	}
+	b := &bloomFilter{
+		bits: make([]uint64, words),
+		m:    m,
+		k:    int(k),
+	}
+	for i := range b.bits {
+		b.bits[i] = binary.BigEndian.Uint64(data[i*8:])
+	}
+	return b, nil
+}
+
+// WriteBloomFilter writes a Bloom filter of ids with the given false
+// positive rate to w, for ReadHashSet.
+func WriteBloomFilter(w io.Writer, ids map[string]bool, falsePositiveRate float64) error {
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return fmt.Errorf("bloom filter false positive rate must be between 0 and 1")
+	}
+	b := newBloomFilter(len(ids), falsePositiveRate)
+	for id := range ids {
+		b.add(id)
+	}
+	err := b.writeTo(w)
+	if err != nil {
+		return fmt.Errorf("error writing bloom filter: %s", err)
+	}
+	return nil
+}
+
+// HashSet is a set of chunk ids, which may report ids it doesn't hold if
+// it is a Bloom filter.
+type HashSet interface {
+	Has(id string) bool
+}
+
+// idSet is a HashSet holding exactly its ids.
+type idSet map[string]bool
+
+func (s idSet) Has(id string) bool {
+	return s[id]
+}
+
+// ReadHashSet reads a set of chunk ids, either a Bloom filter written by
+// WriteBloomFilter, or text with an id as the first field of each line,
+// such as a list of hashes or a manifest, see ReadManifestIDs.
+func ReadHashSet(r io.Reader) (HashSet, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(bloomFilterMagic))
+	if err == nil && bytes.Equal(magic, bloomFilterMagic) {
+		br.Discard(len(magic))
+		return readBloomFilter(br)
+	}
+	ids, err := ReadManifestIDs(br)
+	if err != nil {
+		return nil, err
+	}
+	return idSet(ids), nil
+}
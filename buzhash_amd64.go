@@ -0,0 +1,23 @@
+//go:build !purego
+
+package cchunker
+
+import "golang.org/x/sys/cpu"
+
+var buzhashHasAVX2 = cpu.X86.HasAVX2
+
+// buzhashScanBlocks rolls the hash eight bytes at a time with AVX2 when
+// the CPU supports it, stopping at the first window with the mask bits
+// clear or before the last partial block. The hashes match the portable
+// loop exactly, so chunk boundaries don't depend on the CPU. Only buzhash
+// is vectorized, each step of the rabin fingerprint looks up a table by
+// the hash before it, so its bytes can't be rolled in parallel.
+func buzhashScanBlocks(table, removeTable *[256]uint32, windowSize int, mask uint32, data []byte, n int, sum uint32) (int, uint32) {
+	if !buzhashHasAVX2 || n < 8 {
+		return 0, sum
+	}
+	return buzhashScanAVX2(table, removeTable, mask, &data[0], windowSize, n, sum)
+}
+
+//go:noescape
+func buzhashScanAVX2(table, removeTable *[256]uint32, mask uint32, data *byte, windowSize int, n int, sum uint32) (int, uint32)
@@ -0,0 +1,217 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// The hash after rolling over byte i is
+//
+//	s[i+1] = rotl(s[i], 1) ^ d[i],  d[i] = rotl(table[remove], rot) ^ table[add]
+//
+// so eight bytes on from s the hash is
+//
+//	s[k] = rotl(s, k) ^ rotr(p[k-1], 8-k),  p[m] = xor of rotl(d[j], 7-j) for j <= m
+//
+// where p is a prefix xor over the lanes of a vector, giving all eight
+// hashes of a block at once. The table lookups are scalar, as gathers are
+// slower than loads on many CPUs.
+
+// LOOKUP sets BX to d for byte j of the block.
+#define LOOKUP(j) \
+	MOVBLZX j(SI)(R10*1), BX \
+	MOVL    (R12)(BX*4), BX  \
+	MOVBLZX j(DI)(R10*1), CX \
+	XORL    (AX)(CX*4), BX
+
+// Counts rotating lane j left by 7-j, or right when the pair is swapped.
+DATA buzhashDown<>+0x00(SB)/4, $7
+DATA buzhashDown<>+0x04(SB)/4, $6
+DATA buzhashDown<>+0x08(SB)/4, $5
+DATA buzhashDown<>+0x0c(SB)/4, $4
+DATA buzhashDown<>+0x10(SB)/4, $3
+DATA buzhashDown<>+0x14(SB)/4, $2
+DATA buzhashDown<>+0x18(SB)/4, $1
+DATA buzhashDown<>+0x1c(SB)/4, $0
+GLOBL buzhashDown<>(SB), RODATA|NOPTR, $32
+
+DATA buzhashDownRest<>+0x00(SB)/4, $25
+DATA buzhashDownRest<>+0x04(SB)/4, $26
+DATA buzhashDownRest<>+0x08(SB)/4, $27
+DATA buzhashDownRest<>+0x0c(SB)/4, $28
+DATA buzhashDownRest<>+0x10(SB)/4, $29
+DATA buzhashDownRest<>+0x14(SB)/4, $30
+DATA buzhashDownRest<>+0x18(SB)/4, $31
+DATA buzhashDownRest<>+0x1c(SB)/4, $32
+GLOBL buzhashDownRest<>(SB), RODATA|NOPTR, $32
+
+// Counts rotating lane j left by j+1.
+DATA buzhashUp<>+0x00(SB)/4, $1
+DATA buzhashUp<>+0x04(SB)/4, $2
+DATA buzhashUp<>+0x08(SB)/4, $3
+DATA buzhashUp<>+0x0c(SB)/4, $4
+DATA buzhashUp<>+0x10(SB)/4, $5
+DATA buzhashUp<>+0x14(SB)/4, $6
+DATA buzhashUp<>+0x18(SB)/4, $7
+DATA buzhashUp<>+0x1c(SB)/4, $8
+GLOBL buzhashUp<>(SB), RODATA|NOPTR, $32
+
+DATA buzhashUpRest<>+0x00(SB)/4, $31
+DATA buzhashUpRest<>+0x04(SB)/4, $30
+DATA buzhashUpRest<>+0x08(SB)/4, $29
+DATA buzhashUpRest<>+0x0c(SB)/4, $28
+DATA buzhashUpRest<>+0x10(SB)/4, $27
+DATA buzhashUpRest<>+0x14(SB)/4, $26
+DATA buzhashUpRest<>+0x18(SB)/4, $25
+DATA buzhashUpRest<>+0x1c(SB)/4, $24
+GLOBL buzhashUpRest<>(SB), RODATA|NOPTR, $32
+
+// Lane permutations and masks shifting lanes up by 1, 2 and 4.
+DATA buzhashShift1<>+0x00(SB)/4, $0
+DATA buzhashShift1<>+0x04(SB)/4, $0
+DATA buzhashShift1<>+0x08(SB)/4, $1
+DATA buzhashShift1<>+0x0c(SB)/4, $2
+DATA buzhashShift1<>+0x10(SB)/4, $3
+DATA buzhashShift1<>+0x14(SB)/4, $4
+DATA buzhashShift1<>+0x18(SB)/4, $5
+DATA buzhashShift1<>+0x1c(SB)/4, $6
+GLOBL buzhashShift1<>(SB), RODATA|NOPTR, $32
+
+DATA buzhashShift2<>+0x00(SB)/4, $0
+DATA buzhashShift2<>+0x04(SB)/4, $0
+DATA buzhashShift2<>+0x08(SB)/4, $0
+DATA buzhashShift2<>+0x0c(SB)/4, $1
+DATA buzhashShift2<>+0x10(SB)/4, $2
+DATA buzhashShift2<>+0x14(SB)/4, $3
+DATA buzhashShift2<>+0x18(SB)/4, $4
+DATA buzhashShift2<>+0x1c(SB)/4, $5
+GLOBL buzhashShift2<>(SB), RODATA|NOPTR, $32
+
+DATA buzhashShift4<>+0x00(SB)/4, $0
+DATA buzhashShift4<>+0x04(SB)/4, $0
+DATA buzhashShift4<>+0x08(SB)/4, $0
+DATA buzhashShift4<>+0x0c(SB)/4, $0
+DATA buzhashShift4<>+0x10(SB)/4, $0
+DATA buzhashShift4<>+0x14(SB)/4, $1
+DATA buzhashShift4<>+0x18(SB)/4, $2
+DATA buzhashShift4<>+0x1c(SB)/4, $3
+GLOBL buzhashShift4<>(SB), RODATA|NOPTR, $32
+
+DATA buzhashKeep1<>+0x00(SB)/4, $0
+DATA buzhashKeep1<>+0x04(SB)/4, $0xffffffff
+DATA buzhashKeep1<>+0x08(SB)/4, $0xffffffff
+DATA buzhashKeep1<>+0x0c(SB)/4, $0xffffffff
+DATA buzhashKeep1<>+0x10(SB)/4, $0xffffffff
+DATA buzhashKeep1<>+0x14(SB)/4, $0xffffffff
+DATA buzhashKeep1<>+0x18(SB)/4, $0xffffffff
+DATA buzhashKeep1<>+0x1c(SB)/4, $0xffffffff
+GLOBL buzhashKeep1<>(SB), RODATA|NOPTR, $32
+
+DATA buzhashKeep2<>+0x00(SB)/4, $0
+DATA buzhashKeep2<>+0x04(SB)/4, $0
+DATA buzhashKeep2<>+0x08(SB)/4, $0xffffffff
+DATA buzhashKeep2<>+0x0c(SB)/4, $0xffffffff
+DATA buzhashKeep2<>+0x10(SB)/4, $0xffffffff
+DATA buzhashKeep2<>+0x14(SB)/4, $0xffffffff
+DATA buzhashKeep2<>+0x18(SB)/4, $0xffffffff
+DATA buzhashKeep2<>+0x1c(SB)/4, $0xffffffff
+GLOBL buzhashKeep2<>(SB), RODATA|NOPTR, $32
+
+DATA buzhashKeep4<>+0x00(SB)/4, $0
+DATA buzhashKeep4<>+0x04(SB)/4, $0
+DATA buzhashKeep4<>+0x08(SB)/4, $0
+DATA buzhashKeep4<>+0x0c(SB)/4, $0
+DATA buzhashKeep4<>+0x10(SB)/4, $0xffffffff
+DATA buzhashKeep4<>+0x14(SB)/4, $0xffffffff
+DATA buzhashKeep4<>+0x18(SB)/4, $0xffffffff
+DATA buzhashKeep4<>+0x1c(SB)/4, $0xffffffff
+GLOBL buzhashKeep4<>(SB), RODATA|NOPTR, $32
+
+// func buzhashScanAVX2(table, removeTable *[256]uint32, mask uint32, data *byte, windowSize int, n int, sum uint32) (int, uint32)
+TEXT ·buzhashScanAVX2(SB), NOSPLIT, $32-68
+	MOVQ table+0(FP), AX
+	MOVQ removeTable+8(FP), R12
+	MOVL mask+16(FP), DX
+	MOVQ data+24(FP), SI
+	MOVQ windowSize+32(FP), DI
+	ADDQ SI, DI
+	MOVQ n+40(FP), R8
+	MOVL sum+48(FP), R9
+	XORQ R10, R10
+
+	VMOVD DX, X13
+	VPBROADCASTD X13, Y13
+	VPXOR Y12, Y12, Y12
+	VMOVDQU buzhashShift1<>(SB), Y8
+	VMOVDQU buzhashShift2<>(SB), Y9
+	VMOVDQU buzhashShift4<>(SB), Y10
+
+loop:
+	LEAQ 8(R10), R11
+	CMPQ R11, R8
+	JA   done
+
+	// d for the eight bytes, removeTable holds the rotated entries.
+	LOOKUP(0)
+	VMOVD       BX, X0
+	LOOKUP(1)
+	VPINSRD     $1, BX, X0, X0
+	LOOKUP(2)
+	VPINSRD     $2, BX, X0, X0
+	LOOKUP(3)
+	VPINSRD     $3, BX, X0, X0
+	LOOKUP(4)
+	VMOVD       BX, X1
+	LOOKUP(5)
+	VPINSRD     $1, BX, X1, X1
+	LOOKUP(6)
+	VPINSRD     $2, BX, X1, X1
+	LOOKUP(7)
+	VPINSRD     $3, BX, X1, X1
+	VINSERTI128 $1, X1, Y0, Y5
+
+	// p = prefix xor of rotl(d[j], 7-j).
+	VPSLLVD buzhashDown<>(SB), Y5, Y6
+	VPSRLVD buzhashDownRest<>(SB), Y5, Y7
+	VPOR    Y6, Y7, Y5
+	VPERMD  Y5, Y8, Y6
+	VPAND   buzhashKeep1<>(SB), Y6, Y6
+	VPXOR   Y6, Y5, Y5
+	VPERMD  Y5, Y9, Y6
+	VPAND   buzhashKeep2<>(SB), Y6, Y6
+	VPXOR   Y6, Y5, Y5
+	VPERMD  Y5, Y10, Y6
+	VPAND   buzhashKeep4<>(SB), Y6, Y6
+	VPXOR   Y6, Y5, Y5
+
+	// Lane m holds rotr(p[m], 7-m) ^ rotl(s, m+1), the hash m+1 bytes on.
+	VPSRLVD      buzhashDown<>(SB), Y5, Y6
+	VPSLLVD      buzhashDownRest<>(SB), Y5, Y7
+	VPOR         Y6, Y7, Y5
+	VMOVD        R9, X6
+	VPBROADCASTD X6, Y6
+	VPSLLVD      buzhashUp<>(SB), Y6, Y7
+	VPSRLVD      buzhashUpRest<>(SB), Y6, Y6
+	VPOR         Y6, Y7, Y6
+	VPXOR        Y6, Y5, Y5
+
+	VPAND     Y13, Y5, Y6
+	VPCMPEQD  Y12, Y6, Y6
+	VMOVMSKPS Y6, R11
+	TESTL     R11, R11
+	JNZ       found
+
+	VEXTRACTI128 $1, Y5, X6
+	VPEXTRD      $3, X6, R9
+	ADDQ         $8, R10
+	JMP          loop
+
+found:
+	BSFL    R11, R11
+	VMOVDQU Y5, hashes-32(SP)
+	LEAQ    hashes-32(SP), BX
+	MOVL    (BX)(R11*4), R9
+	LEAQ    1(R10)(R11*1), R10
+
+done:
+	VZEROUPPER
+	MOVQ R10, ret+56(FP)
+	MOVL R9, ret1+64(FP)
+	RET
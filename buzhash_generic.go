@@ -0,0 +1,8 @@
+//go:build !amd64 || purego
+
+package cchunker
+
+// buzhashScanBlocks leaves the whole scan to the portable loop.
+func buzhashScanBlocks(table, removeTable *[256]uint32, windowSize int, mask uint32, data []byte, n int, sum uint32) (int, uint32) {
+	return 0, sum
+}
@@ -0,0 +1,134 @@
+package cchunker
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// borgChunks chunks data like borg's Chunker(seed, minExp, maxExp,
+// maskBits, window).
+func borgChunks(t *testing.T, seed uint32, minExp, maxExp, maskBits uint, window int, data []byte) []string {
+	t.Helper()
+	c := newBuzhashChunker(bytes.NewReader(data), Params{
+		MinSize:     1 << minExp,
+		MaxSize:     1 << maxExp,
+		AverageBits: int(maskBits),
+		WindowSize:  window,
+		Seed:        seed,
+	})
+	var chunks []string
+	for {
+		chunk, err := c.Next(nil)
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, string(chunk.Data))
+	}
+}
+
+func TestBuzhashMatchesBorg(t *testing.T) {
+	// Hashes from borg's testsuite/chunker.py.
+	for _, tc := range []struct {
+		data string
+		seed uint32
+		sum  uint32
+	}{
+		{"abcdefghijklmnop", 0, 3795437769},
+		{"abcdefghijklmnop", 1, 3795400502},
+	} {
+		c := newBuzhashChunker(nil, Params{MaxSize: 1, WindowSize: len(tc.data), Seed: tc.seed})
+		sum := c.hash([]byte(tc.data))
+		if sum != tc.sum {
+			t.Errorf("buzhash of %q with seed %d is %d, expected %d", tc.data, tc.seed, sum, tc.sum)
+		}
+	}
+
+	c := newBuzhashChunker(nil, Params{MaxSize: 1, WindowSize: 16, Seed: 1})
+	rolled := c.update(c.hash([]byte("Xabcdefghijklmno")), 'X', 'p')
+	if rolled != c.hash([]byte("abcdefghijklmnop")) {
+		t.Error("rolled buzhash differs from the buzhash of the window")
+	}
+}
+
+func TestBuzhashChunksMatchBorg(t *testing.T) {
+	// Chunkings from borg's testsuite/chunker.py.
+	data := []byte(strings.Repeat("foobarboobaz", 3))
+	for _, tc := range []struct {
+		seed             uint32
+		minExp, maskBits uint
+		window           int
+		chunks           []string
+	}{
+		{0, 1, 2, 2, []string{"fooba", "rboobaz", "fooba", "rboobaz", "fooba", "rboobaz"}},
+		{1, 1, 2, 2, []string{"fo", "obarb", "oob", "azf", "oobarb", "oob", "azf", "oobarb", "oobaz"}},
+		{2, 1, 2, 2, []string{"foob", "ar", "boobazfoob", "ar", "boobazfoob", "ar", "boobaz"}},
+		{0, 2, 2, 3, []string{strings.Repeat("foobarboobaz", 3)}},
+		{1, 2, 2, 3, []string{"foobar", "boobazfo", "obar", "boobazfo", "obar", "boobaz"}},
+		{2, 2, 2, 3, []string{"foob", "arboobaz", "foob", "arboobaz", "foob", "arboobaz"}},
+		{0, 3, 2, 3, []string{strings.Repeat("foobarboobaz", 3)}},
+		{1, 3, 2, 3, []string{"foobarbo", "obazfoobar", "boobazfo", "obarboobaz"}},
+		{2, 3, 2, 3, []string{"foobarboobaz", "foobarboobaz", "foobarboobaz"}},
+	} {
+		chunks := borgChunks(t, tc.seed, tc.minExp, 23, tc.maskBits, tc.window, data)
+		if strings.Join(chunks, "|") != strings.Join(tc.chunks, "|") {
+			t.Errorf("seed %d, min exp %d, window %d: chunks %q, expected %q", tc.seed, tc.minExp, tc.window, chunks, tc.chunks)
+		}
+	}
+}
+
+// scanPortable is buzhashChunker.scan without the vectorized block scan.
+func scanPortable(c *buzhashChunker, data []byte, sum uint32, n int) (int, uint32) {
+	i := 0
+	for ; i < n && sum&c.mask != 0; i++ {
+		sum = c.update(sum, data[i], data[i+c.windowSize])
+	}
+	return i, sum
+}
+
+// checkScan compares the scan of c over data, starting from the hash of
+// its first window, with the portable scan.
+func checkScan(t *testing.T, c *buzhashChunker, data []byte) {
+	t.Helper()
+	n := len(data) - c.windowSize
+	sum := c.hash(data[:c.windowSize])
+	i, got := c.scan(data, sum, n)
+	j, want := scanPortable(c, data, sum, n)
+	if i != j || got != want {
+		t.Fatalf("window %d, mask %#x, %d bytes: scan stopped at %d with %#x, expected %d with %#x", c.windowSize, c.mask, n, i, got, j, want)
+	}
+}
+
+func TestBuzhashScanMatchesPortable(t *testing.T) {
+	data := testData(64 * kiB)
+	for _, window := range []int{1, 2, 3, 7, 8, 9, 31, 32, 33, 63, 64, 65, 4095} {
+		for _, bits := range []int{1, 3, 8, 13, 21, 32} {
+			c := newBuzhashChunker(nil, Params{MaxSize: 1, WindowSize: window, AverageBits: bits, Seed: uint32(window * bits)})
+			// Lengths and starts either side of the eight byte blocks.
+			for n := 0; n <= 40; n++ {
+				for start := 0; start < 8; start++ {
+					checkScan(t, c, data[start:start+window+n])
+				}
+			}
+			for _, n := range []int{63, 64, 65, 1000, 4096, 60000} {
+				checkScan(t, c, data[:window+n])
+			}
+		}
+	}
+}
+
+func FuzzBuzhashScan(f *testing.F) {
+	f.Add([]byte("foobarboobaz foobarboobaz foobarboobaz"), uint16(5), uint8(2), uint32(0))
+	f.Add(testData(1000), uint16(64), uint8(8), uint32(1))
+	f.Fuzz(func(t *testing.T, data []byte, window uint16, bits uint8, seed uint32) {
+		if window == 0 || int(window) > len(data) || bits > 32 {
+			t.Skip()
+		}
+		c := newBuzhashChunker(nil, Params{MaxSize: 1, WindowSize: int(window), AverageBits: int(bits), Seed: seed})
+		checkScan(t, c, data)
+	})
+}
@@ -0,0 +1,168 @@
+package cchunker
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var dedupCacheBucket = []byte("chunks")
+
+// DedupCache is a persistent record of the output of chunks already
+// processed, keyed by the hex digest of the chunk data.
+type DedupCache struct {
+	db    *bolt.DB
+	bloom *bloomFilter
+}
+
+// OpenDedupCache opens or creates the cache database at path.
+func OpenDedupCache(path string) (*DedupCache, error) {
+	db, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error opening dedup cache: %s", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(dedupCacheBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error opening dedup cache: %s", err)
+	}
+	return &DedupCache{db: db}, nil
+}
+
+// LoadBloomFilter loads every id in the cache into an in memory Bloom filter
+// with the given false positive rate, so Get can report most chunks that
+// are not cached without a disk lookup. The filter is sized for twice the
+// ids currently cached, the false positive rate rises if a run adds more.
+func (c *DedupCache) LoadBloomFilter(falsePositiveRate float64) error {
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return fmt.Errorf("bloom filter false positive rate must be between 0 and 1")
+	}
+	return c.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(dedupCacheBucket)
+		bloom := newBloomFilter(2*bucket.Stats().KeyN, falsePositiveRate)
+		err := bucket.ForEach(func(k, v []byte) error {
+			bloom.add(string(k))
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error reading dedup cache: %s", err)
+		}
+		c.bloom = bloom
+		return nil
+	})
+}
+
+// Get returns the cached output of the chunk with the given id.
+func (c *DedupCache) Get(id string) ([]byte, bool, error) {
+	if c.bloom != nil && !c.bloom.mayContain(id) {
+		return nil, false, nil
+	}
+	var output []byte
+	ok := false
+	err := c.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(dedupCacheBucket).Get([]byte(id))
+		if v != nil {
+			// v is only valid during the transaction.
+			output = bytes.Clone(v)
+			ok = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading dedup cache: %s", err)
+	}
+	return output, ok, nil
+}
+
+// Put records the output of the chunk with the given id.
+func (c *DedupCache) Put(id string, output []byte) error {
+	// Batch combines concurrent puts into one transaction and sync.
+	err := c.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(dedupCacheBucket).Put([]byte(id), output)
+	})
+	if err != nil {
+		return fmt.Errorf("error writing dedup cache: %s", err)
+	}
+	if c.bloom != nil {
+		c.bloom.add(id)
+	}
+	return nil
+}
+
+// Close closes the cache database.
+func (c *DedupCache) Close() error {
+	return c.db.Close()
+}
+
+// Processor returns a Processor that writes the cached output of chunks
+// whose hash is in the cache instead of running p, and caches the output
+// of p for the others. The cached output is repeated verbatim, so it suits
+// processors whose output only depends on the chunk data.
+func (c *DedupCache) Processor(hashName string, p Processor) (Processor, error) {
+	return c.processor(hashName, p, func(id string, output []byte, chunk Chunk, out io.Writer) error {
+		_, err := out.Write(output)
+		if err != nil {
+			return fmt.Errorf("error writing chunk output: %s", err)
+		}
+		return nil
+	})
+}
+
+// ManifestProcessor is like Processor, for processors writing a "hash
+// size offset" line per chunk named by its hashName hash, such as
+// HashProcessor and BackendProcessor. The line of a cached chunk is
+// written for its own offset, as by SkipProcessor, rather than repeated.
+func (c *DedupCache) ManifestProcessor(hashName string, p Processor) (Processor, error) {
+	return c.processor(hashName, p, func(id string, output []byte, chunk Chunk, out io.Writer) error {
+		err := writeManifestLine(out, id, chunk)
+		if err != nil {
+			return fmt.Errorf("error writing manifest line: %s", err)
+		}
+		return nil
+	})
+}
+
+// processor returns a Processor running p for chunks not in the cache,
+// and calling replay with the cached output of the others.
+func (c *DedupCache) processor(hashName string, p Processor, replay func(id string, output []byte, chunk Chunk, out io.Writer) error) (Processor, error) {
+	_, err := NewHash(hashName)
+	if err != nil {
+		return nil, err
+	}
+
+	return ProcessorFunc(func(chunk Chunk, out io.Writer) error {
+		h, _ := NewHash(hashName)
+		h.Write(chunk.Data)
+		id := hex.EncodeToString(h.Sum(nil))
+
+		output, ok, err := c.Get(id)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return replay(id, output, chunk, out)
+		}
+
+		var buf bytes.Buffer
+		err = p.Process(chunk, &buf)
+		if err != nil {
+			out.Write(buf.Bytes())
+			return err
+		}
+		err = c.Put(id, buf.Bytes())
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(buf.Bytes())
+		if err != nil {
+			return fmt.Errorf("error writing chunk output: %s", err)
+		}
+		return nil
+	}), nil
+}
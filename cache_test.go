@@ -0,0 +1,50 @@
+package cchunker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestDedupCacheManifestOffsets(t *testing.T) {
+	cache, err := OpenDedupCache(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	hash, err := HashProcessor("sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := 0
+	p, err := cache.ManifestProcessor("sha256", ProcessorFunc(func(c Chunk, out io.Writer) error {
+		runs++
+		return hash.Process(c, out)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := testData(1000)
+	var out bytes.Buffer
+	for i, offset := range []uint64{0, 1000} {
+		err = p.Process(Chunk{Index: int64(i), Offset: offset, Data: data}, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs != 1 {
+		t.Fatalf("processor ran %d times for a repeated chunk", runs)
+	}
+
+	id, err := (&Store{Hash: "sha256"}).ID(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("%s 1000 0\n%s 1000 1000\n", id, id)
+	if out.String() != expected {
+		t.Fatalf("output %q, expected %q", out.String(), expected)
+	}
+}
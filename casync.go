@@ -0,0 +1,194 @@
+package cchunker
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The casync format constants, see casync's caformat.h.
+const (
+	caFormatIndex            = 0x96824d9c7b129ff9
+	caFormatTable            = 0xe75b9e112f17417d
+	caFormatTableTailMarker  = 0x4b4f050e5549ecd1
+	caFormatExcludeNoDump    = 0x8000000000000000
+	caFormatSHA512256        = 0x2000000000000000
+	caFormatIndexHeaderSize  = 48
+	caFormatTableHeaderSize  = 16
+	caFormatTableItemSize    = 40
+	caFormatTableTailSize    = 40
+	caFormatTableSizeUnknown = 0xffffffffffffffff
+)
+
+// WriteCaibxHeader writes the start of a casync .caibx blob index, chunks
+// cut with params are then written by CaibxProcessor and the index is
+// finished with WriteCaibxTail.
+func WriteCaibxHeader(out io.Writer, params Params) error {
+	hdr := []uint64{
+		caFormatIndexHeaderSize,
+		caFormatIndex,
+		caFormatExcludeNoDump | caFormatSHA512256,
+		uint64(params.MinSize),
+		uint64(1) << params.AverageBits,
+		uint64(params.MaxSize),
+		caFormatTableSizeUnknown,
+		caFormatTable,
+	}
+	return writeCaibxWords(out, hdr)
+}
+
+// WriteCaibxTail finishes a casync .caibx index of nChunks chunks.
+func WriteCaibxTail(out io.Writer, nChunks int64) error {
+	tableSize := caFormatTableHeaderSize + uint64(nChunks)*caFormatTableItemSize + caFormatTableTailSize
+	return writeCaibxWords(out, []uint64{0, 0, caFormatIndexHeaderSize, tableSize, caFormatTableTailMarker})
+}
+
+func writeCaibxWords(out io.Writer, words []uint64) error {
+	buf := make([]byte, 0, 8*len(words))
+	for _, w := range words {
+		buf = binary.LittleEndian.AppendUint64(buf, w)
+	}
+	_, err := out.Write(buf)
+	if err != nil {
+		return fmt.Errorf("error writing caibx index: %s", err)
+	}
+	return nil
+}
+
+// CaibxProcessor wraps p so that instead of the output of p, the casync
+// .caibx table item of each chunk is written, its end offset and
+// sha512-256 id. The chunks must be untransformed, as casync ids are of the
+// chunk data.
+func CaibxProcessor(p Processor) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		err := p.Process(c, io.Discard)
+		if err != nil {
+			return err
+		}
+		id := sha512.Sum512_256(c.Data)
+		item := binary.LittleEndian.AppendUint64(make([]byte, 0, caFormatTableItemSize), c.Offset+uint64(len(c.Data)))
+		item = append(item, id[:]...)
+		_, err = out.Write(item)
+		if err != nil {
+			return fmt.Errorf("error writing caibx index: %s", err)
+		}
+		return nil
+	})
+}
+
+func init() {
+	backends["castr"] = openCastrBackend
+}
+
+// castrBackend stores chunks in a local casync chunk store, each chunk
+// zstd compressed at abcd/abcd....cacnk, named by its sha512-256 id.
+type castrBackend struct {
+	dir  string
+	sync bool
+}
+
+// openCastrBackend opens castr:///path, or castr://relative/path. Chunks
+// are synced to disk unless the fsync query parameter is false.
+func openCastrBackend(u *url.URL) (Backend, error) {
+	dir := filepath.FromSlash(u.Host + u.Path)
+	if dir == "" {
+		return nil, fmt.Errorf("castr backend url %q has no path", u.String())
+	}
+
+	sync := true
+	if v := u.Query().Get("fsync"); v != "" {
+		var err error
+		sync, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fsync value %q in backend url", v)
+		}
+	}
+	return &castrBackend{dir: dir, sync: sync}, nil
+}
+
+func (b *castrBackend) path(id string) string {
+	if len(id) < 4 {
+		return filepath.Join(b.dir, id+".cacnk")
+	}
+	return filepath.Join(b.dir, id[0:4], id+".cacnk")
+}
+
+func (b *castrBackend) Has(id string) (bool, error) {
+	_, err := os.Stat(b.path(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// castrEncoder is shared by all puts, EncodeAll is safe for concurrent use.
+var castrEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+	return zstd.NewWriter(nil)
+})
+
+func (b *castrBackend) Put(id string, data []byte) error {
+	enc, err := castrEncoder()
+	if err != nil {
+		return fmt.Errorf("error creating zstd encoder: %s", err)
+	}
+	compressed := enc.EncodeAll(data, make([]byte, 0, len(data)/2))
+
+	p := b.path(id)
+	dir := filepath.Dir(p)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating chunk directory: %s", err)
+	}
+	// casync and desync skip files starting with a dot, so a partly
+	// written chunk is never picked up.
+	f, err := os.CreateTemp(dir, ".tmp-"+id+"-*")
+	if err != nil {
+		return fmt.Errorf("error creating chunk %s: %s", id, err)
+	}
+	_, err = f.Write(compressed)
+	if err == nil && b.sync {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err == nil && b.sync {
+		err = syncDir(dir)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("error writing chunk %s: %s", id, err)
+	}
+	return nil
+}
+
+func (b *castrBackend) Get(id string) ([]byte, error) {
+	compressed, err := os.ReadFile(b.path(id))
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstdDecoder()
+	if err != nil {
+		return nil, fmt.Errorf("error creating zstd decoder: %s", err)
+	}
+	data, err := dec.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing chunk %s: %s", id, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,39 @@
+package cchunker
+
+import (
+	"context"
+	"os/exec"
+)
+
+// Commander makes the commands run to process chunks, from the
+// command+arguments in args. The caller sets up the command's input and
+// output and starts it. Replacing the SystemCommander, e.g. with one
+// running a helper built into a test binary, lets the exec layer be tested
+// the same way on every system, without depending on the tools installed.
+type Commander interface {
+	Command(ctx context.Context, args []string) *exec.Cmd
+}
+
+// SystemCommander is the Commander running args as a command of the
+// system, the default.
+type SystemCommander struct{}
+
+// Command returns the command running args, killed if ctx is done before
+// it exits.
+func (SystemCommander) Command(ctx context.Context, args []string) *exec.Cmd {
+	return systemCommand(ctx, args)
+}
+
+// ShellCommand returns the command+arguments running the command line
+// line with the system shell, sh -c on unix and cmd.exe /S /C on windows.
+func ShellCommand(line string) []string {
+	return shellArgs(line)
+}
+
+// commander returns c, or the SystemCommander if c is nil.
+func commander(c Commander) Commander {
+	if c == nil {
+		return SystemCommander{}
+	}
+	return c
+}
@@ -0,0 +1,65 @@
+package cchunker
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressProcessor returns a Processor that compresses each chunk in
+// process before passing it to p, with Chunk.RawLength set to the
+// uncompressed length. spec is "zstd" or "zstd:LEVEL", where LEVEL is a zstd
+// compression level from 1 to 22, the default is 3.
+func CompressProcessor(spec string, p Processor) (Processor, error) {
+	name, levelArg, hasLevel := strings.Cut(spec, ":")
+	if name != "zstd" {
+		return nil, fmt.Errorf("unknown compression %q, expected zstd or zstd:LEVEL", spec)
+	}
+	level := 3
+	if hasLevel {
+		var err error
+		level, err = strconv.Atoi(levelArg)
+		if err != nil || level < 1 || level > 22 {
+			return nil, fmt.Errorf("invalid zstd compression level %q, expected 1 to 22", levelArg)
+		}
+	}
+
+	// EncodeAll is safe for concurrent use, so one encoder is shared
+	// by all chunks.
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		return nil, fmt.Errorf("error creating zstd encoder: %s", err)
+	}
+
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		if c.RawLength == 0 {
+			c.RawLength = len(c.Data)
+		}
+		c.Data = enc.EncodeAll(c.Data, make([]byte, 0, len(c.Data)/2))
+		return p.Process(c, out)
+	}), nil
+}
+
+var zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+	return zstd.NewReader(nil)
+})
+
+// decompressChunk decompresses chunk data written by CompressProcessor.
+func decompressChunk(data []byte, rawLength uint64) ([]byte, error) {
+	dec, err := zstdDecoder()
+	if err != nil {
+		return nil, fmt.Errorf("error creating zstd decoder: %s", err)
+	}
+	raw, err := dec.DecodeAll(data, make([]byte, 0, rawLength))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing chunk: %s", err)
+	}
+	if uint64(len(raw)) != rawLength {
+		return nil, fmt.Errorf("chunk decompressed to %d bytes, expected %d", len(raw), rawLength)
+	}
+	return raw, nil
+}
@@ -0,0 +1,65 @@
+package cchunker
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// The magic bytes starting each compressed format, used to detect it.
+var decompressMagic = []struct {
+	format string
+	magic  []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+// NewDecompressReader returns a reader of the decompressed data of r, as
+// chunking compressed data destroys dedup. format is one of gzip, zstd or
+// xz, none to pass r through, or auto to detect the format from the magic
+// bytes at the start of r, passing it through if there are none.
+func NewDecompressReader(r io.Reader, format string) (io.ReadCloser, error) {
+	if format == "auto" {
+		br := bufio.NewReader(r)
+		r = br
+		format = "none"
+		for _, m := range decompressMagic {
+			start, _ := br.Peek(len(m.magic))
+			if bytes.Equal(start, m.magic) {
+				format = m.format
+				break
+			}
+		}
+	}
+
+	switch format {
+	case "none", "":
+		return io.NopCloser(r), nil
+	case "gzip":
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading gzip input: %s", err)
+		}
+		return gr, nil
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading zstd input: %s", err)
+		}
+		return zr.IOReadCloser(), nil
+	case "xz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading xz input: %s", err)
+		}
+		return io.NopCloser(xr), nil
+	}
+	return nil, fmt.Errorf("unknown decompression %q, expected auto, gzip, zstd, xz or none", format)
+}
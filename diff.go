@@ -0,0 +1,102 @@
+package cchunker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ChunkCount is a number of distinct chunks and their total size.
+type ChunkCount struct {
+	Chunks int64
+	Bytes  uint64
+}
+
+// ManifestDiff compares the distinct chunks of two manifests.
+type ManifestDiff struct {
+	// Added are the chunks only in the new manifest.
+	Added ChunkCount
+	// Removed are the chunks only in the old manifest.
+	Removed ChunkCount
+	// Shared are the chunks in both manifests.
+	Shared ChunkCount
+	// LogicalBytes is the size of every chunk line in both manifests,
+	// including repeated chunks.
+	LogicalBytes uint64
+}
+
+// PhysicalBytes is the size of the distinct chunks in either manifest,
+// the space needed to store both.
+func (d ManifestDiff) PhysicalBytes() uint64 {
+	return d.Added.Bytes + d.Removed.Bytes + d.Shared.Bytes
+}
+
+// DedupRatio is LogicalBytes divided by PhysicalBytes.
+func (d ManifestDiff) DedupRatio() float64 {
+	if d.PhysicalBytes() == 0 {
+		return 1
+	}
+	return float64(d.LogicalBytes) / float64(d.PhysicalBytes())
+}
+
+// readManifestSizes returns the size of each distinct chunk in a
+// "hash size offset" manifest, and the total size of every line.
+func readManifestSizes(manifest io.Reader) (map[string]uint64, uint64, error) {
+	sizes := make(map[string]uint64)
+	total := uint64(0)
+	scanner := bufio.NewScanner(manifest)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, 0, fmt.Errorf("manifest line %d has no size", lineNo)
+		}
+		size, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("manifest line %d has an invalid size: %s", lineNo, err)
+		}
+		sizes[fields[0]] = size
+		total += size
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading manifest: %s", err)
+	}
+	return sizes, total, nil
+}
+
+// DiffManifests compares two "hash size offset" manifests.
+func DiffManifests(oldManifest, newManifest io.Reader) (ManifestDiff, error) {
+	oldSizes, oldTotal, err := readManifestSizes(oldManifest)
+	if err != nil {
+		return ManifestDiff{}, err
+	}
+	newSizes, newTotal, err := readManifestSizes(newManifest)
+	if err != nil {
+		return ManifestDiff{}, err
+	}
+
+	d := ManifestDiff{
+		LogicalBytes: oldTotal + newTotal,
+	}
+	for id, size := range newSizes {
+		if _, ok := oldSizes[id]; ok {
+			d.Shared.Chunks += 1
+			d.Shared.Bytes += size
+		} else {
+			d.Added.Chunks += 1
+			d.Added.Bytes += size
+		}
+	}
+	for id, size := range oldSizes {
+		if _, ok := newSizes[id]; !ok {
+			d.Removed.Chunks += 1
+			d.Removed.Bytes += size
+		}
+	}
+	return d, nil
+}
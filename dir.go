@@ -0,0 +1,73 @@
+package cchunker
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+)
+
+func init() {
+	backends["dir"] = openDirBackend
+}
+
+// dirBackend stores chunks in a local directory with the same layout as Store.
+type dirBackend struct {
+	store *Store
+}
+
+// openDirBackend opens dir:///path, or dir://relative/path. Chunks and
+// their directories are synced to disk unless the fsync query parameter is
+// false, e.g. dir:///var/chunks?fsync=false, and chunks smaller than the
+// pack-threshold query parameter are packed, see Store.PackThreshold.
+func openDirBackend(u *url.URL) (Backend, error) {
+	dir := filepath.FromSlash(u.Host + u.Path)
+	if dir == "" {
+		return nil, fmt.Errorf("dir backend url %q has no path", u.String())
+	}
+
+	sync := true
+	if v := u.Query().Get("fsync"); v != "" {
+		var err error
+		sync, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fsync value %q in backend url", v)
+		}
+	}
+
+	packThreshold := uint64(0)
+	if v := u.Query().Get("pack-threshold"); v != "" {
+		var err error
+		packThreshold, err = ParseSize(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pack-threshold value %q in backend url", v)
+		}
+	}
+
+	return &dirBackend{
+		store: &Store{
+			Dir:           dir,
+			Sync:          sync,
+			PackThreshold: int(packThreshold),
+		},
+	}, nil
+}
+
+func (b *dirBackend) Has(id string) (bool, error) {
+	return b.store.has(id)
+}
+
+func (b *dirBackend) Put(id string, data []byte) error {
+	if len(data) < b.store.PackThreshold {
+		return b.store.putPacked(id, data)
+	}
+	return b.store.put(id, data)
+}
+
+func (b *dirBackend) Get(id string) ([]byte, error) {
+	return b.store.read(id)
+}
+
+func (b *dirBackend) Close() error {
+	return b.store.Close()
+}
@@ -0,0 +1,181 @@
+package cchunker
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Formats of the plaintext of an encrypted chunk, the format byte is
+// authenticated so a chunk can't be swapped between them.
+const (
+	encryptedRaw  = 0
+	encryptedZstd = 1
+)
+
+// chunkKeys derives independent subkeys from the 256 bit key with HKDF,
+// one sealing chunks and one deriving convergent nonces, so the key is
+// never used for both.
+func chunkKeys(key []byte) (encryption, nonce []byte, err error) {
+	if len(key) != 32 {
+		return nil, nil, fmt.Errorf("invalid encryption key: must be 32 bytes, not %d", len(key))
+	}
+	encryption = make([]byte, 32)
+	nonce = make([]byte, 32)
+	io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("cchunker chunk encryption")), encryption)
+	io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("cchunker convergent nonce")), nonce)
+	return encryption, nonce, nil
+}
+
+// convergentNonce derives the nonce of a chunk from its contents.
+func convergentNonce(nonceKey, data, nonce []byte) {
+	mac := hmac.New(sha256.New, nonceKey)
+	mac.Write(data)
+	copy(nonce, mac.Sum(nil))
+}
+
+// ReadKeyFile reads a 256 bit key written as 64 hex characters,
+// such as the output of 'openssl rand -hex 32', from source. The source is
+// a file path, fd:N to read the key from the inherited file descriptor N,
+// or cmd:COMMAND to run the shell command COMMAND and read the key from
+// its output, e.g. to fetch it from a keyring or have a KMS decrypt it,
+// so keys never need to be on the command line.
+func ReadKeyFile(source string) ([]byte, error) {
+	buf, err := readKeySource(source)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %s", err)
+	}
+	key, err := ParseKey(buf)
+	if err != nil {
+		return nil, fmt.Errorf("key file %s must contain 64 hex characters", source)
+	}
+	return key, nil
+}
+
+// ParseKey parses a 256 bit key written as 64 hex characters, ignoring
+// surrounding whitespace.
+func ParseKey(text []byte) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(string(text)))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("key must be 64 hex characters")
+	}
+	return key, nil
+}
+
+// readKeySource reads the contents of the key source of ReadKeyFile.
+func readKeySource(source string) ([]byte, error) {
+	if fd, ok := strings.CutPrefix(source, "fd:"); ok {
+		n, err := strconv.ParseUint(fd, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file descriptor %q", fd)
+		}
+		f := os.NewFile(uintptr(n), "fd:"+fd)
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+	if line, ok := strings.CutPrefix(source, "cmd:"); ok {
+		args := ShellCommand(line)
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("key command failed: %s", err)
+		}
+		return out, nil
+	}
+	return os.ReadFile(source)
+}
+
+// EncryptProcessor returns a Processor that encrypts each chunk in process
+// with AES-256-GCM before passing it to p, with Chunk.RawLength set to the
+// plaintext length. An encrypted chunk is a format byte, a 12 byte nonce and
+// the sealed data.
+//
+// If convergent is true the nonce is derived from key and the chunk
+// contents, so identical chunks encrypt identically and still deduplicate,
+// at the cost of revealing which chunks are equal to anyone with the
+// ciphertext. The chunks are sealed and the nonces derived with separate
+// subkeys of key.
+func EncryptProcessor(key []byte, convergent bool, p Processor) (Processor, error) {
+	encryptionKey, nonceKey, err := chunkKeys(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newChunkAEAD(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		// Any earlier transformation is compression.
+		format := byte(encryptedRaw)
+		if c.RawLength != 0 {
+			format = encryptedZstd
+		} else {
+			c.RawLength = len(c.Data)
+		}
+
+		sealed := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(c.Data)+aead.Overhead())
+		sealed[0] = format
+		nonce := sealed[1:]
+		if convergent {
+			convergentNonce(nonceKey, c.Data, nonce)
+		} else {
+			_, err := rand.Read(nonce)
+			if err != nil {
+				return fmt.Errorf("error generating nonce: %s", err)
+			}
+		}
+		c.Data = aead.Seal(sealed, nonce, c.Data, sealed[:1])
+		return p.Process(c, out)
+	}), nil
+}
+
+func newChunkAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %s", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// decryptChunk decrypts chunk data written by EncryptProcessor, reporting
+// if the plaintext is compressed.
+func decryptChunk(key []byte, data []byte) ([]byte, bool, error) {
+	if len(data) == 0 {
+		return nil, false, errors.New("encrypted chunk is truncated")
+	}
+	format := data[0]
+	if format != encryptedRaw && format != encryptedZstd {
+		return nil, false, fmt.Errorf("unknown encrypted chunk format %d", format)
+	}
+	key, _, err := chunkKeys(key)
+	if err != nil {
+		return nil, false, err
+	}
+	aead, err := newChunkAEAD(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) < 1+aead.NonceSize() {
+		return nil, false, errors.New("encrypted chunk is truncated")
+	}
+	nonce := data[1 : 1+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, data[1+aead.NonceSize():], data[:1])
+	if err != nil {
+		return nil, false, errors.New("chunk failed to decrypt, wrong key or corrupt chunk")
+	}
+	return plain, format == encryptedZstd, nil
+}
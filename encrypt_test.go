@@ -0,0 +1,101 @@
+package cchunker
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, 32)
+
+// encryptChunk returns data as encrypted by EncryptProcessor.
+func encryptChunk(t *testing.T, key []byte, convergent bool, data []byte) []byte {
+	t.Helper()
+	var sealed []byte
+	p, err := EncryptProcessor(key, convergent, ProcessorFunc(func(c Chunk, out io.Writer) error {
+		sealed = c.Data
+		if c.RawLength != len(data) {
+			t.Errorf("raw length %d, expected %d", c.RawLength, len(data))
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Process(Chunk{Data: append([]byte(nil), data...)}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	data := testData(10000)
+	for _, convergent := range []bool{false, true} {
+		sealed := encryptChunk(t, testKey, convergent, data)
+		plain, compressed, err := decryptChunk(testKey, sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compressed || !bytes.Equal(plain, data) {
+			t.Fatalf("convergent %v: decrypted chunk differs", convergent)
+		}
+
+		_, _, err = decryptChunk(bytes.Repeat([]byte{0x43}, 32), sealed)
+		if err == nil {
+			t.Fatal("decrypted with the wrong key")
+		}
+	}
+}
+
+func TestEncryptConvergentNonce(t *testing.T) {
+	data := testData(1000)
+	first := encryptChunk(t, testKey, true, data)
+	second := encryptChunk(t, testKey, true, data)
+	if !bytes.Equal(first, second) {
+		t.Fatal("convergent encryption of the same chunk differs")
+	}
+	other := encryptChunk(t, testKey, true, data[1:])
+	if bytes.Equal(first[1:13], other[1:13]) {
+		t.Fatal("different chunks have the same nonce")
+	}
+	random := encryptChunk(t, testKey, false, data)
+	if bytes.Equal(first, random) {
+		t.Fatal("random nonce encryption matches convergent encryption")
+	}
+
+	// The nonce is not derived from the key the chunks are sealed with.
+	mac := hmac.New(sha256.New, testKey)
+	mac.Write(data)
+	if bytes.Equal(first[1:13], mac.Sum(nil)[:12]) {
+		t.Fatal("nonce is derived with the encryption key")
+	}
+}
+
+func TestReadKeyFileSources(t *testing.T) {
+	text := hex.EncodeToString(testKey)
+	path := filepath.Join(t.TempDir(), "key")
+	err := os.WriteFile(path, []byte(text+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range []string{path, "cmd:echo " + text} {
+		key, err := ReadKeyFile(source)
+		if err != nil {
+			t.Fatalf("%s: %s", source, err)
+		}
+		if !bytes.Equal(key, testKey) {
+			t.Fatalf("%s: read the wrong key", source)
+		}
+	}
+
+	_, err = ReadKeyFile("cmd:echo 1234")
+	if err == nil {
+		t.Fatal("read a short key")
+	}
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package cchunker
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestReadKeyFileDescriptor(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.WriteString(hex.EncodeToString(testKey))
+	w.Close()
+	// ReadKeyFile closes the descriptor it reads, so give it a copy.
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ReadKeyFile(fmt.Sprintf("fd:%d", fd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, testKey) {
+		t.Fatal("read the wrong key")
+	}
+}
@@ -0,0 +1,301 @@
+package cchunker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExecOptions controls how ExecProcessor runs its command.
+type ExecOptions struct {
+	// Timeout, if not zero, is how long a command may run on a chunk
+	// before it is killed along with its process group.
+	Timeout time.Duration
+	// ChunkViaFile writes each chunk to a temporary file instead of the
+	// command's stdin, for commands needing a seekable input or a file
+	// name. The file is removed once the command exits.
+	ChunkViaFile bool
+	// ChunkDir is where chunk files are written, if empty /dev/shm is used
+	// when it exists, so chunks stay in memory, otherwise os.TempDir.
+	ChunkDir string
+	// ChunkOnFD sends the chunk data on file descriptor 3 of the command,
+	// with a ChunkMetadata line of JSON on its stdin. It is not supported
+	// on windows.
+	ChunkOnFD bool
+	// Commander makes the commands, the SystemCommander if nil.
+	Commander Commander
+}
+
+// ChunkMetadata describes a chunk to a command reading the chunk data from
+// file descriptor 3.
+type ChunkMetadata struct {
+	Index  int64  `json:"index"`
+	Offset uint64 `json:"offset"`
+	Length int    `json:"length"`
+	// Cut is the hex cut fingerprint, as in CCHUNK_CUT_FINGERPRINT.
+	Cut        string `json:"cut_fingerprint"`
+	RawLength  int    `json:"raw_length,omitempty"`
+	SequenceID string `json:"sequence_id,omitempty"`
+}
+
+// ExecProcessor returns a Processor that runs the command+arguments in args
+// once per chunk, with the chunk data on stdin. The command's stdout is the
+// chunk output, its stderr is passed through to os.Stderr.
+//
+// The placeholders {index}, {offset}, {size} and {sha256} in args are
+// replaced with the chunk index, byte offset, size and hex sha256 digest.
+// The same information is exported to the command environment as
+// CCHUNK_INDEX, CCHUNK_OFFSET, CCHUNK_LENGTH and CCHUNK_CUT_FINGERPRINT,
+// along with CCHUNK_RAW_LENGTH, which is zero unless the chunk is compressed,
+// and CCHUNK_SEQUENCE_ID, only set for chunks with a SequenceID, which is
+// also the {seqid} placeholder.
+//
+// With opts.ChunkViaFile, the chunk is instead in the file whose path
+// replaces the {file} placeholder, or is appended to args if there is no
+// such placeholder, and is in CCHUNK_FILE. With opts.ChunkOnFD, the chunk
+// is on file descriptor 3 and stdin is a line of JSON ChunkMetadata.
+//
+// Each command runs in its own process group, so a terminal interrupt is
+// left to the caller to handle, see KillProcessors. If opts.Timeout is not
+// zero, a command running longer than it is killed along with its process
+// group and the chunk fails.
+func ExecProcessor(args []string, opts ExecOptions) Processor {
+	timeout := opts.Timeout
+	commander := commander(opts.Commander)
+	chunkDir := opts.ChunkDir
+	if opts.ChunkViaFile && chunkDir == "" {
+		chunkDir = defaultChunkDir()
+	}
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		var chunkFile string
+		if opts.ChunkViaFile {
+			var err error
+			chunkFile, err = writeChunkFile(chunkDir, c.Data)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(chunkFile)
+		}
+		args := expandArgs(args, c, chunkFile)
+
+		ctx := context.Background()
+		if timeout != 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		cmd := commander.Command(ctx, args)
+		cmd.Env = append(os.Environ(), chunkEnv(c)...)
+		cmd.Stdout = out
+		cmd.Stderr = os.Stderr
+		var dataPipe *os.File
+		switch {
+		case chunkFile != "":
+			cmd.Env = append(cmd.Env, "CCHUNK_FILE="+chunkFile)
+		case opts.ChunkOnFD:
+			meta, err := json.Marshal(ChunkMetadata{
+				Index:      c.Index,
+				Offset:     c.Offset,
+				Length:     len(c.Data),
+				Cut:        fmt.Sprintf("%016x", c.Cut),
+				RawLength:  c.RawLength,
+				SequenceID: c.SequenceID,
+			})
+			if err != nil {
+				return fmt.Errorf("error encoding chunk metadata: %s", err)
+			}
+			r, w, err := os.Pipe()
+			if err != nil {
+				return fmt.Errorf("error creating chunk data pipe: %s", err)
+			}
+			defer r.Close()
+			defer w.Close()
+			cmd.ExtraFiles = []*os.File{r}
+			cmd.Stdin = bytes.NewReader(append(meta, '\n'))
+			dataPipe = w
+		default:
+			cmd.Stdin = bytes.NewReader(c.Data)
+		}
+		setProcessGroup(cmd)
+		if timeout != 0 {
+			cmd.Cancel = func() error {
+				return killProcessGroup(cmd)
+			}
+			// Don't wait forever on output pipes held open by
+			// processes that escaped the process group.
+			cmd.WaitDelay = time.Second
+		}
+
+		err := startCommand(cmd)
+		if err == nil {
+			var sent chan struct{}
+			if dataPipe != nil {
+				sent = sendChunkData(cmd, dataPipe, c.Data)
+			}
+			err = cmd.Wait()
+			commandDone(cmd)
+			if sent != nil {
+				// Unblock the write if the command exited without
+				// reading all the data, the chunk buffer is reused once
+				// the processor returns.
+				dataPipe.Close()
+				<-sent
+			}
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("chunk processing command timed out after %s", timeout)
+		}
+		if err != nil {
+			return fmt.Errorf("error running chunk processing command: %w", err)
+		}
+		return nil
+	})
+}
+
+// sendChunkData writes data to the pipe w whose read end was passed to the
+// started command cmd, closing w once it is written so the command sees the
+// end of the data. The returned channel is closed once the write finishes.
+func sendChunkData(cmd *exec.Cmd, w *os.File, data []byte) chan struct{} {
+	// Only the command should hold the read end open.
+	cmd.ExtraFiles[0].Close()
+	sent := make(chan struct{})
+	go func() {
+		// A command not reading all of the data is not an error, its
+		// exit status decides if the chunk failed.
+		w.Write(data)
+		w.Close()
+		close(sent)
+	}()
+	return sent
+}
+
+// chunkEnv returns the environment variables describing c.
+func chunkEnv(c Chunk) []string {
+	env := []string{
+		"CCHUNK_INDEX=" + strconv.FormatInt(c.Index, 10),
+		"CCHUNK_OFFSET=" + strconv.FormatUint(c.Offset, 10),
+		"CCHUNK_LENGTH=" + strconv.Itoa(len(c.Data)),
+		fmt.Sprintf("CCHUNK_CUT_FINGERPRINT=%016x", c.Cut),
+		"CCHUNK_RAW_LENGTH=" + strconv.Itoa(c.RawLength),
+	}
+	if c.SequenceID != "" {
+		env = append(env, "CCHUNK_SEQUENCE_ID="+c.SequenceID)
+	}
+	return env
+}
+
+// defaultChunkDir returns the directory chunk files are written to when
+// none is given, preferring a tmpfs.
+func defaultChunkDir() string {
+	info, err := os.Stat("/dev/shm")
+	if err == nil && info.IsDir() {
+		return "/dev/shm"
+	}
+	return os.TempDir()
+}
+
+// writeChunkFile writes data to a new temporary file in dir, returning
+// its path.
+func writeChunkFile(dir string, data []byte) (string, error) {
+	f, err := os.CreateTemp(dir, "cchunker-chunk-*")
+	if err != nil {
+		return "", fmt.Errorf("error creating chunk file: %s", err)
+	}
+	_, err = f.Write(data)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("error writing chunk file: %s", err)
+	}
+	return f.Name(), nil
+}
+
+// expandArgs returns args with chunk placeholders replaced. If chunkFile
+// is not empty it replaces {file}, or is appended without the placeholder.
+func expandArgs(args []string, c Chunk, chunkFile string) []string {
+	var replacer *strings.Replacer
+
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		if !strings.Contains(arg, "{") {
+			expanded[i] = arg
+			continue
+		}
+		if replacer == nil {
+			sha256Hex := ""
+			if hasPlaceholder(args, "{sha256}") {
+				sum := sha256.Sum256(c.Data)
+				sha256Hex = hex.EncodeToString(sum[:])
+			}
+			replacer = strings.NewReplacer(
+				"{index}", strconv.FormatInt(c.Index, 10),
+				"{offset}", strconv.FormatUint(c.Offset, 10),
+				"{size}", strconv.Itoa(len(c.Data)),
+				"{sha256}", sha256Hex,
+				"{file}", chunkFile,
+				"{seqid}", c.SequenceID,
+			)
+		}
+		expanded[i] = replacer.Replace(arg)
+	}
+	if chunkFile != "" && !hasPlaceholder(args, "{file}") {
+		expanded = append(expanded, chunkFile)
+	}
+	return expanded
+}
+
+func hasPlaceholder(args []string, placeholder string) bool {
+	for _, arg := range args {
+		if strings.Contains(arg, placeholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// running is the set of chunk processing commands currently running.
+var running = struct {
+	sync.Mutex
+	cmds map[*exec.Cmd]struct{}
+}{cmds: make(map[*exec.Cmd]struct{})}
+
+// startCommand starts cmd, tracking it until it is passed to commandDone.
+func startCommand(cmd *exec.Cmd) error {
+	running.Lock()
+	defer running.Unlock()
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	running.cmds[cmd] = struct{}{}
+	return nil
+}
+
+func commandDone(cmd *exec.Cmd) {
+	running.Lock()
+	defer running.Unlock()
+	delete(running.cmds, cmd)
+}
+
+// KillProcessors kills every chunk processing command still running, so
+// none are left behind by a process exiting early.
+func KillProcessors() {
+	running.Lock()
+	defer running.Unlock()
+	for cmd := range running.cmds {
+		killProcessGroup(cmd)
+	}
+}
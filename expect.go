@@ -0,0 +1,39 @@
+package cchunker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// maxQuotedOutput is how much of a rejected processor output is quoted in
+// the error.
+const maxQuotedOutput = 256
+
+// ExpectOutputProcessor returns a Processor that fails a chunk when the
+// output of p, without one trailing "\n" or "\r\n", doesn't match re. A processor
+// printing an error message or extra lines to stdout is then caught on the
+// chunk it happened on rather than poisoning the summary or manifest.
+// Only matching output is written.
+func ExpectOutputProcessor(re *regexp.Regexp, p Processor) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		var output bytes.Buffer
+		err := p.Process(c, &output)
+		if err != nil {
+			return err
+		}
+		if !re.Match(bytes.TrimSuffix(trimCR(output.Bytes()), []byte("\n"))) {
+			quoted := output.Bytes()
+			if len(quoted) > maxQuotedOutput {
+				quoted = quoted[:maxQuotedOutput]
+			}
+			return fmt.Errorf("processor output for chunk %d does not match %s: %q", c.Index, re, quoted)
+		}
+		_, err = out.Write(output.Bytes())
+		if err != nil {
+			return fmt.Errorf("error writing chunk output: %s", err)
+		}
+		return nil
+	})
+}
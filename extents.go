@@ -0,0 +1,46 @@
+package cchunker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ExtentsProcessor returns a Processor prefixing every record of the
+// output of p with "offset\tlength\t", the extent of the chunk in its
+// stream, so consumers can rebuild the extent map without trusting the
+// processor to echo it. Records are ended by sep, usually '\n', a last
+// record without one is prefixed too. The length is the size before any
+// compression.
+func ExtentsProcessor(p Processor, sep byte) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		var output bytes.Buffer
+		err := p.Process(c, &output)
+		if err != nil {
+			return err
+		}
+
+		length := len(c.Data)
+		if c.RawLength != 0 {
+			length = c.RawLength
+		}
+		prefix := fmt.Sprintf("%d\t%d\t", c.Offset, length)
+
+		var prefixed bytes.Buffer
+		rest := output.Bytes()
+		for len(rest) != 0 {
+			record, after, found := bytes.Cut(rest, []byte{sep})
+			prefixed.WriteString(prefix)
+			prefixed.Write(record)
+			if found {
+				prefixed.WriteByte(sep)
+			}
+			rest = after
+		}
+		_, err = out.Write(prefixed.Bytes())
+		if err != nil {
+			return fmt.Errorf("error writing chunk output: %s", err)
+		}
+		return nil
+	})
+}
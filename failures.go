@@ -0,0 +1,66 @@
+package cchunker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// FailureRecord describes a chunk a processor failed on, so the region
+// of the input can be processed again later.
+type FailureRecord struct {
+	Index  int64  `json:"index"`
+	Offset uint64 `json:"offset"`
+	Length int    `json:"length"`
+	Error  string `json:"error"`
+}
+
+// FailureLog lets a run continue past chunks the processor fails on,
+// writing a FailureRecord for each of them as a line of JSON.
+type FailureLog struct {
+	mu    sync.Mutex
+	w     io.Writer
+	count int64
+}
+
+// NewFailureLog returns a FailureLog writing records to w.
+func NewFailureLog(w io.Writer) *FailureLog {
+	return &FailureLog{w: w}
+}
+
+// Processor returns a Processor that runs p, recording any failure
+// instead of returning it. Any output p wrote for the failed chunk is kept.
+func (f *FailureLog) Processor(p Processor) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		err := p.Process(c, out)
+		if err == nil {
+			return nil
+		}
+		line, jsonErr := json.Marshal(FailureRecord{
+			Index:  c.Index,
+			Offset: c.Offset,
+			Length: len(c.Data),
+			Error:  err.Error(),
+		})
+		if jsonErr != nil {
+			return fmt.Errorf("error encoding failure record: %s", jsonErr)
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.count += 1
+		_, writeErr := f.w.Write(append(line, '\n'))
+		if writeErr != nil {
+			return fmt.Errorf("error writing failure record: %s", writeErr)
+		}
+		return nil
+	})
+}
+
+// Count returns the number of failed chunks recorded so far.
+func (f *FailureLog) Count() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.count
+}
@@ -0,0 +1,38 @@
+package cchunker
+
+import (
+	"io"
+
+	"github.com/restic/chunker"
+)
+
+// fixedChunker cuts chunks of exactly MaxSize bytes, except for the last
+// chunk which may be shorter.
+type fixedChunker struct {
+	rd     io.Reader
+	size   int
+	offset uint
+}
+
+func (c *fixedChunker) Next(buf []byte) (chunker.Chunk, error) {
+	if cap(buf) < c.size {
+		buf = make([]byte, c.size)
+	}
+	buf = buf[:c.size]
+
+	n, err := io.ReadFull(c.rd, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if err != nil {
+		return chunker.Chunk{}, err
+	}
+
+	chunk := chunker.Chunk{
+		Start:  c.offset,
+		Length: uint(n),
+		Data:   buf[:n],
+	}
+	c.offset += uint(n)
+	return chunk, nil
+}
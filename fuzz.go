@@ -0,0 +1,166 @@
+package cchunker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// fuzzMaxSize bounds the max chunk size of FuzzParams, so random inputs
+// of a few MiB still cut many chunks.
+const fuzzMaxSize = 256 * kiB
+
+// FuzzParams returns random valid chunk parameters of every plain
+// algorithm, picked by seed, for checking invariants over many
+// parameters.
+func FuzzParams(seed uint64) Params {
+	state := seed
+	next := func(n int) int {
+		state += splitmixGamma
+		return int(splitmix64(state) % uint64(n))
+	}
+
+	minSize := uint(1 + next(64*kiB))
+	maxSize := minSize + uint(next(fuzzMaxSize-int(minSize)))
+	p := Params{
+		Polynomial:  DefaultPolynomial,
+		MinSize:     minSize,
+		MaxSize:     maxSize,
+		AverageBits: 4 + next(14),
+	}
+	switch next(4) {
+	case 0:
+		p.Algorithm = "rabin"
+	case 1:
+		p.Algorithm = "buzhash"
+		p.WindowSize = 1 + next(4096)
+		p.Seed = uint32(next(1 << 24))
+		if uint(p.WindowSize)+p.MinSize+1 > p.MaxSize {
+			p.MaxSize = uint(p.WindowSize) + p.MinSize + 1
+		}
+	case 2:
+		p.Algorithm = "fixed"
+		p.MinSize = p.MaxSize
+	case 3:
+		p.Key = []byte(fmt.Sprintf("fuzz key %d", seed))
+	}
+	if p.Algorithm == "" || p.Algorithm == "rabin" {
+		p.MinSize = max(p.MinSize, rabinWindowSize)
+		p.MaxSize = max(p.MaxSize, p.MinSize)
+	}
+	return p
+}
+
+// fuzzChunk is a chunk found by CheckInvariants.
+type fuzzChunk struct {
+	start, length uint
+	cut           uint64
+}
+
+// CheckInvariants chunks data with params, returning an error describing
+// the first broken invariant of the chunker:
+//
+//   - every chunk but the last is between the min and max size, the last
+//     is at most the max size, and fixed chunks are exactly the max size
+//   - the chunks are contiguous and their concatenation is the input
+//   - chunking is deterministic
+//   - once chunking data with prefix prepended cuts at a boundary of data,
+//     every later boundary is the same, so a prefix insertion only
+//     changes the chunks before the chunkers resynchronize
+//
+// Params that modify the input, such as TarAware, AnchorDelim and
+// SkipHoles, are not checked.
+func CheckInvariants(data []byte, prefix []byte, params Params) error {
+	err := params.Validate()
+	if err != nil {
+		return err
+	}
+	chunks, err := fuzzChunks(data, params, true)
+	if err != nil {
+		return err
+	}
+	again, err := fuzzChunks(data, params, false)
+	if err != nil {
+		return err
+	}
+	if len(again) != len(chunks) {
+		return fmt.Errorf("chunking the same data twice cut %d then %d chunks", len(chunks), len(again))
+	}
+	for i := range chunks {
+		if chunks[i] != again[i] {
+			return fmt.Errorf("chunking the same data twice cut chunk %d differently, %+v then %+v", i, chunks[i], again[i])
+		}
+	}
+
+	for i, c := range chunks {
+		last := i == len(chunks)-1
+		switch {
+		case c.length == 0:
+			return fmt.Errorf("chunk %d at %d is empty", i, c.start)
+		case c.length > params.MaxSize:
+			return fmt.Errorf("chunk %d at %d has size %d, over the max size %d", i, c.start, c.length, params.MaxSize)
+		case !last && c.length < params.MinSize:
+			return fmt.Errorf("chunk %d at %d has size %d, under the min size %d", i, c.start, c.length, params.MinSize)
+		case !last && params.Algorithm == "fixed" && c.length != params.MaxSize:
+			return fmt.Errorf("fixed chunk %d at %d has size %d, not %d", i, c.start, c.length, params.MaxSize)
+		}
+	}
+
+	// Boundaries are compared as the end offsets of chunks in data.
+	ends := make(map[uint]bool, len(chunks))
+	for _, c := range chunks {
+		ends[c.start+c.length] = true
+	}
+	prefixed, err := fuzzChunks(append(append([]byte(nil), prefix...), data...), params, true)
+	if err != nil {
+		return err
+	}
+	aligned := false
+	for _, c := range prefixed {
+		end := c.start + c.length
+		if end <= uint(len(prefix)) {
+			continue
+		}
+		end -= uint(len(prefix))
+		if aligned && !ends[end] {
+			return fmt.Errorf("with a %d byte prefix, the chunkers cut at the same boundary then the prefixed data was cut at %d, which isn't a boundary of the data", len(prefix), end)
+		}
+		aligned = aligned || ends[end]
+	}
+	return nil
+}
+
+// fuzzChunks chunks data with params, checking the chunks are contiguous
+// and reassemble data if check is set.
+func fuzzChunks(data []byte, params Params, check bool) ([]fuzzChunk, error) {
+	buf := make([]byte, params.MaxSize)
+	splitter := params.newChunker(bytes.NewReader(data))
+	var chunks []fuzzChunk
+	offset := uint(0)
+	for {
+		c, err := splitter.Next(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error chunking: %s", err)
+		}
+		if check {
+			if c.Start != offset {
+				return nil, fmt.Errorf("chunk %d starts at %d, the previous chunk ended at %d", len(chunks), c.Start, offset)
+			}
+			if c.Length != uint(len(c.Data)) {
+				return nil, fmt.Errorf("chunk %d at %d has length %d but %d bytes of data", len(chunks), c.Start, c.Length, len(c.Data))
+			}
+			if offset+c.Length > uint(len(data)) || !bytes.Equal(c.Data, data[offset:offset+c.Length]) {
+				return nil, fmt.Errorf("chunk %d at %d doesn't match the input data", len(chunks), c.Start)
+			}
+		}
+		chunks = append(chunks, fuzzChunk{start: c.Start, length: c.Length, cut: c.Cut})
+		offset += c.Length
+	}
+	if check && offset != uint(len(data)) {
+		return nil, fmt.Errorf("the chunks end at %d, the input is %d bytes", offset, len(data))
+	}
+	return chunks, nil
+}
@@ -0,0 +1,21 @@
+//go:build gofuzz
+
+package cchunker
+
+import "encoding/binary"
+
+// Fuzz is the go-fuzz target for CheckInvariants, the first 8 bytes of
+// data pick the params and the length of the prefix taken from the rest.
+func Fuzz(data []byte) int {
+	if len(data) < 10 {
+		return -1
+	}
+	seed := binary.LittleEndian.Uint64(data)
+	data = data[8:]
+	n := int(seed>>48) % len(data)
+	err := CheckInvariants(data[n:], data[:n], FuzzParams(seed))
+	if err != nil {
+		panic(err)
+	}
+	return 1
+}
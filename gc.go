@@ -0,0 +1,183 @@
+package cchunker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GCOptions controls Store.GC.
+type GCOptions struct {
+	// DryRun only reports the chunks that would be removed.
+	DryRun bool
+	// Grace keeps files modified more recently than this, so chunks just
+	// written by a run whose manifest isn't finished yet are not removed.
+	// Store.Put refreshes the modification time of chunks already
+	// present, so those a run deduplicated against are kept too, but a
+	// run taking longer than Grace can still lose its earliest chunks.
+	Grace time.Duration
+}
+
+// GCResult counts the files removed by Store.GC, or that would be with
+// GCOptions.DryRun.
+type GCResult struct {
+	Chunks int64
+	Bytes  int64
+	// Kept is the number of unreferenced chunks kept for being within
+	// the grace period.
+	Kept int64
+}
+
+// ReadManifestRefs returns the set of chunk ids referenced by all of
+// manifests, including parity chunks, which are parsed strictly, so a file that isn't a manifest
+// fails rather than referencing nothing.
+func ReadManifestRefs(manifests ...io.Reader) (map[string]bool, error) {
+	refs := make(map[string]bool)
+	for _, manifest := range manifests {
+		data, err := io.ReadAll(manifest)
+		if err != nil {
+			return nil, fmt.Errorf("error reading manifest: %s", err)
+		}
+		sections, err := ReadManifest(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		for _, section := range sections {
+			for _, c := range section.Chunks {
+				refs[c.ID] = true
+			}
+		}
+		groups, err := readParityGroups(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			for _, c := range g.parity {
+				refs[c.ID] = true
+			}
+		}
+	}
+	return refs, nil
+}
+
+// GC removes the chunks of the store not in refs, along with temporary
+// files left by interrupted writes, writing the id of each removed chunk
+// as a line to report. Pack files are append only, so one is only removed
+// once none of its chunks are referenced.
+func (s *Store) GC(refs map[string]bool, opts GCOptions, report io.Writer) (GCResult, error) {
+	var result GCResult
+	cutoff := time.Now().Add(-opts.Grace)
+	err := filepath.WalkDir(s.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == s.packDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := d.Name()
+		temporary := strings.HasPrefix(name, ".tmp-")
+		// Only files at their sharded path are chunks, anything else in
+		// the directory is left alone.
+		if !temporary && s.Path(name) != path {
+			return nil
+		}
+		if !temporary && refs[name] {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(cutoff) {
+			if !temporary {
+				result.Kept += 1
+			}
+			return nil
+		}
+		if !opts.DryRun {
+			err = os.Remove(path)
+			if err != nil {
+				return err
+			}
+		}
+		if temporary {
+			return nil
+		}
+		result.Chunks += 1
+		result.Bytes += info.Size()
+		_, err = fmt.Fprintln(report, name)
+		if err != nil {
+			return fmt.Errorf("error writing gc report: %s", err)
+		}
+		return nil
+	})
+	if err == nil {
+		err = s.gcPacks(refs, opts, cutoff, &result, report)
+	}
+	if err != nil {
+		return result, fmt.Errorf("error collecting garbage: %s", err)
+	}
+	return result, nil
+}
+
+// gcPacks removes the pack files with no chunks in refs.
+func (s *Store) gcPacks(refs map[string]bool, opts GCOptions, cutoff time.Time, result *GCResult, report io.Writer) error {
+	packed, err := s.packedChunks()
+	if err != nil {
+		return err
+	}
+	// Report the packs in a stable order.
+	packs := make([]string, 0, len(packed))
+	for pack := range packed {
+		packs = append(packs, pack)
+	}
+	sort.Strings(packs)
+
+	for _, pack := range packs {
+		ids := packed[pack]
+		referenced := false
+		for _, id := range ids {
+			referenced = referenced || refs[id]
+		}
+		if referenced {
+			continue
+		}
+		info, err := os.Stat(pack)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(cutoff) {
+			result.Kept += int64(len(ids))
+			continue
+		}
+		if !opts.DryRun {
+			err = os.Remove(strings.TrimSuffix(pack, ".pack") + ".idx")
+			if err == nil {
+				err = os.Remove(pack)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			_, err = fmt.Fprintln(report, id)
+			if err != nil {
+				return fmt.Errorf("error writing gc report: %s", err)
+			}
+		}
+		result.Chunks += int64(len(ids))
+		result.Bytes += info.Size()
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package cchunker
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ageFiles sets the modification time of every file in dir to d ago.
+func ageFiles(t *testing.T, dir string, d time.Duration) {
+	t.Helper()
+	old := time.Now().Add(-d)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		return os.Chtimes(path, old, old)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGCKeepsDeduplicatedChunks(t *testing.T) {
+	for _, threshold := range []int{0, 10000} {
+		s := &Store{Dir: t.TempDir(), Hash: "sha256", PackThreshold: threshold}
+		data := testData(1000)
+		_, err := s.Put(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ageFiles(t, s.Dir, 2*time.Hour)
+
+		// A new run deduplicates against the old chunk, and has not
+		// written its manifest yet when gc runs.
+		_, err = s.Put(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.GC(nil, GCOptions{Grace: time.Hour}, &bytes.Buffer{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Chunks != 0 || res.Kept != 1 {
+			t.Fatalf("pack threshold %d: gc removed %d chunks and kept %d, expected to keep the deduplicated chunk", threshold, res.Chunks, res.Kept)
+		}
+
+		ageFiles(t, s.Dir, 2*time.Hour)
+		res, err = s.GC(nil, GCOptions{Grace: time.Hour}, &bytes.Buffer{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Chunks != 1 {
+			t.Fatalf("pack threshold %d: gc removed %d chunks, expected the unreferenced chunk", threshold, res.Chunks)
+		}
+	}
+}
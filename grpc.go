@@ -0,0 +1,226 @@
+package cchunker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// GRPCProcessor is a Processor that sends chunks to a gRPC service
+// implementing ProcessChunk from proto/processor.proto.
+//
+// All chunks are sent over a single stream, results may come back in any
+// order and are matched to their chunk by index, so Process is safe for
+// concurrent use.
+type GRPCProcessor struct {
+	conn   *grpc.ClientConn
+	stream grpc.ClientStream
+
+	sendMu sync.Mutex
+
+	mu       sync.Mutex
+	waiting  map[int64]chan grpcResult
+	recvErr  error
+	recvDone chan struct{}
+}
+
+// DialGRPCProcessor connects to the chunk processor service at address and
+// opens the ProcessChunk stream.
+func DialGRPCProcessor(address string) (*GRPCProcessor, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to chunk processor: %s", err)
+	}
+
+	stream, err := conn.NewStream(context.Background(), &grpc.StreamDesc{
+		StreamName:    "ProcessChunk",
+		ServerStreams: true,
+		ClientStreams: true,
+	}, "/cchunker.ChunkProcessor/ProcessChunk", grpc.ForceCodec(protoCodec{}))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error opening chunk processor stream: %s", err)
+	}
+
+	p := &GRPCProcessor{
+		conn:     conn,
+		stream:   stream,
+		waiting:  make(map[int64]chan grpcResult),
+		recvDone: make(chan struct{}),
+	}
+	go p.receive()
+	return p, nil
+}
+
+func (p *GRPCProcessor) receive() {
+	defer close(p.recvDone)
+	for {
+		var r grpcResult
+		err := p.stream.RecvMsg(&r)
+		if err != nil {
+			p.mu.Lock()
+			p.recvErr = err
+			for _, c := range p.waiting {
+				close(c)
+			}
+			p.waiting = nil
+			p.mu.Unlock()
+			return
+		}
+
+		p.mu.Lock()
+		c, ok := p.waiting[r.Index]
+		delete(p.waiting, r.Index)
+		p.mu.Unlock()
+		if ok {
+			c <- r
+		}
+	}
+}
+
+// Process sends c to the service and copies the result output to out.
+func (p *GRPCProcessor) Process(c Chunk, out io.Writer) error {
+	result := make(chan grpcResult, 1)
+
+	p.mu.Lock()
+	if p.waiting == nil {
+		err := p.recvErr
+		p.mu.Unlock()
+		return fmt.Errorf("error receiving chunk processor result: %s", err)
+	}
+	p.waiting[c.Index] = result
+	p.mu.Unlock()
+
+	p.sendMu.Lock()
+	err := p.stream.SendMsg(&c)
+	p.sendMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error sending chunk to processor: %s", err)
+	}
+
+	r, ok := <-result
+	if !ok {
+		p.mu.Lock()
+		err := p.recvErr
+		p.mu.Unlock()
+		if err == io.EOF {
+			return fmt.Errorf("chunk processor ended the stream without a result for chunk %d", c.Index)
+		}
+		return fmt.Errorf("error receiving chunk processor result: %s", err)
+	}
+	if r.Error != "" {
+		return fmt.Errorf("chunk processor failed: %s", r.Error)
+	}
+
+	_, err = out.Write(r.Output)
+	if err != nil {
+		return fmt.Errorf("error writing chunk output: %s", err)
+	}
+	return nil
+}
+
+// Close ends the stream, waiting for the service to finish it.
+func (p *GRPCProcessor) Close() error {
+	err := p.stream.CloseSend()
+	if err == nil {
+		<-p.recvDone
+		if p.recvErr != io.EOF {
+			err = p.recvErr
+		}
+	}
+	if err2 := p.conn.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return fmt.Errorf("error closing chunk processor stream: %s", err)
+	}
+	return nil
+}
+
+type grpcResult struct {
+	Index  int64
+	Output []byte
+	Error  string
+}
+
+// protoCodec encodes the messages of proto/processor.proto in protobuf
+// wire format, so services generated from it interoperate.
+type protoCodec struct{}
+
+func (protoCodec) Name() string {
+	return "proto"
+}
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	c, ok := v.(*Chunk)
+	if !ok {
+		return nil, fmt.Errorf("unable to marshal %T", v)
+	}
+	b := make([]byte, 0, len(c.Data)+32)
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(c.Index))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, c.Offset)
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	b = protowire.AppendVarint(b, c.Cut)
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	b = protowire.AppendBytes(b, c.Data)
+	if c.RawLength != 0 {
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(c.RawLength))
+	}
+	if c.SequenceID != "" {
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendString(b, c.SequenceID)
+	}
+	return b, nil
+}
+
+func (protoCodec) Unmarshal(b []byte, v interface{}) error {
+	r, ok := v.(*grpcResult)
+	if !ok {
+		return fmt.Errorf("unable to unmarshal %T", v)
+	}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			x, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			r.Index = int64(x)
+			b = b[n:]
+		case num == 2 && typ == protowire.BytesType:
+			x, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			r.Output = append([]byte(nil), x...)
+			b = b[n:]
+		case num == 3 && typ == protowire.BytesType:
+			x, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			r.Error = string(x)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package cchunker
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"lukechampine.com/blake3"
+)
+
+// HashNames lists the supported built-in chunk hashes.
+var HashNames = []string{"sha256", "blake3", "sha512-256"}
+
+// NewHash returns a new hash.Hash for one of HashNames.
+func NewHash(name string) (hash.Hash, error) {
+	switch name {
+	case "sha256":
+		return sha256.New(), nil
+	case "blake3":
+		return blake3.New(32, nil), nil
+	case "sha512-256":
+		// The chunk hash of casync.
+		return sha512.New512_256(), nil
+	default:
+		return nil, fmt.Errorf("unknown hash %q", name)
+	}
+}
+
+// HashProcessor returns a Processor that hashes each chunk in process,
+// writing a "hash size offset" line per chunk.
+func HashProcessor(name string) (Processor, error) {
+	// Check the name up front, the processor may be used concurrently
+	// so a new hash is created per chunk.
+	_, err := NewHash(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		h, _ := NewHash(name)
+		h.Write(c.Data)
+		err := writeManifestLine(out, hex.EncodeToString(h.Sum(nil)), c)
+		if err != nil {
+			return fmt.Errorf("error writing chunk hash: %s", err)
+		}
+		return nil
+	}), nil
+}
+
+// BoundariesProcessor returns a Processor that only writes an
+// "offset length cut-fingerprint" line per chunk, so chunking parameters
+// can be tried out without running anything.
+func BoundariesProcessor() Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		_, err := fmt.Fprintf(out, "%d %d %016x\n", c.Offset, len(c.Data), c.Cut)
+		if err != nil {
+			return fmt.Errorf("error writing chunk boundary: %s", err)
+		}
+		return nil
+	})
+}
+
+// FramedProcessor returns a Processor that writes each chunk itself as a
+// big endian uint64 length followed by the chunk data, the same framing
+// as a PersistentProcessor is sent, so another program can read the chunks
+// from a single pipe.
+func FramedProcessor() Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		var hdr [8]byte
+		binary.BigEndian.PutUint64(hdr[:], uint64(len(c.Data)))
+		_, err := out.Write(hdr[:])
+		if err == nil {
+			_, err = out.Write(c.Data)
+		}
+		if err != nil {
+			return fmt.Errorf("error writing framed chunk: %s", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,63 @@
+package cchunker
+
+import (
+	"io"
+	"math/bits"
+	"sync"
+)
+
+// HistogramBucket counts the chunks with sizes in [Min, Max).
+type HistogramBucket struct {
+	Min   uint64 `json:"min"`
+	Max   uint64 `json:"max"`
+	Count int64  `json:"count"`
+}
+
+// SizeHistogram counts the sizes of the chunks passing through a Processor
+// in power of two buckets.
+type SizeHistogram struct {
+	mu     sync.Mutex
+	counts [65]int64
+}
+
+// NewSizeHistogram returns an empty SizeHistogram.
+func NewSizeHistogram() *SizeHistogram {
+	return &SizeHistogram{}
+}
+
+// Processor returns a Processor that runs p, counting each chunk.
+func (h *SizeHistogram) Processor(p Processor) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		h.mu.Lock()
+		h.counts[bits.Len64(uint64(len(c.Data)))] += 1
+		h.mu.Unlock()
+		return p.Process(c, out)
+	})
+}
+
+// Buckets returns the buckets from the smallest to the largest non empty
+// one.
+func (h *SizeHistogram) Buckets() []HistogramBucket {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	first, last := -1, -1
+	for i, n := range h.counts {
+		if n != 0 {
+			if first == -1 {
+				first = i
+			}
+			last = i
+		}
+	}
+	var buckets []HistogramBucket
+	for i := first; first != -1 && i <= last; i += 1 {
+		b := HistogramBucket{Count: h.counts[i]}
+		// Bucket i holds sizes needing exactly i bits.
+		if i != 0 {
+			b.Min = 1 << (i - 1)
+		}
+		b.Max = 1 << i
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
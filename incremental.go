@@ -0,0 +1,61 @@
+package cchunker
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ReadManifestIDs returns the set of chunk ids, the first field of each
+// line, in a manifest. Lines starting with '#' are skipped.
+func ReadManifestIDs(manifest io.Reader) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	scanner := bufio.NewScanner(manifest)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		ids[fields[0]] = true
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %s", err)
+	}
+	return ids, nil
+}
+
+// IncrementalProcessor returns a Processor that only runs p for chunks
+// whose hash is not in previous, for the others a "hash size offset" line
+// is written instead. It is intended for processors that also write
+// "hash size offset" lines, so the output is the complete new manifest.
+func IncrementalProcessor(previous map[string]bool, hashName string, p Processor) (Processor, error) {
+	return SkipProcessor(idSet(previous), hashName, p)
+}
+
+// SkipProcessor returns a Processor that only runs p for chunks whose hash
+// is not in skip, for the others a "hash size offset" line is written as a
+// placeholder, as by IncrementalProcessor. If skip is a Bloom filter, a
+// false positive skips a chunk that should have been processed.
+func SkipProcessor(skip HashSet, hashName string, p Processor) (Processor, error) {
+	_, err := NewHash(hashName)
+	if err != nil {
+		return nil, err
+	}
+
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		h, _ := NewHash(hashName)
+		h.Write(c.Data)
+		id := hex.EncodeToString(h.Sum(nil))
+		if !skip.Has(id) {
+			return p.Process(c, out)
+		}
+		err := writeManifestLine(out, id, c)
+		if err != nil {
+			return fmt.Errorf("error writing manifest line: %s", err)
+		}
+		return nil
+	}), nil
+}
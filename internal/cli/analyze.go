@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func analyzeMain(args []string) {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Chunk each FILE without running a processor and report how many chunks are duplicated")
+		fmt.Fprintln(os.Stderr, "within and across the inputs, the unique bytes and the projected storage savings,")
+		fmt.Fprintln(os.Stderr, "to help choose chunking parameters.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker analyze [-flags...] FILE...")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	hashName := fs.String("hash", "sha256", "hash identifying duplicate chunks, one of sha256 or blake3")
+	jobs := fs.Int("jobs", 1, "number of chunks to hash concurrently")
+	cf := addChunkFlags(fs)
+	pf := addProfileFlags(fs)
+	lf := addLogFlags(fs)
+
+	files := parseInterspersed(fs, args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	_, err := pf.apply(fs)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if len(files) == 0 {
+		fs.Usage()
+	}
+	if *jobs < 1 {
+		slog.Error("-jobs must be at least 1")
+		os.Exit(exitUsage)
+	}
+	_, err = cchunker.NewHash(*hashName)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	params, err := cf.params()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	var mu sync.Mutex
+	// seenIn is the index of the first input containing each chunk.
+	seenIn := make(map[string]int)
+	totalChunks := int64(0)
+	totalBytes := uint64(0)
+	uniqueBytes := uint64(0)
+	withinDuplicates := int64(0)
+	acrossDuplicates := int64(0)
+
+	for i, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			slog.Error("error opening input", "err", err)
+			os.Exit(exitCode(err))
+		}
+
+		input := i
+		p := cchunker.Pipeline{
+			Params: params,
+			Processor: cchunker.ProcessorFunc(func(c cchunker.Chunk, out io.Writer) error {
+				h, _ := cchunker.NewHash(*hashName)
+				h.Write(c.Data)
+				id := hex.EncodeToString(h.Sum(nil))
+
+				mu.Lock()
+				defer mu.Unlock()
+				totalChunks += 1
+				totalBytes += uint64(len(c.Data))
+				first, ok := seenIn[id]
+				switch {
+				case !ok:
+					seenIn[id] = input
+					uniqueBytes += uint64(len(c.Data))
+				case first == input:
+					withinDuplicates += 1
+				default:
+					acrossDuplicates += 1
+				}
+				return nil
+			}),
+			Jobs: *jobs,
+		}
+		_, err = p.Run(f, io.Discard)
+		f.Close()
+		if err != nil {
+			slog.Error(path, "err", err)
+			os.Exit(exitCode(err))
+		}
+	}
+
+	savings := 0.0
+	if totalBytes != 0 {
+		savings = 100 * float64(totalBytes-uniqueBytes) / float64(totalBytes)
+	}
+	fmt.Printf("inputs %d\n", len(files))
+	fmt.Printf("chunks %d\n", totalChunks)
+	fmt.Printf("unique chunks %d\n", len(seenIn))
+	fmt.Printf("duplicate chunks within inputs %d\n", withinDuplicates)
+	fmt.Printf("duplicate chunks across inputs %d\n", acrossDuplicates)
+	fmt.Printf("total bytes %d\n", totalBytes)
+	fmt.Printf("unique bytes %d\n", uniqueBytes)
+	fmt.Printf("projected savings %d bytes %.1f%%\n", totalBytes-uniqueBytes, savings)
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func catMain(args []string) {
+	fs := flag.NewFlagSet("cat", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Reassemble the original data from a manifest of chunks in a chunk store,")
+		fmt.Fprintln(os.Stderr, "writing it to stdout and verifying the hash of every chunk.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker cat MANIFEST -dir STORE [-flags...]")
+		fmt.Fprintln(os.Stderr, "MANIFEST is the output of cchunker store, or - for stdin.")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	dir := fs.String("dir", "", "chunk store directory")
+	hashName := fs.String("hash", "sha256", "hash naming each chunk, one of sha256 or blake3")
+	keyFile := fs.String("encrypt-keyfile", "", "key file the chunks were encrypted with, or fd:N, cmd:COMMAND or prompt")
+	file := fs.String("file", "", "only write the data of this input file of a manifest of several files")
+	streamHash := fs.Bool("stream-hash", true, "check the data of each file against its -stream-hash trailers, with the hash each trailer names")
+	lf := addLogFlags(fs)
+
+	positional := parseInterspersed(fs, args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if *dir == "" || len(positional) != 1 {
+		fs.Usage()
+	}
+	_, err := cchunker.NewHash(*hashName)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	var manifest io.Reader = os.Stdin
+	if positional[0] != "-" {
+		f, err := os.Open(positional[0])
+		if err != nil {
+			slog.Error("error opening manifest", "err", err)
+			os.Exit(exitCode(err))
+		}
+		defer f.Close()
+		manifest = f
+	}
+
+	store := &cchunker.Store{
+		Dir:             *dir,
+		Hash:            *hashName,
+		CheckStreamHash: *streamHash,
+	}
+	if *keyFile != "" {
+		store.Key, err = readKey(*keyFile)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	if *file != "" {
+		err = store.CatFile(manifest, *file, out)
+	} else {
+		err = store.Cat(manifest, out)
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+}
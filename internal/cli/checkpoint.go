@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+// checkpointRecord is a line of a checkpoint file. The first line only
+// holds the chunking parameters, each following line is the checkpoint
+// after a chunk along with the output of that chunk.
+type checkpointRecord struct {
+	Params *cchunker.Params `json:"params,omitempty"`
+	cchunker.Checkpoint
+	Output []byte `json:"output"`
+}
+
+// readCheckpoint reads the complete chunk records of a checkpoint file
+// written with params.
+func readCheckpoint(path string, params cchunker.Params) ([]checkpointRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening checkpoint: %s", err)
+	}
+	defer f.Close()
+
+	var records []checkpointRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<30)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		var record checkpointRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			// A run killed while writing leaves a partial last line.
+			break
+		}
+		if lineNo == 1 {
+			if record.Params == nil {
+				return nil, fmt.Errorf("%s is not a checkpoint file", path)
+			}
+			// The chunking key is not recorded in the checkpoint.
+			params.Key = nil
+			if !reflect.DeepEqual(*record.Params, params) {
+				return nil, fmt.Errorf("checkpoint %s was made with different chunking parameters", path)
+			}
+			continue
+		}
+		records = append(records, record)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint: %s", err)
+	}
+	return records, nil
+}
+
+// checkpointWriter records the progress of a run to a checkpoint file,
+// syncing it to disk at most once per interval.
+type checkpointWriter struct {
+	f        *os.File
+	w        *bufio.Writer
+	interval time.Duration
+	lastSync time.Time
+}
+
+// createCheckpoint replaces the checkpoint file at path with one for a run
+// with params, starting with the records of any chunks already done.
+func createCheckpoint(path string, params cchunker.Params, done []checkpointRecord, interval time.Duration) (*checkpointWriter, error) {
+	// Write to a temporary file first so the checkpoint being resumed
+	// from is never lost.
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-checkpoint-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating checkpoint: %s", err)
+	}
+	cw := &checkpointWriter{
+		f:        f,
+		w:        bufio.NewWriter(f),
+		interval: interval,
+	}
+
+	err = cw.write(checkpointRecord{Params: &params})
+	for i := 0; err == nil && i < len(done); i++ {
+		err = cw.write(done[i])
+	}
+	if err == nil {
+		err = cw.sync()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("error writing checkpoint: %s", err)
+	}
+	return cw, nil
+}
+
+func (cw *checkpointWriter) write(record checkpointRecord) error {
+	buf, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = cw.w.Write(append(buf, '\n'))
+	return err
+}
+
+func (cw *checkpointWriter) sync() error {
+	err := cw.w.Flush()
+	if err != nil {
+		return err
+	}
+	cw.lastSync = time.Now()
+	return cw.f.Sync()
+}
+
+// Checkpoint is a cchunker.Pipeline checkpoint function.
+func (cw *checkpointWriter) Checkpoint(cp cchunker.Checkpoint, output []byte) error {
+	err := cw.write(checkpointRecord{Checkpoint: cp, Output: output})
+	if err == nil && time.Since(cw.lastSync) >= cw.interval {
+		err = cw.sync()
+	}
+	if err != nil {
+		return fmt.Errorf("error writing checkpoint: %s", err)
+	}
+	return nil
+}
+
+// Close syncs and closes the checkpoint file.
+func (cw *checkpointWriter) Close() error {
+	err := cw.sync()
+	if err2 := cw.f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return fmt.Errorf("error writing checkpoint: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,413 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func chunkMain(args []string) {
+	runChunk("chunk", args)
+}
+
+func archiveMain(args []string) {
+	runChunk("archive", args)
+}
+
+// runChunk runs the chunk command, or with name "archive", the archive
+// command which chunks the archive stream of a directory instead.
+func runChunk(name string, args []string) {
+	archive := name == "archive"
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		if archive {
+			fmt.Fprintln(os.Stderr, "Do content defined chunking on a deterministic archive stream of the directory tree DIR,")
+			fmt.Fprintln(os.Stderr, "running CHUNK PROCESSOR on each chunk. The restored stream is unpacked with cchunker extract.")
+			fmt.Fprint(os.Stderr, "\n\n")
+			fmt.Fprintln(os.Stderr, "usage:")
+			fmt.Fprintln(os.Stderr, "cchunker archive [-flags...] DIR CHUNK PROCESSOR")
+		} else {
+			fmt.Fprintln(os.Stderr, "Do content defined chunking on data piped into stdin or read from -input, running CHUNK PROCESSOR on each chunk.")
+			fmt.Fprint(os.Stderr, "\n\n")
+			fmt.Fprintln(os.Stderr, "usage:")
+			fmt.Fprintln(os.Stderr, "cchunker chunk [-flags...] CHUNK PROCESSOR")
+		}
+		printProcessorHelp(fs)
+		fmt.Fprintln(os.Stderr, "With -format jsonl, one JSON object is printed per chunk with the index, offset, length,")
+		fmt.Fprintln(os.Stderr, "processor output and processor exit status.")
+		fmt.Fprintln(os.Stderr, "With -format caibx, a casync .caibx index of the chunks is written instead of the processor output,")
+		fmt.Fprintln(os.Stderr, "CHUNK PROCESSOR is optional, use -backend castr:///path to also write a casync chunk store.")
+		fmt.Fprintln(os.Stderr, "With -checkpoint FILE, progress is recorded so a killed run reading a file with -input or on stdin can be")
+		fmt.Fprintln(os.Stderr, "continued with -resume FILE, which repeats the output of the chunks already done.")
+		fmt.Fprintln(os.Stderr, "The default are chunks with a min size 512 KiB, max size 16 MiB and and average of 4MiB")
+		fmt.Fprintln(os.Stderr, "On any IO or subprocess errors, cchunker exits with a non zero exit code.")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	format := fs.String("format", "raw", "output format, raw prints processor output unchanged, jsonl prints a JSON object per chunk, caibx writes a casync blob index")
+	signKey := fs.String("sign-key", "", "append an ed25519 signature of the manifest made with the key in this file, or fd:N, cmd:COMMAND or prompt, see gen-sign-key")
+	streamHash := addStreamHashFlag(fs)
+	withExtents := fs.Bool("with-extents", false, "prefix each line of processor output with the offset and length of its chunk, separated by tabs")
+	provenance := fs.Bool("provenance", false, "start the output with a line recording the cchunker version and chunk parameters, off by default as processor output may be read by tools not expecting it")
+	checkpointFile := fs.String("checkpoint", "", "record progress to this file so an interrupted run can be continued with -resume")
+	checkpointInterval := fs.Duration("checkpoint-interval", 10*time.Second, "how often the -checkpoint file is synced to disk")
+	resumeFile := fs.String("resume", "", "continue an interrupted run from this checkpoint file, the input must be the same seekable file")
+	inputFiles := addInputFlag(fs)
+	elide := addElideZeroFlag(fs)
+	decompress := addDecompressFlag(fs)
+	useMmap := addMmapFlag(fs)
+	rf := addRangeFlags(fs)
+	maxChunks := addMaxChunksFlag(fs)
+	archiveMetadata := new(bool)
+	if archive {
+		archiveMetadata = fs.Bool("metadata", false, "also archive ownership, modification times, xattrs and ACLs, restored by extract")
+	}
+	statsFile := addStatsFlag(fs)
+	metricsAddr := addMetricsFlag(fs)
+	otlpEndpoint := addTracingFlag(fs)
+	epf := addErrorPolicyFlags(fs)
+	hf := addHistogramFlags(fs)
+	prf := addProcessorFlags(fs)
+	cf := addChunkFlags(fs)
+	pf := addProfileFlags(fs)
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	profileProcessor, err := pf.apply(fs)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	cmdArgs := fs.Args()
+	var archiveDir string
+	if archive {
+		if len(cmdArgs) == 0 {
+			fs.Usage()
+		}
+		archiveDir = cmdArgs[0]
+		cmdArgs = cmdArgs[1:]
+	}
+	if len(cmdArgs) == 0 && !prf.builtin() {
+		cmdArgs = profileProcessor
+	}
+
+	// A caibx index can be made without storing the chunks anywhere.
+	indexOnly := *format == "caibx" && len(cmdArgs) == 0 && !prf.builtin()
+	if len(cmdArgs) == 0 && !prf.builtin() && !indexOnly {
+		fs.Usage()
+	}
+
+	params, err := cf.params()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	// Holes are only skipped when they can be written as zero lines.
+	params.SkipHoles = *elide
+
+	if len(*inputFiles) > 1 && (*checkpointFile != "" || *resumeFile != "") {
+		slog.Error("-checkpoint and -resume can only be used with a single input")
+		os.Exit(exitUsage)
+	}
+
+	if archive && (len(*inputFiles) != 0 || *decompress != "none" || rf.set()) {
+		slog.Error("-input, -decompress, -offset and -length cannot be used with archive")
+		os.Exit(exitUsage)
+	}
+
+	if rf.set() && (len(*inputFiles) > 1 || *decompress != "none") {
+		slog.Error("-offset and -length can only be used with a single input and without -decompress")
+		os.Exit(exitUsage)
+	}
+
+	if archive && *resumeFile != "" {
+		slog.Error("-resume cannot be used with archive")
+		os.Exit(exitUsage)
+	}
+
+	if *prf.emit != "" && (*format != "raw" || *elide || *signKey != "" || len(*inputFiles) > 1) {
+		slog.Error("-emit can not be used with -format jsonl, -elide-zero, -sign-key or several -input files")
+		os.Exit(exitUsage)
+	}
+
+	if *format == "caibx" && (*prf.compress != "" || *prf.keyFile != "" || *elide || *signKey != "" || len(*inputFiles) > 1 ||
+		*checkpointFile != "" || *resumeFile != "" || rf.set() || *epf.onError != "abort") {
+		slog.Error("-format caibx indexes every untransformed chunk of a single whole input, it can not be used with -compress,\n" +
+			"-encrypt-keyfile, -elide-zero, -sign-key, several -input files, -checkpoint, -resume, -offset, -length or -on-error continue")
+		os.Exit(exitUsage)
+	}
+
+	err = checkStreamHash(*streamHash)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if *streamHash != "" && (*format == "caibx" || *prf.emit != "" || archive || *resumeFile != "") {
+		slog.Error("-stream-hash can not be used with -format caibx, -emit, archive or -resume")
+		os.Exit(exitUsage)
+	}
+
+	if *withExtents && (*format != "raw" || *prf.emit != "") {
+		slog.Error("-with-extents can only be used with -format raw and without -emit")
+		os.Exit(exitUsage)
+	}
+
+	if *provenance && *format != "raw" {
+		slog.Error("-provenance can only be used with -format raw")
+		os.Exit(exitUsage)
+	}
+	prf.checkPrevious(params)
+
+	var processor cchunker.Processor
+	var closer io.Closer
+	if indexOnly {
+		processor = cchunker.ProcessorFunc(func(c cchunker.Chunk, out io.Writer) error { return nil })
+	} else {
+		processor, closer, err = prf.processor(cmdArgs)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+	}
+
+	switch *format {
+	case "raw":
+	case "jsonl":
+		processor = cchunker.JSONLinesProcessor(processor)
+	case "caibx":
+		processor = cchunker.CaibxProcessor(processor)
+	default:
+		slog.Error(fmt.Sprintf("unknown output format %q", *format))
+		os.Exit(exitUsage)
+	}
+
+	processor, err = prf.wrap(processor)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	processor = elideZero(*elide, *format, processor)
+	if *withExtents {
+		processor = cchunker.ExtentsProcessor(processor, '\n')
+	}
+	processor = lf.processor(processor)
+
+	metrics, err := serveMetrics(*metricsAddr)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if metrics != nil {
+		processor = metrics.Processor(processor)
+	}
+
+	traces, err := startTracing(*otlpEndpoint, "cchunker chunk")
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if traces != nil {
+		processor = traces.processor(processor)
+	}
+
+	var recorder *cchunker.StatsRecorder
+	if *statsFile != "" {
+		recorder = cchunker.NewStatsRecorder()
+		processor = recorder.Processor(processor)
+	}
+
+	histogram, err := hf.histogram()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if histogram != nil {
+		processor = histogram.Processor(processor)
+	}
+
+	failures, failuresCloser, err := epf.failureLog()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if failures != nil {
+		processor = failures.Processor(processor)
+	}
+
+	p := cchunker.Pipeline{
+		Params:    params,
+		Processor: processor,
+		Jobs:      *prf.jobs,
+		MaxChunks: *maxChunks,
+		// Chunk offsets of a range are from the start of the input.
+		Resume: cchunker.Checkpoint{Offset: uint64(rf.offset)},
+	}
+
+	out, sign, err := signedOutput(*signKey)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if *provenance {
+		err = cchunker.WriteProvenance(out, params)
+		if err != nil {
+			slog.Error("error writing chunk output", "err", err)
+			os.Exit(exitCode(err))
+		}
+	}
+
+	var done []checkpointRecord
+	if *resumeFile != "" {
+		done, err = readCheckpoint(*resumeFile, params)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+		if len(done) != 0 {
+			p.Resume = done[len(done)-1].Checkpoint
+		}
+		// Repeat the output of the chunks already done, so the output of
+		// the resumed run is complete.
+		for _, record := range done {
+			_, err = out.Write(record.Output)
+			if err != nil {
+				slog.Error("error writing chunk output", "err", err)
+				os.Exit(exitCode(err))
+			}
+		}
+		if *checkpointFile == "" {
+			*checkpointFile = *resumeFile
+		}
+	}
+
+	var cw *checkpointWriter
+	if *checkpointFile != "" {
+		cw, err = createCheckpoint(*checkpointFile, params, done, *checkpointInterval)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+		p.Checkpoint = cw.Checkpoint
+	}
+
+	signals := handleSignals()
+	p.Stop = signals.stop
+	header := cchunker.WriteFileHeader
+	trailer := cchunker.WriteStreamHash
+	if *format == "jsonl" {
+		header = cchunker.WriteJSONFileHeader
+		trailer = cchunker.WriteJSONStreamHash
+	}
+	if *format == "caibx" {
+		err = cchunker.WriteCaibxHeader(out, params)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+	}
+	nChunks := int64(0)
+	if archive {
+		nChunks, err = runArchive(&p, archiveDir, cchunker.ArchiveOptions{Metadata: *archiveMetadata, Warnings: os.Stderr}, out)
+	} else {
+		err = forEachInput(*inputFiles, func(path string) error {
+			return header(out, path)
+		}, func(input *os.File) error {
+			src, release, err := mapInput(input, *useMmap)
+			if err != nil {
+				return err
+			}
+			defer release()
+			if *decompress == "none" {
+				r, err := rf.reader(src, p.Resume.Offset)
+				if err != nil {
+					return err
+				}
+				nChunks, err = runStreamHashed(&p, r, out, *streamHash, trailer)
+				return err
+			}
+			r, done, err := decompressInput(src, *decompress)
+			if err != nil {
+				return err
+			}
+			defer done()
+			if p.Resume.Offset != 0 {
+				// Offsets are into the decompressed data, which can only
+				// be skipped over.
+				_, err := io.CopyN(io.Discard, r, int64(p.Resume.Offset))
+				if err != nil {
+					return fmt.Errorf("error skipping to the -resume offset: %s", err)
+				}
+			}
+			nChunks, err = runStreamHashed(&p, r, out, *streamHash, trailer)
+			return err
+		})
+	}
+	if err == nil && *format == "caibx" {
+		err = cchunker.WriteCaibxTail(out, nChunks)
+	}
+	if cw != nil {
+		// Keep the progress made even if the run failed.
+		if err2 := cw.Close(); err == nil {
+			err = err2
+		}
+	}
+	if err == nil {
+		err = sign()
+	}
+	if recorder != nil {
+		if err2 := writeStats(*statsFile, recorder, err); err == nil {
+			err = err2
+		}
+	}
+	if histogram != nil {
+		if err2 := hf.print(histogram, params); err == nil {
+			err = err2
+		}
+	}
+	if failures != nil {
+		err = finishFailures(failures, failuresCloser, err)
+	}
+	if traces != nil {
+		if err2 := traces.finish(err); err == nil {
+			err = err2
+		}
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(signals.exitCode(err))
+	}
+
+	if closer != nil {
+		err = closer.Close()
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+	}
+}
+
+// runArchive runs p on the archive stream of the directory dir, returning
+// the number of chunks.
+func runArchive(p *cchunker.Pipeline, dir string, opts cchunker.ArchiveOptions, out io.Writer) (int64, error) {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(cchunker.WriteArchive(w, dir, opts))
+	}()
+	nChunks, err := p.Run(r, out)
+	// Unblock the archive writer if the run stopped early.
+	r.Close()
+	return nChunks, err
+}
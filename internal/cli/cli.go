@@ -0,0 +1,113 @@
+// Package cli implements the cchunker and multicchunker commands.
+package cli
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+type command struct {
+	name    string
+	summary string
+	main    func(args []string)
+}
+
+func commands() []command {
+	return []command{
+		{"chunk", "chunk stdin, running a processor on each chunk", chunkMain},
+		{"multi", "chunk stdin repeatedly until it is reduced to a single summary line", func(args []string) {
+			multiMain("cchunker multi", args)
+		}},
+		{"archive", "chunk a deterministic archive stream of a directory tree", archiveMain},
+		{"extract", "unpack an archive stream on stdin into a directory", extractMain},
+		{"split", "write each chunk of stdin to a numbered file", splitMain},
+		{"store", "chunk stdin into a content addressed directory", storeMain},
+		{"send", "send the chunks of stdin that a cchunker receive store is missing", sendMain},
+		{"receive", "receive chunks from cchunker send into a content addressed directory", receiveMain},
+		{"cat", "restore data from a manifest and a content addressed directory", catMain},
+		{"rechunk", "chunk the data of a manifest again with new chunk parameters", rechunkMain},
+		{"verify", "check the chunks of a manifest are intact in a content addressed directory", verifyMain},
+		{"repair", "rebuild lost chunks of a manifest from its parity chunks", repairMain},
+		{"gc", "remove the chunks no manifest references from a content addressed directory", gcMain},
+		{"export-restic", "write the chunks of a manifest as a restic repository index", exportResticMain},
+		{"mount", "mount the data of a manifest as a read only filesystem", mountMain},
+		{"analyze", "report the duplicate chunks within and across files", analyzeMain},
+		{"diff", "compare the chunks of two manifests", diffMain},
+		{"tune", "recommend chunk sizes from how a sample of the input chunks", tuneMain},
+		{"skip-filter", "write a Bloom filter of chunk hashes for -skip-hashes", skipFilterMain},
+		{"serve", "serve chunking over a unix socket or HTTP", serveMain},
+		{"synth", "write reproducible pseudo-random test data", synthMain},
+		{"fuzz", "check the chunker invariants over random inputs and parameters", fuzzMain},
+		{"selftest", "check this build cuts the same chunks as known good builds", selftestMain},
+		{"gen-poly", "generate a new chunking polynomial", genPolyMain},
+		{"check-poly", "check polynomials are suitable for content chunking", checkPolyMain},
+		{"gen-sign-key", "generate a manifest signing key", genSignKeyMain},
+		{"verify-manifest", "verify the signature of a signed manifest", verifyManifestMain},
+		{"completion", "print a completion script for bash, zsh or fish", completionMain},
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "This is a command that does content defined chunking on data piped into stdin.")
+	fmt.Fprintln(os.Stderr, "Content chunking has the special property is that chunks will be shared across similar")
+	fmt.Fprintln(os.Stderr, "data, this makes these chunks suitable for deduplicating backup programs.")
+	fmt.Fprint(os.Stderr, "\n\n")
+	fmt.Fprintln(os.Stderr, "usage:")
+	fmt.Fprintln(os.Stderr, "cchunker COMMAND [-flags...] [ARGS...]")
+	fmt.Fprintln(os.Stderr, "cchunker [-flags...] CHUNK PROCESSOR, the same as cchunker chunk")
+	fmt.Fprintln(os.Stderr, "cchunker -version")
+	fmt.Fprint(os.Stderr, "\n")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, c := range commands() {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Fprint(os.Stderr, "\n")
+	fmt.Fprintln(os.Stderr, "Run cchunker COMMAND -help for the flags of each command.")
+	os.Exit(exitUsage)
+}
+
+// Main runs the cchunker command with args, not including the program name.
+func Main(args []string) {
+	slog.SetDefault(slog.New(newTextHandler(os.Stderr, slog.LevelInfo)))
+	if len(args) == 0 {
+		usage()
+	}
+
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage()
+	case "-version", "--version":
+		fmt.Println("cchunker", cchunker.Version())
+		return
+	}
+
+	for _, c := range commands() {
+		if c.name == args[0] {
+			c.main(args[1:])
+			return
+		}
+	}
+
+	if legacyPolyMain(args) {
+		return
+	}
+	// Without a command, behave as the original chunk command did.
+	chunkMain(args)
+}
+
+// MultiMain runs the multicchunker command with args, not including the
+// program name.
+func MultiMain(args []string) {
+	slog.SetDefault(slog.New(newTextHandler(os.Stderr, slog.LevelInfo)))
+	if len(args) != 0 && (args[0] == "-version" || args[0] == "--version") {
+		fmt.Println("multicchunker", cchunker.Version())
+		return
+	}
+	if legacyPolyMain(args) {
+		return
+	}
+	multiMain("multicchunker", args)
+}
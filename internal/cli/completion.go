@@ -0,0 +1,275 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// collectFlags, when set, is given the flag set of a command in place of
+// running the command, see commandFlags.
+var collectFlags func(fs *flag.FlagSet)
+
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// completionCommand is a command and the words that can follow it.
+type completionCommand struct {
+	name    string
+	summary string
+	flags   []completionFlag
+	// args are the choices for the arguments, files if empty.
+	args []string
+}
+
+type completionFlag struct {
+	name  string
+	usage string
+	// value names the value of the flag, empty for bool flags.
+	value string
+}
+
+// commandFlags returns the flags main defines, by running it until its
+// flags are parsed.
+func commandFlags(main func(args []string)) []completionFlag {
+	var flags []completionFlag
+	collectFlags = func(fs *flag.FlagSet) {
+		fs.VisitAll(func(f *flag.Flag) {
+			value, usage := flag.UnquoteUsage(f)
+			usage, _, _ = strings.Cut(usage, "\n")
+			flags = append(flags, completionFlag{name: f.Name, usage: usage, value: value})
+		})
+	}
+	defer func() { collectFlags = nil }()
+
+	// The command stops itself with runtime.Goexit once it has given its
+	// flags, so it runs in its own goroutine.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		main(nil)
+	}()
+	<-done
+	return flags
+}
+
+func completionMain(args []string) {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Print a script completing the commands and flags of cchunker and multicchunker")
+		fmt.Fprintln(os.Stderr, "for a shell, made from the flags of this build so it is never out of date.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker completion bash|zsh|fish")
+		fmt.Fprint(os.Stderr, "\n")
+		fmt.Fprintln(os.Stderr, "e.g.")
+		fmt.Fprintln(os.Stderr, "bash: source <(cchunker completion bash)")
+		fmt.Fprintln(os.Stderr, "zsh:  cchunker completion zsh > \"${fpath[1]}/_cchunker\"")
+		fmt.Fprintln(os.Stderr, "fish: cchunker completion fish | source")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+	}
+
+	var write func(w io.Writer, cmds []completionCommand)
+	switch fs.Arg(0) {
+	case "bash":
+		write = writeBashCompletion
+	case "zsh":
+		write = writeZshCompletion
+	case "fish":
+		write = writeFishCompletion
+	default:
+		slog.Error(fmt.Sprintf("unknown shell %q, expected bash, zsh or fish", fs.Arg(0)))
+		os.Exit(exitUsage)
+	}
+
+	var cmds []completionCommand
+	for _, c := range commands() {
+		cc := completionCommand{name: c.name, summary: c.summary, flags: commandFlags(c.main)}
+		if c.name == "completion" {
+			cc.args = completionShells
+		}
+		cmds = append(cmds, cc)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	write(out, cmds)
+	err := out.Flush()
+	if err != nil {
+		slog.Error("error writing completion script", "err", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// findCompletionCommand returns the command named name.
+func findCompletionCommand(cmds []completionCommand, name string) completionCommand {
+	for _, c := range cmds {
+		if c.name == name {
+			return c
+		}
+	}
+	panic("no command " + name)
+}
+
+func writeBashCompletion(w io.Writer, cmds []completionCommand) {
+	var names []string
+	for _, c := range cmds {
+		names = append(names, c.name)
+	}
+	fmt.Fprintln(w, "# bash completion for cchunker, generated by cchunker completion bash.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "_cchunker() {")
+	fmt.Fprintln(w, "\tlocal cur=${COMP_WORDS[COMP_CWORD]} cmd words args")
+	fmt.Fprintln(w, "\tif [ \"${1##*/}\" = multicchunker ]; then")
+	fmt.Fprintln(w, "\t\tcmd=multi")
+	fmt.Fprintln(w, "\telif [ \"$COMP_CWORD\" -eq 1 ] && [[ $cur != -* ]]; then")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W '%s' -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\telif [[ ${COMP_WORDS[1]} == -* ]]; then")
+	fmt.Fprintln(w, "\t\tcmd=chunk")
+	fmt.Fprintln(w, "\telse")
+	fmt.Fprintln(w, "\t\tcmd=${COMP_WORDS[1]}")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tcase $cmd in")
+	for _, c := range cmds {
+		var words []string
+		if c.name == "chunk" {
+			words = append(words, "-version")
+		}
+		for _, f := range c.flags {
+			words = append(words, "-"+f.name)
+		}
+		fmt.Fprintf(w, "\t%s)\n", c.name)
+		fmt.Fprintf(w, "\t\twords='%s'\n", strings.Join(words, " "))
+		if len(c.args) != 0 {
+			fmt.Fprintf(w, "\t\targs='%s'\n", strings.Join(c.args, " "))
+		}
+		fmt.Fprintln(w, "\t\t;;")
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "\tif [[ $cur == -* ]]; then")
+	fmt.Fprintln(w, "\t\tCOMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))")
+	fmt.Fprintln(w, "\telif [ -n \"$args\" ]; then")
+	fmt.Fprintln(w, "\t\tCOMPREPLY=($(compgen -W \"$args\" -- \"$cur\"))")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "complete -o default -F _cchunker cchunker multicchunker")
+}
+
+// zshQuote quotes s for a single quoted _arguments or _describe spec.
+func zshQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+	return strings.ReplaceAll(s, "'", `'\''`)
+}
+
+func writeZshCompletion(w io.Writer, cmds []completionCommand) {
+	fmt.Fprintln(w, "#compdef cchunker multicchunker")
+	fmt.Fprintln(w, "# zsh completion for cchunker, generated by cchunker completion zsh.")
+	for _, c := range cmds {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "_cchunker_%s() {\n", c.name)
+		fmt.Fprintln(w, "\t_arguments \\")
+		for _, f := range c.flags {
+			spec := "-" + f.name + "[" + zshQuote(f.usage) + "]"
+			if f.value != "" {
+				spec += ":" + zshQuote(f.value) + ":_files"
+			}
+			fmt.Fprintf(w, "\t\t'%s' \\\n", spec)
+		}
+		if len(c.args) != 0 {
+			fmt.Fprintf(w, "\t\t'1:argument:(%s)'\n", strings.Join(c.args, " "))
+		} else {
+			fmt.Fprintln(w, "\t\t'*:file:_files'")
+		}
+		fmt.Fprintln(w, "}")
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "_cchunker() {")
+	fmt.Fprintln(w, "\tlocal cmd")
+	fmt.Fprintln(w, "\tlocal -a commands")
+	fmt.Fprintln(w, "\tif [[ $service == multicchunker ]]; then")
+	fmt.Fprintln(w, "\t\t_cchunker_multi")
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tif (( CURRENT == 2 )) && [[ $PREFIX != -* ]]; then")
+	fmt.Fprintln(w, "\t\tcommands=(")
+	for _, c := range cmds {
+		fmt.Fprintf(w, "\t\t\t'%s:%s'\n", zshQuote(c.name), zshQuote(c.summary))
+	}
+	fmt.Fprintln(w, "\t\t)")
+	fmt.Fprintln(w, "\t\t_describe -t commands 'cchunker command' commands")
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tif [[ $words[2] == -* ]]; then")
+	fmt.Fprintln(w, "\t\t_cchunker_chunk")
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tcmd=$words[2]")
+	fmt.Fprintln(w, "\tshift words")
+	fmt.Fprintln(w, "\t(( CURRENT-- ))")
+	fmt.Fprintln(w, "\t(( $+functions[_cchunker_$cmd] )) && _cchunker_$cmd")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "if [ \"$funcstack[1]\" = _cchunker ]; then")
+	fmt.Fprintln(w, "\t_cchunker \"$@\"")
+	fmt.Fprintln(w, "else")
+	fmt.Fprintln(w, "\tcompdef _cchunker cchunker multicchunker")
+	fmt.Fprintln(w, "fi")
+}
+
+// fishQuote quotes s as a single quoted fish string.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func writeFishCompletion(w io.Writer, cmds []completionCommand) {
+	writeFlags := func(program, condition string, flags []completionFlag) {
+		for _, f := range flags {
+			fmt.Fprintf(w, "complete -c %s", program)
+			if condition != "" {
+				fmt.Fprintf(w, " -n %s", fishQuote(condition))
+			}
+			fmt.Fprintf(w, " -o %s", f.name)
+			if f.value != "" {
+				fmt.Fprint(w, " -r")
+			}
+			fmt.Fprintf(w, " -d %s\n", fishQuote(f.usage))
+		}
+	}
+
+	fmt.Fprintln(w, "# fish completion for cchunker, generated by cchunker completion fish.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "complete -c cchunker -n __fish_use_subcommand -f")
+	for _, c := range cmds {
+		fmt.Fprintf(w, "complete -c cchunker -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.summary))
+	}
+	fmt.Fprintln(w, "complete -c cchunker -n __fish_use_subcommand -o version -d 'print the version'")
+	// Without a command, cchunker is cchunker chunk.
+	writeFlags("cchunker", "__fish_use_subcommand", findCompletionCommand(cmds, "chunk").flags)
+	for _, c := range cmds {
+		fmt.Fprintln(w)
+		condition := "__fish_seen_subcommand_from " + c.name
+		writeFlags("cchunker", condition, c.flags)
+		if len(c.args) != 0 {
+			fmt.Fprintf(w, "complete -c cchunker -n %s -f -a %s\n", fishQuote(condition), fishQuote(strings.Join(c.args, " ")))
+		}
+	}
+	fmt.Fprintln(w)
+	writeFlags("multicchunker", "", findCompletionCommand(cmds, "multi").flags)
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func diffMain(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Compare the chunks of two 'hash size offset' manifests, reporting the chunks added,")
+		fmt.Fprintln(os.Stderr, "removed and shared with byte totals, and the dedup ratio of storing both.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker diff OLD NEW")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+	}
+
+	oldManifest, err := os.Open(fs.Arg(0))
+	if err != nil {
+		slog.Error("error opening manifest", "err", err)
+		os.Exit(exitCode(err))
+	}
+	defer oldManifest.Close()
+	newManifest, err := os.Open(fs.Arg(1))
+	if err != nil {
+		slog.Error("error opening manifest", "err", err)
+		os.Exit(exitCode(err))
+	}
+	defer newManifest.Close()
+
+	d, err := cchunker.DiffManifests(oldManifest, newManifest)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	fmt.Printf("added %d chunks %d bytes\n", d.Added.Chunks, d.Added.Bytes)
+	fmt.Printf("removed %d chunks %d bytes\n", d.Removed.Chunks, d.Removed.Bytes)
+	fmt.Printf("shared %d chunks %d bytes\n", d.Shared.Chunks, d.Shared.Bytes)
+	fmt.Printf("dedup ratio %.2f\n", d.DedupRatio())
+}
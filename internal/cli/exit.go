@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"errors"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+// Exit codes distinguishing classes of failure, so scripts wrapping
+// cchunker can react to them, for example only retrying input errors.
+// A run stopped by a signal exits with 128 plus the signal number.
+const (
+	// exitFailure is any failure not in a more specific class.
+	exitFailure = 1
+	// exitUsage is invalid flags or arguments, the code the flag
+	// package already exits with for flags it can't parse.
+	exitUsage = 2
+	// exitInput is an error reading the data being chunked.
+	exitInput = 3
+	// exitProcessor is a processor, store or backend failing on a chunk.
+	exitProcessor = 4
+	// exitPolynomial is an invalid polynomial, or one that is not
+	// irreducible.
+	exitPolynomial = 5
+)
+
+// usageError is an error in the flags or arguments of a command.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *usageError) Unwrap() error {
+	return e.err
+}
+
+// polynomialError is an invalid or unsuitable polynomial.
+type polynomialError struct {
+	err error
+}
+
+func (e *polynomialError) Error() string {
+	return e.err.Error()
+}
+
+func (e *polynomialError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the exit code for the class of err.
+func exitCode(err error) int {
+	var polyErr *polynomialError
+	var usageErr *usageError
+	var inputErr *cchunker.InputError
+	var processorErr *cchunker.ProcessorError
+	switch {
+	case errors.As(err, &polyErr):
+		return exitPolynomial
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.As(err, &inputErr):
+		return exitInput
+	case errors.As(err, &processorErr):
+		return exitProcessor
+	}
+	return exitFailure
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func extractMain(args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Unpack the archive stream made by cchunker archive, read from stdin, into the directory DIR.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker extract DIR")
+		fmt.Fprintln(os.Stderr, "For example: cchunker cat MANIFEST -dir STORE | cchunker extract DIR")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+	}
+
+	err := cchunker.ExtractArchive(os.Stdin, fs.Arg(0))
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+// errorPolicyFlags select what happens when the processor fails on a chunk.
+type errorPolicyFlags struct {
+	onError  *string
+	failures *string
+}
+
+func addErrorPolicyFlags(fs *flag.FlagSet) *errorPolicyFlags {
+	return &errorPolicyFlags{
+		onError:  fs.String("on-error", "abort", "what to do when the processor fails on a chunk, abort the run or continue with the next chunk"),
+		failures: fs.String("failures", "", "with -on-error continue, write a JSON line with the index, offset, length and error of each failed chunk to this file instead of stderr"),
+	}
+}
+
+// failureLog returns the log failed chunks are recorded in, or nil if the
+// run should abort on the first failure, and a closer for it.
+func (f *errorPolicyFlags) failureLog() (*cchunker.FailureLog, io.Closer, error) {
+	switch *f.onError {
+	case "abort":
+		if *f.failures != "" {
+			return nil, nil, &usageError{fmt.Errorf("-failures requires -on-error continue")}
+		}
+		return nil, nil, nil
+	case "continue":
+	default:
+		return nil, nil, &usageError{fmt.Errorf("unknown -on-error policy %q, expected abort or continue", *f.onError)}
+	}
+	if *f.failures == "" {
+		return cchunker.NewFailureLog(os.Stderr), nil, nil
+	}
+	file, err := os.Create(*f.failures)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating failures file: %s", err)
+	}
+	return cchunker.NewFailureLog(file), file, nil
+}
+
+// finishFailures closes the failures file of a run that ended with runErr,
+// returning an error if any chunks failed.
+func finishFailures(log *cchunker.FailureLog, closer io.Closer, runErr error) error {
+	if closer != nil {
+		err := closer.Close()
+		if err != nil && runErr == nil {
+			return fmt.Errorf("error closing failures file: %s", err)
+		}
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if n := log.Count(); n != 0 {
+		return fmt.Errorf("chunks failed processing: %d", n)
+	}
+	return nil
+}
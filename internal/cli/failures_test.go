@@ -0,0 +1,27 @@
+package cli
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestErrorPolicyFlagErrorsAreUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"-on-error", "retry"},
+		{"-failures", "failures.jsonl"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		f := addErrorPolicyFlags(fs)
+		err := fs.Parse(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = f.failureLog()
+		if err == nil {
+			t.Fatalf("%v: expected an error", args)
+		}
+		if code := exitCode(err); code != exitUsage {
+			t.Fatalf("%v: %q exits with %d, expected %d", args, err, code, exitUsage)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/andrewchambers/cchunker"
+	"github.com/restic/chunker"
+)
+
+// chunkFlags are the flags shared by every command that chunks data.
+type chunkFlags struct {
+	fs             *flag.FlagSet
+	smallChunks    *bool
+	largeChunks    *bool
+	polynomialInt  *uint64
+	polynomialFile *string
+	fromPassphrase *bool
+	passphraseFile *string
+	keyFile        *string
+	algorithm      *string
+	buzhashSeed    *uint
+	fixedSize      cchunker.Size
+	minSize        cchunker.Size
+	maxSize        cchunker.Size
+	avgSize        cchunker.Size
+	avgBits        *int
+	tarAware       *bool
+	anchorDelim    *string
+}
+
+// addChunkFlags registers the chunking flags on fs.
+func addChunkFlags(fs *flag.FlagSet) *chunkFlags {
+	f := &chunkFlags{
+		fs:             fs,
+		smallChunks:    fs.Bool("small-chunks", false, "change to a min size 512 KiB, max size 16 MiB and and average of 4MiB"),
+		largeChunks:    fs.Bool("large-chunks", false, "change to a min size 1 MiB, max size 32 MiB and and average of 8MiB"),
+		polynomialInt:  fs.Uint64("polynomial", uint64(cchunker.DefaultPolynomial), "polynomial to use for content defined chunking, should be generated with cchunker gen-poly"),
+		polynomialFile: fs.String("polynomial-file", "", "file containing the polynomial, keeping a secret polynomial out of ps and shell history, overrides $"+polynomialEnv),
+		fromPassphrase: fs.Bool("polynomial-from-passphrase", false, "derive the polynomial from a passphrase, prompted for on the terminal or read from -passphrase-file"),
+		passphraseFile: fs.String("passphrase-file", "", "file containing the passphrase for -polynomial-from-passphrase"),
+		keyFile:        fs.String("chunk-key-file", "", "make chunk boundaries depend on the secret hex key in this file, fd:N, cmd:COMMAND or prompt, so chunk sizes can't fingerprint known files"),
+		algorithm:      fs.String("algorithm", "rabin", "chunking algorithm, rabin or buzhash, buzhash uses the BorgBackup chunker parameters and is vectorized with AVX2 on amd64"),
+		buzhashSeed:    fs.Uint("buzhash-seed", 0, "seed for the buzhash table, as used by BorgBackup"),
+		avgBits:        fs.Int("avg-bits", -1, "bits of the chunk split mask, chunks are cut one out of every 2^bits bytes, overrides the preset of the algorithm"),
+		anchorDelim:    fs.String("anchor-delim", "", "snap chunk cuts to the end of records ended by this byte sequence, with Go escapes, e.g. '\\n'"),
+		tarAware:       fs.Bool("tar-aware", false, "cut chunks at the entry boundaries of tar input, so renamed or reordered files still deduplicate"),
+	}
+	// The borg name for the same mask, so it can't disagree with -avg-bits.
+	fs.IntVar(f.avgBits, "buzhash-mask-bits", -1, "alias of -avg-bits, the buzhash HASH_MASK_BITS of BorgBackup")
+	fs.Var(&f.fixedSize, "fixed-size", "cut chunks of exactly this size instead of content defined chunking, e.g. 4MiB")
+	fs.Var(&f.minSize, "min-size", "minimum chunk size, e.g. 256KiB, overrides the preset")
+	fs.Var(&f.maxSize, "max-size", "maximum chunk size, e.g. 8MiB, overrides the preset")
+	fs.Var(&f.avgSize, "avg-size", "average chunk size, e.g. 1MiB, rounded to a power of two, overrides the preset")
+	return f
+}
+
+// polynomialEnv is the environment variable a polynomial may be given in.
+const polynomialEnv = "CCHUNKER_POLYNOMIAL"
+
+// polynomial returns the polynomial given by -polynomial, -polynomial-file,
+// -polynomial-from-passphrase or $CCHUNKER_POLYNOMIAL, in that order of
+// precedence, or the default.
+func (f *chunkFlags) polynomial() (chunker.Pol, error) {
+	explicit := false
+	f.fs.Visit(func(fl *flag.Flag) {
+		if fl.Name == "polynomial" {
+			explicit = true
+		}
+	})
+
+	var p chunker.Pol
+	var source string
+	var err error
+	if *f.passphraseFile != "" && !*f.fromPassphrase {
+		return 0, fmt.Errorf("-passphrase-file requires -polynomial-from-passphrase")
+	}
+	sources := 0
+	for _, given := range []bool{explicit, *f.polynomialFile != "", *f.fromPassphrase} {
+		if given {
+			sources += 1
+		}
+	}
+	if sources > 1 {
+		return 0, fmt.Errorf("only one of -polynomial, -polynomial-file and -polynomial-from-passphrase can be used")
+	}
+
+	switch {
+	case *f.fromPassphrase:
+		passphrase, err := readPassphrase(*f.passphraseFile)
+		if err != nil {
+			return 0, err
+		}
+		p, err := cchunker.PolynomialFromPassphrase(passphrase)
+		if err != nil {
+			return 0, &polynomialError{err}
+		}
+		return p, nil
+	case explicit:
+		p, source = chunker.Pol(*f.polynomialInt), "-polynomial"
+	case *f.polynomialFile != "":
+		source = *f.polynomialFile
+		buf, readErr := os.ReadFile(*f.polynomialFile)
+		if readErr != nil {
+			return 0, fmt.Errorf("error reading polynomial file: %s", readErr)
+		}
+		p, err = parsePolynomial(string(buf))
+	case os.Getenv(polynomialEnv) != "":
+		source = "$" + polynomialEnv
+		p, err = parsePolynomial(os.Getenv(polynomialEnv))
+	default:
+		return cchunker.DefaultPolynomial, nil
+	}
+	if err != nil {
+		return 0, &polynomialError{fmt.Errorf("invalid polynomial in %s: %s", source, err)}
+	}
+	if !p.Irreducible() {
+		return 0, &polynomialError{fmt.Errorf("polynomial from %s is not irreducible, it is not suitable for content chunking", source)}
+	}
+	return p, nil
+}
+
+// params returns validated chunking parameters from the flags, errors
+// other than a bad polynomial are usage errors.
+func (f *chunkFlags) params() (cchunker.Params, error) {
+	params, err := f.parseParams()
+	var polyErr *polynomialError
+	if err != nil && !errors.As(err, &polyErr) {
+		err = &usageError{err}
+	}
+	return params, err
+}
+
+func (f *chunkFlags) parseParams() (cchunker.Params, error) {
+	var params cchunker.Params
+	var err error
+	if f.fixedSize != 0 {
+		if *f.smallChunks || *f.largeChunks || f.minSize != 0 || f.maxSize != 0 || f.avgSize != 0 || *f.avgBits != -1 {
+			return params, fmt.Errorf("chunk size flags can not be used with -fixed-size")
+		}
+		if *f.keyFile != "" {
+			return params, fmt.Errorf("-chunk-key-file can not be used with -fixed-size")
+		}
+		params = cchunker.FixedParams(uint(f.fixedSize))
+		params.TarAware = *f.tarAware
+		params.AnchorDelim, err = f.anchor()
+		if err != nil {
+			return params, err
+		}
+		return params, params.Validate()
+	}
+
+	switch *f.algorithm {
+	case "rabin":
+		params = cchunker.StandardParams
+		if *f.smallChunks {
+			params = cchunker.SmallParams
+		} else if *f.largeChunks {
+			params = cchunker.LargeParams
+		}
+		params.Polynomial, err = f.polynomial()
+		if err != nil {
+			return params, err
+		}
+	case "buzhash":
+		if *f.smallChunks || *f.largeChunks {
+			return params, fmt.Errorf("-small-chunks and -large-chunks can not be used with the buzhash algorithm")
+		}
+		params = cchunker.BuzhashParams
+		params.Seed = uint32(*f.buzhashSeed)
+	default:
+		return params, fmt.Errorf("unknown chunking algorithm %q", *f.algorithm)
+	}
+
+	if f.minSize != 0 {
+		params.MinSize = uint(f.minSize)
+	}
+	if f.maxSize != 0 {
+		params.MaxSize = uint(f.maxSize)
+	}
+	if f.avgSize != 0 {
+		if !(uint(f.avgSize) > params.MinSize && uint(f.avgSize) < params.MaxSize) {
+			return params, fmt.Errorf("chunk sizes must satisfy min %s < avg %s < max %s",
+				cchunker.FormatSize(uint64(params.MinSize)), f.avgSize.String(), cchunker.FormatSize(uint64(params.MaxSize)))
+		}
+		params.AverageBits = cchunker.AverageBitsForSize(uint64(f.avgSize))
+	}
+	if *f.avgBits != -1 {
+		if f.avgSize != 0 {
+			return params, fmt.Errorf("-avg-bits can not be used with -avg-size")
+		}
+		params.AverageBits = *f.avgBits
+	}
+	if *f.keyFile != "" {
+		params.Key, err = readKey(*f.keyFile)
+		if err != nil {
+			return params, err
+		}
+	}
+	params.TarAware = *f.tarAware
+	params.AnchorDelim, err = f.anchor()
+	if err != nil {
+		return params, err
+	}
+	return params, params.Validate()
+}
+
+// anchor returns the record delimiter given by -anchor-delim, which may
+// use Go string escapes such as \n or \x00.
+func (f *chunkFlags) anchor() ([]byte, error) {
+	if *f.anchorDelim == "" {
+		return nil, nil
+	}
+	delim, err := strconv.Unquote(`"` + strings.ReplaceAll(*f.anchorDelim, `"`, `\"`) + `"`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -anchor-delim %q: %s", *f.anchorDelim, err)
+	}
+	return []byte(delim), nil
+}
+
+// parseInterspersed parses args with fs, allowing flags to come after
+// positional arguments, and returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...)
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
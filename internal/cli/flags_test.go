@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func TestAvgBitsFlags(t *testing.T) {
+	for _, tc := range []struct {
+		args string
+		bits int
+	}{
+		{"", cchunker.StandardParams.AverageBits},
+		{"-algorithm buzhash", cchunker.BuzhashParams.AverageBits},
+		{"-algorithm buzhash -avg-bits 18", 18},
+		{"-algorithm buzhash -buzhash-mask-bits 18", 18},
+		// The last of the names given wins, whichever it is.
+		{"-buzhash-mask-bits 18 -avg-bits 19 -algorithm buzhash", 19},
+		{"-avg-bits 19 -buzhash-mask-bits 18 -algorithm buzhash", 18},
+		{"-avg-bits 21", 21},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		f := addChunkFlags(fs)
+		err := fs.Parse(strings.Fields(tc.args))
+		if err != nil {
+			t.Fatal(err)
+		}
+		params, err := f.params()
+		if err != nil {
+			t.Fatalf("%q: %s", tc.args, err)
+		}
+		if params.AverageBits != tc.bits {
+			t.Fatalf("%q: average bits %d, expected %d", tc.args, params.AverageBits, tc.bits)
+		}
+	}
+}
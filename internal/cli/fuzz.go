@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func fuzzMain(args []string) {
+	fs := flag.NewFlagSet("fuzz", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Check the invariants of the chunkers over random inputs and parameters: chunk sizes are")
+		fmt.Fprintln(os.Stderr, "within the min and max size, the chunks concatenate to the input, chunking is deterministic,")
+		fmt.Fprintln(os.Stderr, "and boundaries are stable once chunking resynchronizes after a prefix insertion. Each failure")
+		fmt.Fprintln(os.Stderr, "is printed with the seed reproducing it, and the exit code is non zero if there are any.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker fuzz [-iterations N | -duration D] [-seed N]")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	iterations := fs.Int("iterations", 100, "number of random cases to check")
+	duration := fs.Duration("duration", 0, "check random cases for this long instead of -iterations, e.g. 10m")
+	seed := fs.Uint64("seed", uint64(time.Now().UnixNano()), "seed of the first case, each later case uses the next seed")
+	var maxInput cchunker.Size = 4 << 20
+	fs.Var(&maxInput, "max-input", "largest random input to chunk")
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if fs.NArg() != 0 || *iterations < 1 || maxInput == 0 {
+		fs.Usage()
+	}
+
+	deadline := time.Now().Add(*duration)
+	failed := 0
+	checked := 0
+	for i := 0; ; i++ {
+		if *duration != 0 && !time.Now().Before(deadline) {
+			break
+		}
+		if *duration == 0 && i == *iterations {
+			break
+		}
+		err := fuzzCase(*seed+uint64(i), int64(maxInput))
+		if err != nil {
+			failed += 1
+			fmt.Printf("FAIL seed %d: %s\n", *seed+uint64(i), err)
+		}
+		checked += 1
+	}
+	slog.Info(fmt.Sprintf("checked %d cases from seed %d, %d failed", checked, *seed, failed))
+	if failed != 0 {
+		os.Exit(exitFailure)
+	}
+}
+
+// fuzzCase checks the chunker invariants for the parameters, input and
+// prefix picked by seed.
+func fuzzCase(seed uint64, maxInput int64) error {
+	params := cchunker.FuzzParams(seed)
+	size := int64(seed*0x9e3779b97f4a7c15>>1) % (maxInput + 1)
+	data, err := synthBytes(cchunker.SynthOptions{Size: size, Seed: seed, Mutate: 0.01, MutateSeed: seed})
+	if err != nil {
+		return err
+	}
+	prefix, err := synthBytes(cchunker.SynthOptions{Size: int64(seed % 4096), Seed: ^seed})
+	if err != nil {
+		return err
+	}
+	err = cchunker.CheckInvariants(data, prefix, params)
+	if err != nil {
+		return fmt.Errorf("%s, with %d bytes and params %+v", err, len(data), params)
+	}
+	return nil
+}
+
+func synthBytes(opts cchunker.SynthOptions) ([]byte, error) {
+	r, err := cchunker.NewSynthReader(opts)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, opts.Size)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
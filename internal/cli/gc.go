@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func gcMain(args []string) {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Remove the chunks of a chunk store not referenced by any MANIFEST, printing the id of")
+		fmt.Fprintln(os.Stderr, "each removed chunk. Every manifest still in use must be given, chunks only they reference")
+		fmt.Fprintln(os.Stderr, "are otherwise lost.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker gc -dir STORE [-flags...] MANIFEST...")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	dir := fs.String("dir", "", "chunk store directory")
+	hashName := fs.String("hash", "sha256", "hash naming each chunk, one of sha256 or blake3")
+	dryRun := fs.Bool("dry-run", false, "only print the chunks that would be removed")
+	grace := fs.Duration("grace", time.Hour, "keep chunks modified within this long, so chunks of runs still writing their manifest survive")
+	lf := addLogFlags(fs)
+
+	manifestPaths := parseInterspersed(fs, args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if *dir == "" || len(manifestPaths) == 0 {
+		fs.Usage()
+	}
+	_, err := cchunker.NewHash(*hashName)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	var manifests []io.Reader
+	for _, path := range manifestPaths {
+		f, err := os.Open(path)
+		if err != nil {
+			slog.Error("error opening manifest", "err", err)
+			os.Exit(exitCode(err))
+		}
+		defer f.Close()
+		manifests = append(manifests, f)
+	}
+	refs, err := cchunker.ReadManifestRefs(manifests...)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	store := &cchunker.Store{
+		Dir:  *dir,
+		Hash: *hashName,
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	result, err := store.GC(refs, cchunker.GCOptions{DryRun: *dryRun, Grace: *grace}, out)
+	if err2 := out.Flush(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	verb := "removed"
+	if *dryRun {
+		verb = "would remove"
+	}
+	slog.Info(fmt.Sprintf("%s %d chunks %d bytes, kept %d unreferenced chunks within the grace period", verb, result.Chunks, result.Bytes, result.Kept))
+}
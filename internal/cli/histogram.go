@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+// histogramFlags select printing a histogram of chunk sizes at the end
+// of a run.
+type histogramFlags struct {
+	enabled *bool
+	format  *string
+}
+
+func addHistogramFlags(fs *flag.FlagSet) *histogramFlags {
+	return &histogramFlags{
+		enabled: fs.Bool("histogram", false, "print a histogram of the chunk sizes to stderr at the end of the run"),
+		format:  fs.String("histogram-format", "text", "format of the -histogram, text or json"),
+	}
+}
+
+// histogram returns the histogram to record chunks in, or nil if it is
+// not enabled.
+func (f *histogramFlags) histogram() (*cchunker.SizeHistogram, error) {
+	if *f.format != "text" && *f.format != "json" {
+		return nil, fmt.Errorf("unknown -histogram-format %q, expected text or json", *f.format)
+	}
+	if !*f.enabled {
+		return nil, nil
+	}
+	return cchunker.NewSizeHistogram(), nil
+}
+
+// print prints h to stderr, with the average chunk size params aim for.
+func (f *histogramFlags) print(h *cchunker.SizeHistogram, params cchunker.Params) error {
+	buckets := h.Buckets()
+	expected := expectedAverageSize(params)
+
+	if *f.format == "json" {
+		buf, err := json.Marshal(struct {
+			Buckets         []cchunker.HistogramBucket `json:"buckets"`
+			ExpectedAverage uint64                     `json:"expected_average"`
+		}{buckets, expected})
+		if err != nil {
+			return fmt.Errorf("error encoding histogram: %s", err)
+		}
+		_, err = fmt.Fprintf(os.Stderr, "%s\n", buf)
+		return err
+	}
+
+	var most, chunks int64
+	for _, b := range buckets {
+		most = max(most, b.Count)
+		chunks += b.Count
+	}
+	fmt.Fprintf(os.Stderr, "chunk sizes, %d chunks, parameters expect an average of about %s:\n", chunks, cchunker.FormatSize(expected))
+	for _, b := range buckets {
+		bar := strings.Repeat("#", int((b.Count*50+most-1)/most))
+		fmt.Fprintf(os.Stderr, "%10s - %-10s %10d %s\n", cchunker.FormatSize(b.Min), cchunker.FormatSize(b.Max), b.Count, bar)
+	}
+	return nil
+}
+
+// expectedAverageSize is roughly the average chunk size params give on
+// random data, cuts are only looked for after the minimum size.
+func expectedAverageSize(params cchunker.Params) uint64 {
+	if params.Algorithm == "fixed" {
+		return uint64(params.MaxSize)
+	}
+	return min(uint64(params.MinSize)+1<<params.AverageBits, uint64(params.MaxSize))
+}
@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+// inputsFlag is the list of files given with -input.
+type inputsFlag []string
+
+func (f *inputsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *inputsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// first returns the first input, or "" for stdin if there are none.
+func (f *inputsFlag) first() string {
+	if len(*f) == 0 {
+		return ""
+	}
+	return (*f)[0]
+}
+
+func addInputFlag(fs *flag.FlagSet) *inputsFlag {
+	f := &inputsFlag{}
+	fs.Var(f, "input", "file to chunk instead of stdin, repeat it to chunk several files, each in its own manifest section")
+	return f
+}
+
+func addMaxChunksFlag(fs *flag.FlagSet) *int64 {
+	return fs.Int64("max-chunks", 0, "fail the run once the input is cut into more than this many chunks, 0 for no limit")
+}
+
+func addDecompressFlag(fs *flag.FlagSet) *string {
+	return fs.String("decompress", "none", "decompress the input before chunking, one of gzip, zstd, xz, none or auto to detect the format")
+}
+
+// decompressInput returns a reader of the input decompressed as format,
+// and a function releasing it. Without decompression the input itself is
+// returned, so the chunker can still tell it is a file.
+func decompressInput(input io.Reader, format string) (io.Reader, func(), error) {
+	if format == "none" {
+		return input, func() {}, nil
+	}
+	r, err := cchunker.NewDecompressReader(input, format)
+	if err != nil {
+		return nil, nil, &cchunker.InputError{Err: err}
+	}
+	return r, func() { r.Close() }, nil
+}
+
+// rangeFlags select a byte range of a seekable input to chunk, so huge
+// inputs can be split between several runs.
+type rangeFlags struct {
+	offset cchunker.Size
+	length cchunker.Size
+}
+
+func addRangeFlags(fs *flag.FlagSet) *rangeFlags {
+	f := &rangeFlags{}
+	fs.Var(&f.offset, "offset", "only chunk the input from this byte offset, e.g. 10GiB, chunk offsets are still from the start of the input")
+	fs.Var(&f.length, "length", "only chunk this many bytes of the input from -offset, e.g. 1GiB, instead of up to its end")
+	return f
+}
+
+// set reports if a range was given.
+func (f *rangeFlags) set() bool {
+	return f.offset != 0 || f.length != 0
+}
+
+// reader positions input at pos, the -offset or a resumed position after
+// it, returning a reader of the rest of the range.
+func (f *rangeFlags) reader(input io.ReadSeeker, pos uint64) (io.Reader, error) {
+	if pos != 0 {
+		_, err := input.Seek(int64(pos), io.SeekStart)
+		if err != nil {
+			return nil, &cchunker.InputError{Err: fmt.Errorf("-offset and -resume require a seekable input: %s", err)}
+		}
+	}
+	if f.length == 0 {
+		return input, nil
+	}
+	end := uint64(f.offset) + uint64(f.length)
+	if pos >= end {
+		return strings.NewReader(""), nil
+	}
+	return io.LimitReader(input, int64(end-pos)), nil
+}
+
+// openInput opens the file to chunk, stdin if path is empty or "-".
+func openInput(path string) (*os.File, error) {
+	if path == "" || path == "-" {
+		return os.Stdin, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, &cchunker.InputError{Err: fmt.Errorf("error opening input: %s", err)}
+	}
+	return f, nil
+}
+
+// forEachInput calls run with each input file in turn, or with stdin if
+// there are none. With more than one input, each is preceded by a call
+// to header with its path, so the output can be split into sections.
+func forEachInput(inputs inputsFlag, header func(path string) error, run func(input *os.File) error) error {
+	if len(inputs) == 0 {
+		return run(os.Stdin)
+	}
+	for _, path := range inputs {
+		if len(inputs) > 1 {
+			err := header(path)
+			if err != nil {
+				return err
+			}
+		}
+		input, err := openInput(path)
+		if err != nil {
+			return err
+		}
+		err = run(input)
+		if input != os.Stdin {
+			input.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/andrewchambers/cchunker"
+	"golang.org/x/term"
+)
+
+// readKey reads a hex key from source, prompt to prompt for it on the
+// terminal, or any source cchunker.ReadKeyFile takes.
+func readKey(source string) ([]byte, error) {
+	if source != "prompt" {
+		return cchunker.ReadKeyFile(source)
+	}
+	text, err := promptSecret("hex key")
+	if err != nil {
+		return nil, err
+	}
+	return cchunker.ParseKey(text)
+}
+
+// readSignKey reads an ed25519 private key from source, as readKey.
+func readSignKey(source string) (ed25519.PrivateKey, error) {
+	seed, err := readKey(source)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// promptSecret prompts for a secret named name on the terminal without
+// echoing it.
+func promptSecret(name string) ([]byte, error) {
+	in, out, err := openTerminal()
+	if err != nil {
+		return nil, fmt.Errorf("unable to prompt for %s: %s", name, err)
+	}
+	defer in.Close()
+	if out != in {
+		defer out.Close()
+	}
+	fmt.Fprintf(out, "%s: ", name)
+	secret, err := term.ReadPassword(int(in.Fd()))
+	fmt.Fprintln(out)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", name, err)
+	}
+	return secret, nil
+}
@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+// logFlags select the level and format of the messages logged to stderr.
+type logFlags struct {
+	fs     *flag.FlagSet
+	level  *string
+	format *string
+}
+
+func addLogFlags(fs *flag.FlagSet) *logFlags {
+	return &logFlags{
+		fs:     fs,
+		level:  fs.String("log-level", "info", "least severe messages to log, one of debug, info, warn or error, debug logs every chunk"),
+		format: fs.String("log-format", "text", "format of logged messages, text or json lines"),
+	}
+}
+
+// setup makes the flags the configuration of the default logger.
+func (f *logFlags) setup() error {
+	if collectFlags != nil {
+		// Every flag is defined once the flags are parsed, stop the
+		// command before it does anything.
+		collectFlags(f.fs)
+		runtime.Goexit()
+	}
+	var level slog.Level
+	err := level.UnmarshalText([]byte(*f.level))
+	if err != nil {
+		return &usageError{fmt.Errorf("unknown -log-level %q, expected debug, info, warn or error", *f.level)}
+	}
+	switch *f.format {
+	case "text":
+		slog.SetDefault(slog.New(newTextHandler(os.Stderr, level)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	default:
+		return &usageError{fmt.Errorf("unknown -log-format %q, expected text or json", *f.format)}
+	}
+	return nil
+}
+
+// processor logs the index, offset, size and processing time of each chunk
+// at debug level, returning p unchanged if debug messages aren't logged.
+func (f *logFlags) processor(p cchunker.Processor) cchunker.Processor {
+	if !slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		return p
+	}
+	return cchunker.ProcessorFunc(func(c cchunker.Chunk, out io.Writer) error {
+		start := time.Now()
+		err := p.Process(c, out)
+		attrs := []any{
+			"index", c.Index,
+			"offset", c.Offset,
+			"size", len(c.Data),
+			"duration", time.Since(start),
+		}
+		if err != nil {
+			attrs = append(attrs, "err", err)
+		}
+		slog.Debug("processed chunk", attrs...)
+		return err
+	})
+}
+
+// textHandler logs messages as plain lines, with any attributes after the
+// message as key=value, so the default output reads like an ordinary
+// command's. An "err" attribute is appended as ": err" like the messages of
+// fmt.Errorf. Messages other than info and error are prefixed with their level.
+type textHandler struct {
+	mu    *sync.Mutex
+	out   io.Writer
+	level slog.Level
+	attrs []slog.Attr
+}
+
+func newTextHandler(out io.Writer, level slog.Level) *textHandler {
+	return &textHandler{mu: &sync.Mutex{}, out: out, level: level}
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var line strings.Builder
+	if r.Level < slog.LevelInfo {
+		line.WriteString(strings.ToLower(r.Level.String()))
+		line.WriteString(": ")
+	} else if r.Level == slog.LevelWarn {
+		line.WriteString("warning: ")
+	}
+	line.WriteString(r.Message)
+	writeAttr := func(a slog.Attr) bool {
+		value := a.Value.Resolve().String()
+		if a.Key == "err" {
+			line.WriteString(": ")
+			line.WriteString(value)
+			return true
+		}
+		if strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&line, " %s=%s", a.Key, value)
+		return true
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	r.Attrs(writeAttr)
+	line.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.out, line.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &h2
+}
+
+// WithGroup is not used by cchunker, groups are flattened.
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	return h
+}
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func addMetricsFlag(fs *flag.FlagSet) *string {
+	return fs.String("metrics-listen", "", "address to serve Prometheus metrics on at /metrics, e.g. :9090")
+}
+
+// serveMetrics serves metrics at /metrics on addr in the background,
+// returning nil if addr is empty.
+func serveMetrics(addr string) (*cchunker.Metrics, error) {
+	if addr == "" {
+		return nil, nil
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen for metrics: %s", err)
+	}
+	metrics := cchunker.NewMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	go func() {
+		err := http.Serve(l, mux)
+		slog.Error("metrics server failed", "err", err)
+	}()
+	return metrics, nil
+}
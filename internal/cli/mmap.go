@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func addMmapFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("mmap", false, "memory map regular input files instead of reading them, saving syscalls and a copy on large files")
+}
+
+// mapInput returns a reader of input, reading from a memory mapping of it
+// if enabled, and a function releasing it. The mapping requires input to
+// be a regular file, which must not be truncated while it is chunked.
+func mapInput(input *os.File, enabled bool) (io.ReadSeeker, func(), error) {
+	if !enabled {
+		return input, func() {}, nil
+	}
+	info, err := input.Stat()
+	if err != nil {
+		return nil, nil, &cchunker.InputError{Err: fmt.Errorf("error mapping input: %s", err)}
+	}
+	if !info.Mode().IsRegular() {
+		return nil, nil, &usageError{fmt.Errorf("-mmap requires the input to be a regular file")}
+	}
+	if info.Size() == 0 {
+		return bytes.NewReader(nil), func() {}, nil
+	}
+	data, unmap, err := mmapFile(input, info.Size())
+	if err != nil {
+		return nil, nil, &cchunker.InputError{Err: fmt.Errorf("error mapping input: %s", err)}
+	}
+	return bytes.NewReader(data), unmap, nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package cli
+
+import (
+	"errors"
+	"os"
+)
+
+func mmapFile(f *os.File, size int64) ([]byte, func(), error) {
+	return nil, nil, errors.New("memory mapping is not supported on this system")
+}
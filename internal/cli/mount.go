@@ -0,0 +1,332 @@
+//go:build linux || darwin
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/andrewchambers/cchunker"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func mountMain(args []string) {
+	flags := flag.NewFlagSet("mount", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Mount the data of a manifest of chunks in a chunk store as a read only filesystem,")
+		fmt.Fprintln(flags.Output(), "fetching and verifying chunks as they are read. Data chunked with cchunker archive")
+		fmt.Fprintln(flags.Output(), "is shown as its directory tree, other data as a file named after its input, or data.")
+		fmt.Fprint(flags.Output(), "\n\n")
+		fmt.Fprintln(flags.Output(), "usage:")
+		fmt.Fprintln(flags.Output(), "cchunker mount MANIFEST MOUNTPOINT -dir STORE [-flags...]")
+		fmt.Fprintln(flags.Output(), "MANIFEST is the output of cchunker store, or - for stdin. Unmount it or interrupt")
+		fmt.Fprintln(flags.Output(), "cchunker mount to stop serving it.")
+		flags.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	dir := flags.String("dir", "", "chunk store directory")
+	hashName := flags.String("hash", "sha256", "hash naming each chunk, one of sha256 or blake3")
+	keyFile := flags.String("encrypt-keyfile", "", "key file the chunks were encrypted with, or fd:N, cmd:COMMAND or prompt")
+	raw := flags.Bool("raw", false, "show archive streams as files instead of their directory trees")
+	debug := flags.Bool("debug", false, "log every FUSE request to stderr")
+	lf := addLogFlags(flags)
+
+	positional := parseInterspersed(flags, args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if *dir == "" || len(positional) != 2 {
+		flags.Usage()
+	}
+	_, err := cchunker.NewHash(*hashName)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	var manifest io.Reader = os.Stdin
+	if positional[0] != "-" {
+		f, err := os.Open(positional[0])
+		if err != nil {
+			slog.Error("error opening manifest", "err", err)
+			os.Exit(exitCode(err))
+		}
+		defer f.Close()
+		manifest = f
+	}
+	sections, err := cchunker.ReadManifest(manifest)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	store := &cchunker.Store{
+		Dir:  *dir,
+		Hash: *hashName,
+	}
+	if *keyFile != "" {
+		store.Key, err = readKey(*keyFile)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+	}
+
+	root, err := newMountTree(store, sections, *raw)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	server, err := fs.Mount(positional[1], root, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:      "cchunker",
+			Name:        "cchunker",
+			Options:     []string{"ro"},
+			DirectMount: true,
+			Debug:       *debug,
+		},
+	})
+	if err != nil {
+		slog.Error("error mounting "+positional[1], "err", err)
+		os.Exit(exitCode(err))
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		err := server.Unmount()
+		if err != nil {
+			slog.Error("error unmounting "+positional[1], "err", err)
+		}
+	}()
+	server.Wait()
+}
+
+// mountNode is an entry of the mounted tree, built before mounting so
+// errors in the manifest or archives are reported up front.
+type mountNode struct {
+	attr     fuse.Attr
+	children map[string]*mountNode
+	target   string
+	data     *cchunker.StoreFile
+	offset   int64
+}
+
+func newMountDir(attr fuse.Attr) *mountNode {
+	attr.Mode = fuse.S_IFDIR | attr.Mode
+	return &mountNode{attr: attr, children: make(map[string]*mountNode)}
+}
+
+// newMountTree returns the root of the tree showing the data of each
+// manifest section.
+func newMountTree(store *cchunker.Store, sections []cchunker.ManifestSection, raw bool) (*mountRoot, error) {
+	defaults := fuse.Attr{
+		Mode:  0555,
+		Nlink: 1,
+		Owner: fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())},
+	}
+	root := newMountDir(defaults)
+	for _, section := range sections {
+		file := store.OpenSection(section)
+		name := mountPath(section.Path)
+		if !raw && cchunker.IsArchive(file) {
+			prefix := name
+			// A manifest of a single archive is shown as the tree itself.
+			if len(sections) == 1 {
+				prefix = ""
+			}
+			err := addMountArchive(root, prefix, file, defaults)
+			if err != nil {
+				return nil, fmt.Errorf("error reading archive %s: %s", name, err)
+			}
+			continue
+		}
+		attr := defaults
+		attr.Mode = fuse.S_IFREG | 0444
+		attr.Size = section.Size
+		addMountNode(root, name, &mountNode{attr: attr, data: file}, defaults)
+	}
+	return &mountRoot{mountDir: mountDir{node: root}}, nil
+}
+
+// mountPath returns the path in the mount of an input file path, kept
+// beneath the mount point.
+func mountPath(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+	if p == "" {
+		return "data"
+	}
+	return p
+}
+
+// addMountNode adds n at path p beneath root, creating any missing
+// parent directories with the default attributes.
+func addMountNode(root *mountNode, p string, n *mountNode, defaults fuse.Attr) {
+	parent := root
+	components := strings.Split(p, "/")
+	for _, component := range components[:len(components)-1] {
+		child, ok := parent.children[component]
+		if !ok || child.children == nil {
+			child = newMountDir(defaults)
+			parent.children[component] = child
+		}
+		parent = child
+	}
+	name := components[len(components)-1]
+	if existing, ok := parent.children[name]; ok && existing.children != nil && n.children != nil {
+		// Keep the entries already added beneath the directory.
+		existing.attr = n.attr
+		return
+	}
+	parent.children[name] = n
+}
+
+// addMountArchive adds the tree of the archive stream file beneath
+// prefix, or as root itself if prefix is empty. Only the chunks holding
+// entry headers are fetched.
+func addMountArchive(root *mountNode, prefix string, file *cchunker.StoreFile, defaults fuse.Attr) error {
+	ar, err := cchunker.NewArchiveReader(io.NewSectionReader(file, 0, file.Size()))
+	if err != nil {
+		return err
+	}
+	for {
+		entry, err := ar.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		attr := defaults
+		attr.Mode = entry.Mode & 07777
+		if entry.UID != nil && entry.GID != nil {
+			attr.Owner = fuse.Owner{Uid: *entry.UID, Gid: *entry.GID}
+		}
+		if entry.MtimeNs != nil {
+			attr.Mtime = uint64(*entry.MtimeNs / 1e9)
+			attr.Mtimensec = uint32(*entry.MtimeNs % 1e9)
+			attr.Atime, attr.Atimensec = attr.Mtime, attr.Mtimensec
+			attr.Ctime, attr.Ctimensec = attr.Mtime, attr.Mtimensec
+		}
+
+		var n *mountNode
+		switch entry.Type {
+		case cchunker.ArchiveDir:
+			n = newMountDir(attr)
+		case cchunker.ArchiveFile:
+			attr.Mode |= fuse.S_IFREG
+			attr.Size = uint64(entry.Size)
+			n = &mountNode{attr: attr, data: file, offset: ar.Offset()}
+		case cchunker.ArchiveSymlink:
+			attr.Mode |= fuse.S_IFLNK
+			attr.Size = uint64(len(entry.Target))
+			n = &mountNode{attr: attr, target: entry.Target}
+		default:
+			return fmt.Errorf("unknown archive entry type %q", entry.Type)
+		}
+
+		p := path.Join(prefix, entry.Path)
+		if p == "." {
+			root.attr = n.attr
+			continue
+		}
+		addMountNode(root, p, n, defaults)
+	}
+}
+
+// mountRoot is the root directory of the mount, which adds the rest of
+// the tree when it is mounted.
+type mountRoot struct {
+	mountDir
+}
+
+var _ = (fs.NodeOnAdder)((*mountRoot)(nil))
+
+func (r *mountRoot) OnAdd(ctx context.Context) {
+	addMountChildren(ctx, &r.Inode, r.node)
+}
+
+func addMountChildren(ctx context.Context, parent *fs.Inode, n *mountNode) {
+	for name, child := range n.children {
+		var node fs.InodeEmbedder
+		switch child.attr.Mode & syscall.S_IFMT {
+		case fuse.S_IFDIR:
+			node = &mountDir{node: child}
+		case fuse.S_IFLNK:
+			node = &fs.MemSymlink{Attr: child.attr, Data: []byte(child.target)}
+		default:
+			node = &mountFile{node: child}
+		}
+		inode := parent.NewPersistentInode(ctx, node, fs.StableAttr{Mode: child.attr.Mode & syscall.S_IFMT})
+		parent.AddChild(name, inode, true)
+		if child.children != nil {
+			addMountChildren(ctx, inode, child)
+		}
+	}
+}
+
+type mountDir struct {
+	fs.Inode
+	node *mountNode
+}
+
+var _ = (fs.NodeGetattrer)((*mountDir)(nil))
+
+func (d *mountDir) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Attr = d.node.attr
+	return 0
+}
+
+type mountFile struct {
+	fs.Inode
+	node *mountNode
+}
+
+var _ = (fs.NodeGetattrer)((*mountFile)(nil))
+var _ = (fs.NodeOpener)((*mountFile)(nil))
+var _ = (fs.NodeReader)((*mountFile)(nil))
+
+func (f *mountFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Attr = f.node.attr
+	return 0
+}
+
+func (f *mountFile) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	// The contents never change, so the kernel may keep them cached.
+	return nil, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (f *mountFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	size := int64(f.node.attr.Size)
+	if off >= size {
+		return fuse.ReadResultData(nil), 0
+	}
+	if int64(len(dest)) > size-off {
+		dest = dest[:size-off]
+	}
+	n, err := f.node.data.ReadAt(dest, f.node.offset+off)
+	if err != nil && err != io.EOF {
+		slog.Error(err.Error())
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
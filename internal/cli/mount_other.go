@@ -0,0 +1,21 @@
+//go:build !(linux || darwin)
+
+package cli
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+)
+
+func mountMain(args []string) {
+	fs := flag.NewFlagSet("mount", flag.ExitOnError)
+	lf := addLogFlags(fs)
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	slog.Error("cchunker mount is not supported on this system")
+	os.Exit(exitFailure)
+}
@@ -0,0 +1,336 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+	"github.com/restic/chunker"
+)
+
+// multiMain runs the multi command, name is how it was invoked for help text.
+func multiMain(name string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Iteratively do content defined chunking on data piped into stdin or read from -input,")
+		fmt.Fprintln(os.Stderr, "each subcommand prints a line per chunk, eventually the iteration will reduce the data to a single line")
+		fmt.Fprintln(os.Stderr, "This command is intended to be used as part of a backup tool")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintf(os.Stderr, "%s [-flags...] CHUNK PROCESSOR\n", name)
+		printProcessorHelp(fs)
+		fmt.Fprintln(os.Stderr, "CHUNK PROCESSOR must only print a single line to stdout, other output fails the run. With -record-sep nul")
+		fmt.Fprintln(os.Stderr, "its whole output is a record ended by a NUL byte instead, so it may print several lines or binary data without NUL bytes.")
+		fmt.Fprintln(os.Stderr, "-leaf-processor 'CMD' may be given instead of CHUNK PROCESSOR, -node-processor 'CMD' runs on the summary")
+		fmt.Fprintln(os.Stderr, "iterations instead, both are run with sh -c.")
+		fmt.Fprintln(os.Stderr, "The default are chunks with a min size 512 KiB, max size 16 MiB and and average of 4MiB")
+		fmt.Fprintln(os.Stderr, "On any IO or subprocess errors, multicchunker exits with a non zero exit code.")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	spillThreshold := cchunker.Size(64 * 1024 * 1024)
+	fs.Var(&spillThreshold, "spill-threshold", "size above which summary data is spilled to a temporary file, 0 to never spill")
+	var maxMemory cchunker.Size
+	fs.Var(&maxMemory, "max-memory", "bound the memory used for chunk and summary data, e.g. 256MiB, by lowering -jobs and spilling summaries earlier, 0 for no limit")
+	spillDir := fs.String("spill-dir", "", "directory for summary spill files, defaults to the system temporary directory")
+	summaryPolynomialInt := fs.Uint64("summary-polynomial", 0, "polynomial to use for summary iterations, defaults to -polynomial")
+	var summaryMinSize, summaryMaxSize cchunker.Size
+	fs.Var(&summaryMinSize, "summary-min-size", "minimum chunk size of the summary iterations, e.g. 4KiB, defaults to the data chunk sizes")
+	fs.Var(&summaryMaxSize, "summary-max-size", "maximum chunk size of the summary iterations, e.g. 64KiB, defaults to the data chunk sizes")
+	summaryAvgBits := fs.Int("summary-avg-bits", 0, "bits of the chunk split mask of the summary iterations, defaults to the data chunk sizes")
+	perLevelPolynomials := fs.Bool("per-level-polynomials", false, "derive a distinct polynomial for each summary iteration from the summary polynomial")
+	leafCmd := fs.String("leaf-processor", "", "shell command run on the chunks of the input data, instead of CHUNK PROCESSOR")
+	nodeCmd := fs.String("node-processor", "", "shell command run on the chunks of the summary iterations, defaults to the leaf processor")
+	treeOut := fs.String("tree-out", "", "write a JSON line describing every chunk of every iteration and its children to this file")
+	recordSep := fs.String("record-sep", "newline", "end of the summary records of each chunk, newline, or nul to allow processor output with newlines or binary data")
+	signKey := fs.String("sign-key", "", "append an ed25519 signature of the root summary made with the key in this file, or fd:N, cmd:COMMAND or prompt, see gen-sign-key")
+	withExtents := fs.Bool("with-extents", false, "prefix each summary record with the offset and length of its chunk in its iteration, separated by tabs")
+	provenance := fs.Bool("provenance", false, "start the root summary with a line recording the cchunker version and chunk parameters, off by default so summaries are the same across versions")
+	inputFiles := addInputFlag(fs)
+	elide := addElideZeroFlag(fs)
+	decompress := addDecompressFlag(fs)
+	useMmap := addMmapFlag(fs)
+	maxChunks := addMaxChunksFlag(fs)
+	statsFile := addStatsFlag(fs)
+	metricsAddr := addMetricsFlag(fs)
+	otlpEndpoint := addTracingFlag(fs)
+	epf := addErrorPolicyFlags(fs)
+	prf := addProcessorFlags(fs)
+	cf := addChunkFlags(fs)
+	pf := addProfileFlags(fs)
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	profileProcessor, err := pf.apply(fs)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	cmdArgs := fs.Args()
+	if *leafCmd != "" {
+		if len(cmdArgs) != 0 {
+			slog.Error("-leaf-processor can not be used with CHUNK PROCESSOR")
+			os.Exit(exitUsage)
+		}
+		cmdArgs = cchunker.ShellCommand(*leafCmd)
+	}
+	if len(cmdArgs) == 0 && !prf.builtin() {
+		cmdArgs = profileProcessor
+	}
+
+	if len(cmdArgs) == 0 && !prf.builtin() {
+		fs.Usage()
+	}
+
+	params, err := cf.params()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if len(*inputFiles) > 1 {
+		slog.Error(name + " reduces a single input to a summary, -input can only be given once")
+		os.Exit(exitUsage)
+	}
+	input, err := openInput(inputFiles.first())
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	src, _, err := mapInput(input, *useMmap)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	data, _, err := decompressInput(src, *decompress)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if *summaryAvgBits < 0 {
+		slog.Error("-summary-avg-bits must not be negative")
+		os.Exit(exitUsage)
+	}
+	params.SkipHoles = *elide
+	summaryParams := params
+	if summaryMinSize != 0 {
+		summaryParams.MinSize = uint(summaryMinSize)
+	}
+	if summaryMaxSize != 0 {
+		summaryParams.MaxSize = uint(summaryMaxSize)
+	}
+	if *summaryAvgBits != 0 {
+		summaryParams.AverageBits = *summaryAvgBits
+	}
+	err = summaryParams.Validate()
+	if err != nil {
+		slog.Error("invalid summary chunk sizes", "err", err)
+		os.Exit(exitCode(err))
+	}
+
+	summaryPolynomial := chunker.Pol(*summaryPolynomialInt)
+	if summaryPolynomial != 0 && !summaryPolynomial.Irreducible() {
+		slog.Error("summary polynomial is not irreducible, it is not suitable for content chunking")
+		os.Exit(exitPolynomial)
+	}
+
+	var nulRecords bool
+	switch *recordSep {
+	case "newline":
+	case "nul":
+		nulRecords = true
+	default:
+		slog.Error(fmt.Sprintf("unknown -record-sep %q, expected newline or nul", *recordSep))
+		os.Exit(exitUsage)
+	}
+
+	if *prf.emit != "" {
+		slog.Error("-emit writes no summary lines, it can not be used with " + name)
+		os.Exit(exitUsage)
+	}
+	prf.checkPrevious(params)
+
+	processor, closer, err := prf.processor(cmdArgs)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	processor, err = prf.wrap(processor)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	processor = elideZero(*elide, "raw", processor)
+
+	var nodeProcessor cchunker.Processor
+	if *nodeCmd != "" {
+		var nodeCloser io.Closer
+		nodeProcessor, nodeCloser, err = prf.nodeProcessor(cchunker.ShellCommand(*nodeCmd))
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+		if nodeCloser != nil {
+			if closer != nil {
+				closer = closeAll{closer, nodeCloser}
+			} else {
+				closer = nodeCloser
+			}
+		}
+		nodeProcessor, err = prf.wrap(nodeProcessor)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+	}
+	// wrapBoth applies wrap to the leaf and any node processor.
+	wrapBoth := func(wrap func(cchunker.Processor) cchunker.Processor) {
+		processor = wrap(processor)
+		if nodeProcessor != nil {
+			nodeProcessor = wrap(nodeProcessor)
+		}
+	}
+
+	wrapBoth(func(p cchunker.Processor) cchunker.Processor {
+		return cchunker.SummaryRecordProcessor(p, nulRecords)
+	})
+	if *withExtents {
+		sep := byte('\n')
+		if nulRecords {
+			sep = 0
+		}
+		wrapBoth(func(p cchunker.Processor) cchunker.Processor {
+			return cchunker.ExtentsProcessor(p, sep)
+		})
+	}
+	wrapBoth(lf.processor)
+
+	metrics, err := serveMetrics(*metricsAddr)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if metrics != nil {
+		wrapBoth(metrics.Processor)
+	}
+
+	traces, err := startTracing(*otlpEndpoint, name)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if traces != nil {
+		wrapBoth(traces.processor)
+	}
+
+	var recorder *cchunker.StatsRecorder
+	if *statsFile != "" {
+		recorder = cchunker.NewStatsRecorder()
+		wrapBoth(recorder.Processor)
+	}
+
+	failures, failuresCloser, err := epf.failureLog()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if failures != nil {
+		wrapBoth(failures.Processor)
+	}
+
+	m := cchunker.MultiLevelChunker{
+		Params:              params,
+		Processor:           processor,
+		NodeProcessor:       nodeProcessor,
+		Jobs:                *prf.jobs,
+		SummaryPolynomial:   summaryPolynomial,
+		SummaryMinSize:      uint(summaryMinSize),
+		SummaryMaxSize:      uint(summaryMaxSize),
+		SummaryAverageBits:  *summaryAvgBits,
+		PerLevelPolynomials: *perLevelPolynomials,
+		SpillThreshold:      int64(spillThreshold),
+		SpillDir:            *spillDir,
+		MaxMemory:           int64(maxMemory),
+		MaxChunks:           *maxChunks,
+		NulRecords:          nulRecords,
+	}
+
+	out, sign, err := signedOutput(*signKey)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if *provenance {
+		err = cchunker.WriteProvenance(out, params)
+		if err != nil {
+			slog.Error("error writing chunk output", "err", err)
+			os.Exit(exitCode(err))
+		}
+	}
+
+	var tree *bufio.Writer
+	var treeFile *os.File
+	if *treeOut != "" {
+		treeFile, err = os.Create(*treeOut)
+		if err != nil {
+			slog.Error("error creating tree file", "err", err)
+			os.Exit(exitCode(err))
+		}
+		tree = bufio.NewWriter(treeFile)
+		m.Tree = tree
+	}
+
+	signals := handleSignals()
+	m.Stop = signals.stop
+	err = m.Run(data, out)
+	if tree != nil {
+		// Keep the tree of the iterations done even if the run failed.
+		err2 := tree.Flush()
+		if err2 == nil {
+			err2 = treeFile.Close()
+		}
+		if err2 != nil && err == nil {
+			err = fmt.Errorf("error writing tree file: %s", err2)
+		}
+	}
+	if err == nil {
+		err = sign()
+	}
+	if recorder != nil {
+		if err2 := writeStats(*statsFile, recorder, err); err == nil {
+			err = err2
+		}
+	}
+	if failures != nil {
+		err = finishFailures(failures, failuresCloser, err)
+	}
+	if traces != nil {
+		if err2 := traces.finish(err); err == nil {
+			err = err2
+		}
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(signals.exitCode(err))
+	}
+
+	if closer != nil {
+		err = closer.Close()
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+	}
+}
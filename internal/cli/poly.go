@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/andrewchambers/cchunker"
+	"github.com/restic/chunker"
+)
+
+func genPolyMain(args []string) {
+	fs := flag.NewFlagSet("gen-poly", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Generate a new random chunking polynomial and print it on stdout.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker gen-poly")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+	}
+
+	p, err := chunker.RandomPolynomial()
+	if err != nil {
+		slog.Error("unable to generate polynomial", "err", err)
+		os.Exit(exitCode(err))
+	}
+
+	_, err = fmt.Printf("%d\n", uint64(p))
+	if err != nil {
+		slog.Error("unable to print polynomial", "err", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+func checkPolyMain(args []string) {
+	fs := flag.NewFlagSet("check-poly", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Check if the given polynomials are suitable for content chunking,")
+		fmt.Fprintln(os.Stderr, "exiting with a non zero exit code if any are not.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker check-poly POLYNOMIAL...")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+	}
+
+	for _, arg := range fs.Args() {
+		p, err := parsePolynomial(arg)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitPolynomial)
+		}
+		if !p.Irreducible() {
+			slog.Error("polynomial " + arg + " is not irreducible, it is not suitable for content chunking")
+			os.Exit(exitPolynomial)
+		}
+	}
+}
+
+// legacyPolyArgs finds the -new-polynomial and -check-polynomial flags
+// cchunker and multicchunker took before gen-poly and check-poly, returning
+// the command replacing them and its arguments, the -polynomial and
+// -summary-polynomial values, or the default polynomial if there are none.
+func legacyPolyArgs(args []string) (string, []string, bool) {
+	var newPoly, checkPoly bool
+	var polys []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" || !strings.HasPrefix(args[i], "-") {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		switch name {
+		case "new-polynomial", "check-polynomial":
+			set := true
+			if hasValue {
+				set, _ = strconv.ParseBool(value)
+			}
+			if name == "new-polynomial" {
+				newPoly = set
+			} else {
+				checkPoly = set
+			}
+		case "polynomial", "summary-polynomial":
+			if !hasValue && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			// A zero summary polynomial means -polynomial.
+			if name == "polynomial" || value != "0" {
+				polys = append(polys, value)
+			}
+		}
+	}
+	switch {
+	case newPoly:
+		return "gen-poly", nil, true
+	case checkPoly:
+		if len(polys) == 0 {
+			polys = []string{strconv.FormatUint(uint64(cchunker.DefaultPolynomial), 10)}
+		}
+		return "check-poly", polys, true
+	default:
+		return "", nil, false
+	}
+}
+
+// legacyPolyMain runs gen-poly or check-poly if args use the deprecated
+// -new-polynomial or -check-polynomial flags, reporting if they did.
+func legacyPolyMain(args []string) bool {
+	name, cmdArgs, ok := legacyPolyArgs(args)
+	if !ok {
+		return false
+	}
+	if name == "gen-poly" {
+		slog.Warn("-new-polynomial is deprecated, use cchunker gen-poly")
+		genPolyMain(cmdArgs)
+	} else {
+		slog.Warn("-check-polynomial is deprecated, use cchunker check-poly")
+		checkPolyMain(cmdArgs)
+	}
+	return true
+}
+
+// parsePolynomial parses a polynomial in decimal, or hex with a 0x prefix,
+// ignoring surrounding whitespace.
+func parsePolynomial(s string) (chunker.Pol, error) {
+	n, err := strconv.ParseUint(strings.TrimSpace(s), 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid polynomial %q", strings.TrimSpace(s))
+	}
+	return chunker.Pol(n), nil
+}
+
+// readPassphrase reads a passphrase from path, or if path is empty prompts
+// for it on the terminal without echoing it.
+func readPassphrase(path string) ([]byte, error) {
+	if path != "" {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading passphrase file: %s", err)
+		}
+		return bytes.TrimRight(buf, "\r\n"), nil
+	}
+
+	passphrase, err := promptSecret("passphrase")
+	if err != nil {
+		return nil, fmt.Errorf("%s, use -passphrase-file", err)
+	}
+	return passphrase, nil
+}
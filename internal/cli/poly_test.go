@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLegacyPolyArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args    string
+		name    string
+		cmdArgs string
+	}{
+		{"-new-polynomial", "gen-poly", ""},
+		{"--new-polynomial=true -polynomial 17", "gen-poly", ""},
+		{"-check-polynomial", "check-poly", "17349423945073011"},
+		{"-polynomial 0x3DA3358B4DC173 -check-polynomial", "check-poly", "0x3DA3358B4DC173"},
+		{"-check-polynomial -polynomial=5 -summary-polynomial 7", "check-poly", "5 7"},
+		{"-check-polynomial -summary-polynomial 0", "check-poly", "17349423945073011"},
+		{"-new-polynomial=false", "", ""},
+		{"-hash sha256", "", ""},
+		{"cat -new-polynomial", "", ""},
+	} {
+		name, cmdArgs, ok := legacyPolyArgs(strings.Fields(tc.args))
+		if ok != (tc.name != "") || name != tc.name || strings.Join(cmdArgs, " ") != tc.cmdArgs {
+			t.Errorf("%q: %q %q %v, expected %q %q", tc.args, name, cmdArgs, ok, tc.name, tc.cmdArgs)
+		}
+	}
+}
@@ -0,0 +1,465 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+// processorFlags are the flags shared by commands running a chunk processor.
+type processorFlags struct {
+	persistent *bool
+	jobs       *int
+	hashName   *string
+	grpcAddr   *string
+	backendURL *string
+	compress   *string
+	keyFile    *string
+	convergent *bool
+	previous   *string
+	dedupCache *string
+	bloomRate  *float64
+	rateLimit  *string
+	timeout    *time.Duration
+	retries    *int
+	backoff    *time.Duration
+	boundaries *bool
+	viaFile    *bool
+	chunkDir   *string
+	chunkOnFD  *bool
+	workers    *int
+	emit       *string
+	expect     *string
+	streamID   *string
+	skipHashes *string
+}
+
+func addProcessorFlags(fs *flag.FlagSet) *processorFlags {
+	return &processorFlags{
+		persistent: fs.Bool("persistent", false, "start the chunk processor once and send it length prefixed chunks on stdin"),
+		jobs:       fs.Int("jobs", 1, "number of chunk processors to run concurrently, output is still in chunk order"),
+		workers:    fs.Int("workers", 0, "start this many -persistent chunk processors once and process that many chunks concurrently, output is still in chunk order"),
+		boundaries: fs.Bool("boundaries-only", false, "run no chunk processor, only print an 'offset length cut-fingerprint' line per chunk"),
+		emit:       fs.String("emit", "", "run no chunk processor, write the chunks themselves to stdout, framed writes a big endian uint64 length then the data per chunk"),
+		hashName:   fs.String("hash", "", "hash chunks in process instead of running a chunk processor, one of sha256, blake3 or sha512-256"),
+		grpcAddr:   fs.String("processor-grpc", "", "address of a gRPC ChunkProcessor service to send chunks to instead of running a chunk processor"),
+		backendURL: fs.String("backend", "", "url of a backend to put chunks not already present in, keyed by -hash (default sha256), instead of running a chunk processor"),
+		compress:   fs.String("compress", "", "compress chunks before they reach the processor or backend, zstd or zstd:LEVEL"),
+		keyFile:    fs.String("encrypt-keyfile", "", "encrypt chunks with AES-256-GCM before they reach the processor or backend, using the hex key in this file, fd:N, cmd:COMMAND or prompt"),
+		previous:   fs.String("previous", "", "only run the processor for chunks not in this 'hash size offset' manifest of an earlier run"),
+		skipHashes: fs.String("skip-hashes", "", "only run the processor for chunks whose hash is not in this file of hashes, one per line, or Bloom filter from cchunker skip-filter"),
+		dedupCache: fs.String("dedup-cache", "", "database of processor output by chunk hash, chunks already in it are not processed again"),
+		bloomRate:  fs.Float64("dedup-cache-bloom", 0, "load the -dedup-cache ids into a Bloom filter with this false positive rate, e.g. 0.01, to skip disk lookups for new chunks"),
+		rateLimit:  fs.String("rate-limit", "", "limit the chunk bytes handed to the processor or backend to this rate, e.g. 10MiB/s"),
+		timeout:    fs.Duration("processor-timeout", 0, "kill a chunk processor command and its process group if it runs longer than this for a chunk, failing the chunk"),
+		retries:    fs.Int("retries", 0, "number of times to retry a chunk the processor or backend failed on before giving up"),
+		backoff:    fs.Duration("retry-backoff", time.Second, "wait before the first -retries retry, doubling for each retry after that"),
+		viaFile:    fs.Bool("chunk-via-file", false, "write each chunk to a temporary file, substituted for {file} or appended to CHUNK PROCESSOR, instead of its stdin"),
+		chunkDir:   fs.String("chunk-dir", "", "directory for -chunk-via-file files, defaults to /dev/shm if present, otherwise the system temporary directory"),
+		chunkOnFD:  fs.Bool("chunk-fd", false, "send the chunk data to CHUNK PROCESSOR on file descriptor 3 and a JSON line describing the chunk on its stdin"),
+		convergent: fs.Bool("convergent", false, "with -encrypt-keyfile, encrypt identical chunks identically so they still deduplicate"),
+		streamID:   fs.String("stream-id", "", "name of the stream, giving each chunk a sequence id from it, its index and hash, in CCHUNK_SEQUENCE_ID, for idempotent uploads"),
+		expect:     fs.String("expect-output", "", "fail a chunk whose processor output, without a trailing newline, doesn't match this regular expression, e.g. '^[0-9a-f]{64}$'"),
+	}
+}
+
+// builtin reports if the flags select a processor other than a command.
+func (f *processorFlags) builtin() bool {
+	return *f.hashName != "" || *f.grpcAddr != "" || *f.backendURL != "" || *f.boundaries || *f.emit != ""
+}
+
+// castr reports if the backend is a casync chunk store.
+func (f *processorFlags) castr() bool {
+	return strings.HasPrefix(*f.backendURL, "castr://")
+}
+
+// chunkHash returns the hash chunks are identified by, -hash or the
+// default of the backend.
+func (f *processorFlags) chunkHash() string {
+	switch {
+	case *f.hashName != "":
+		return *f.hashName
+	case f.castr():
+		return "sha512-256"
+	default:
+		return "sha256"
+	}
+}
+
+// processor returns the chunk processor for cmdArgs, and if it must be
+// closed when done, a closer for it.
+func (f *processorFlags) processor(cmdArgs []string) (cchunker.Processor, io.Closer, error) {
+	processor, closer, err := f.base(cmdArgs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	processor, err = f.limit(processor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashName := f.chunkHash()
+
+	// The chunks are hashed as they reach the processor, after wrap, so
+	// compressed or encrypted chunks would be matched by bytes that change
+	// with the compressor version or the nonce rather than the data.
+	if (*f.dedupCache != "" || *f.previous != "" || *f.skipHashes != "") && (*f.compress != "" || *f.keyFile != "") {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, nil, &usageError{fmt.Errorf("-dedup-cache, -previous and -skip-hashes can not be used with -compress or -encrypt-keyfile")}
+	}
+
+	if *f.dedupCache != "" {
+		cache, err := cchunker.OpenDedupCache(*f.dedupCache)
+		if err != nil {
+			return nil, nil, err
+		}
+		if *f.bloomRate != 0 {
+			err = cache.LoadBloomFilter(*f.bloomRate)
+			if err != nil {
+				cache.Close()
+				return nil, nil, err
+			}
+		}
+		// -hash and -backend lines hold the offset of the chunk, so
+		// they are written anew rather than repeated.
+		if *f.hashName != "" || *f.backendURL != "" {
+			processor, err = cache.ManifestProcessor(hashName, processor)
+		} else {
+			processor, err = cache.Processor(hashName, processor)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if closer != nil {
+			closer = closeAll{closer, cache}
+		} else {
+			closer = cache
+		}
+	}
+
+	if *f.previous != "" {
+		manifest, err := os.Open(*f.previous)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening previous manifest: %s", err)
+		}
+		defer manifest.Close()
+		previous, err := cchunker.ReadManifestIDs(manifest)
+		if err != nil {
+			return nil, nil, err
+		}
+		processor, err = cchunker.IncrementalProcessor(previous, hashName, processor)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if *f.skipHashes != "" {
+		hashes, err := os.Open(*f.skipHashes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening skip hashes: %s", err)
+		}
+		defer hashes.Close()
+		skip, err := cchunker.ReadHashSet(hashes)
+		if err != nil {
+			return nil, nil, err
+		}
+		processor, err = cchunker.SkipProcessor(skip, hashName, processor)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return processor, closer, nil
+}
+
+// nodeProcessor returns the processor for the summary iterations of
+// multicchunker running cmdArgs, only the flags about running commands
+// apply to it.
+func (f *processorFlags) nodeProcessor(cmdArgs []string) (cchunker.Processor, io.Closer, error) {
+	processor, closer, err := f.command(cmdArgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	processor, err = f.limit(processor)
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, nil, err
+	}
+	return processor, closer, nil
+}
+
+// limit applies the -expect-output, -rate-limit and -retries flags to p.
+func (f *processorFlags) limit(p cchunker.Processor) (cchunker.Processor, error) {
+	if *f.expect != "" {
+		re, err := regexp.Compile(*f.expect)
+		if err != nil {
+			return nil, &usageError{fmt.Errorf("invalid -expect-output: %s", err)}
+		}
+		// Checked inside -retries, so bad output is retried like
+		// any other failure.
+		p = cchunker.ExpectOutputProcessor(re, p)
+	}
+	if *f.rateLimit != "" {
+		rate, err := cchunker.ParseRate(*f.rateLimit)
+		if err != nil {
+			return nil, &usageError{fmt.Errorf("bad -rate-limit: %s", err)}
+		}
+		p = cchunker.NewRateLimiter(rate).Processor(p)
+	}
+	if *f.retries < 0 {
+		return nil, &usageError{fmt.Errorf("-retries must not be negative")}
+	}
+	if *f.retries > 0 {
+		p = cchunker.RetryProcessor(*f.retries, *f.backoff, os.Stderr, p)
+	}
+	return p, nil
+}
+
+// checkPrevious warns if the -previous manifest records chunk parameters
+// differing from params, as its chunks then rarely match.
+func (f *processorFlags) checkPrevious(params cchunker.Params) {
+	if *f.previous == "" {
+		return
+	}
+	manifest, err := os.Open(*f.previous)
+	if err != nil {
+		// Reported when the processor is made.
+		return
+	}
+	defer manifest.Close()
+	previous, ok, err := cchunker.ReadProvenance(manifest)
+	if err != nil || !ok {
+		return
+	}
+	err = previous.Compatible(cchunker.NewProvenance(params))
+	if err != nil {
+		slog.Warn("the -previous manifest was made with other chunk parameters, few of its chunks will match", "err", err)
+	}
+}
+
+// closeAll closes each closer in order, returning the first error.
+type closeAll []io.Closer
+
+func (c closeAll) Close() error {
+	var err error
+	for _, closer := range c {
+		if err2 := closer.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// base returns the processor selected by the flags, before any wrapping.
+func (f *processorFlags) base(cmdArgs []string) (cchunker.Processor, io.Closer, error) {
+	if len(cmdArgs) != 0 && *f.hashName != "" {
+		return nil, nil, &usageError{fmt.Errorf("-hash can not be used with a chunk processor")}
+	}
+	if len(cmdArgs) != 0 && *f.grpcAddr != "" {
+		return nil, nil, &usageError{fmt.Errorf("-processor-grpc can not be used with a chunk processor")}
+	}
+	if *f.grpcAddr != "" && (*f.hashName != "" || *f.persistent) {
+		return nil, nil, &usageError{fmt.Errorf("-processor-grpc can not be used with -hash or -persistent")}
+	}
+	if len(cmdArgs) != 0 && *f.backendURL != "" {
+		return nil, nil, &usageError{fmt.Errorf("-backend can not be used with a chunk processor")}
+	}
+	if *f.backendURL != "" && (*f.grpcAddr != "" || *f.persistent) {
+		return nil, nil, &usageError{fmt.Errorf("-backend can not be used with -processor-grpc or -persistent")}
+	}
+	if *f.boundaries && (len(cmdArgs) != 0 || *f.hashName != "" || *f.grpcAddr != "" || *f.backendURL != "" || *f.persistent) {
+		return nil, nil, &usageError{fmt.Errorf("-boundaries-only can not be used with a chunk processor, -hash, -processor-grpc, -backend or -persistent")}
+	}
+	if *f.emit != "" && (len(cmdArgs) != 0 || *f.hashName != "" || *f.grpcAddr != "" || *f.backendURL != "" || *f.boundaries || *f.persistent) {
+		return nil, nil, &usageError{fmt.Errorf("-emit can not be used with a chunk processor, -hash, -processor-grpc, -backend, -boundaries-only or -persistent")}
+	}
+	if *f.jobs < 1 {
+		return nil, nil, &usageError{fmt.Errorf("-jobs must be at least 1")}
+	}
+	if *f.workers < 0 {
+		return nil, nil, &usageError{fmt.Errorf("-workers must not be negative")}
+	}
+	if *f.workers != 0 {
+		if *f.jobs != 1 {
+			return nil, nil, &usageError{fmt.Errorf("-jobs can not be used with -workers, which processes one chunk per worker at a time")}
+		}
+		if f.builtin() {
+			return nil, nil, &usageError{fmt.Errorf("-workers can only be used with a chunk processor")}
+		}
+		*f.jobs = *f.workers
+		*f.persistent = true
+	}
+	if *f.jobs > 1 && *f.persistent && *f.workers == 0 {
+		return nil, nil, &usageError{fmt.Errorf("-jobs can not be used with -persistent")}
+	}
+	if *f.hashName != "" && *f.persistent {
+		return nil, nil, &usageError{fmt.Errorf("-hash can not be used with -persistent")}
+	}
+	if *f.viaFile && f.builtin() {
+		return nil, nil, &usageError{fmt.Errorf("-chunk-via-file can only be used with a chunk processor")}
+	}
+	if *f.chunkOnFD && f.builtin() {
+		return nil, nil, &usageError{fmt.Errorf("-chunk-fd can only be used with a chunk processor")}
+	}
+
+	if *f.backendURL != "" {
+		hashName := f.chunkHash()
+		if f.castr() {
+			// casync tools find chunks by their sha512-256 id, and
+			// expect them compressed only by the store.
+			if hashName != "sha512-256" {
+				return nil, nil, &usageError{fmt.Errorf("castr backends name chunks by their sha512-256 hash, -hash can not be %s", hashName)}
+			}
+			if *f.compress != "" || *f.keyFile != "" {
+				return nil, nil, &usageError{fmt.Errorf("-compress and -encrypt-keyfile can not be used with a castr backend, which compresses chunks itself")}
+			}
+		}
+		backend, err := cchunker.OpenBackend(*f.backendURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		processor, err := cchunker.BackendProcessor(backend, hashName)
+		closer, _ := backend.(io.Closer)
+		return processor, closer, err
+	}
+	if *f.boundaries {
+		return cchunker.BoundariesProcessor(), nil, nil
+	}
+	switch *f.emit {
+	case "":
+	case "framed":
+		return cchunker.FramedProcessor(), nil, nil
+	default:
+		return nil, nil, &usageError{fmt.Errorf("unknown -emit format %q, expected framed", *f.emit)}
+	}
+	if *f.hashName != "" {
+		processor, err := cchunker.HashProcessor(*f.hashName)
+		if err != nil {
+			return nil, nil, &usageError{fmt.Errorf("bad -hash: %s", err)}
+		}
+		return processor, nil, nil
+	}
+	if *f.grpcAddr != "" {
+		processor, err := cchunker.DialGRPCProcessor(*f.grpcAddr)
+		if err != nil {
+			return nil, nil, err
+		}
+		return processor, processor, nil
+	}
+	return f.command(cmdArgs)
+}
+
+// command returns the processor running the command cmdArgs.
+func (f *processorFlags) command(cmdArgs []string) (cchunker.Processor, io.Closer, error) {
+	if (*f.viaFile || *f.chunkOnFD) && *f.persistent {
+		return nil, nil, &usageError{fmt.Errorf("-chunk-via-file and -chunk-fd can not be used with -persistent")}
+	}
+	if *f.viaFile && *f.chunkOnFD {
+		return nil, nil, &usageError{fmt.Errorf("-chunk-via-file can not be used with -chunk-fd")}
+	}
+	if *f.chunkOnFD && runtime.GOOS == "windows" {
+		return nil, nil, &usageError{fmt.Errorf("-chunk-fd is not supported on windows, use -chunk-via-file")}
+	}
+	if *f.workers != 0 {
+		pool, err := cchunker.StartPersistentPool(cmdArgs, *f.workers, cchunker.PersistentOptions{Timeout: *f.timeout})
+		if err != nil {
+			return nil, nil, err
+		}
+		return pool, pool, nil
+	}
+	if *f.persistent {
+		processor, err := cchunker.StartPersistentProcessor(cmdArgs, cchunker.PersistentOptions{Timeout: *f.timeout})
+		if err != nil {
+			return nil, nil, err
+		}
+		return processor, processor, nil
+	}
+	if *f.chunkDir != "" && !*f.viaFile {
+		return nil, nil, &usageError{fmt.Errorf("-chunk-dir requires -chunk-via-file")}
+	}
+	opts := cchunker.ExecOptions{
+		Timeout:      *f.timeout,
+		ChunkViaFile: *f.viaFile,
+		ChunkDir:     *f.chunkDir,
+		ChunkOnFD:    *f.chunkOnFD,
+	}
+	return cchunker.ExecProcessor(cmdArgs, opts), nil, nil
+}
+
+// wrap applies the in process chunk transformations selected by the flags
+// to p, it must wrap any output formatting so the formatter sees the
+// transformed chunks.
+func (f *processorFlags) wrap(p cchunker.Processor) (cchunker.Processor, error) {
+	if *f.convergent && *f.keyFile == "" {
+		return nil, &usageError{fmt.Errorf("-convergent requires -encrypt-keyfile")}
+	}
+	// Chunks are compressed before they are encrypted, so the processors
+	// are wrapped in the reverse order.
+	if *f.keyFile != "" {
+		key, err := readKey(*f.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		p, err = cchunker.EncryptProcessor(key, *f.convergent, p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if *f.compress != "" {
+		var err error
+		p, err = cchunker.CompressProcessor(*f.compress, p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Sequence ids are of the data as it is in the stream.
+	if *f.streamID != "" {
+		p = cchunker.SequenceIDProcessor(*f.streamID, p)
+	}
+	return p, nil
+}
+
+func printProcessorHelp(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintln(out, "CHUNK PROCESSOR is a command+arguments that reads the chunk data on stdin and does an arbitrary action.")
+	fmt.Fprintln(out, "The placeholders {index}, {offset}, {size} and {sha256} in CHUNK PROCESSOR are replaced with")
+	fmt.Fprintln(out, "the chunk index, byte offset, size and hex sha256 digest before it is run.")
+	fmt.Fprintln(out, "CHUNK PROCESSOR is run with CCHUNK_INDEX, CCHUNK_OFFSET, CCHUNK_LENGTH and CCHUNK_CUT_FINGERPRINT set.")
+	fmt.Fprintln(out, "With -stream-id, CCHUNK_SEQUENCE_ID and the {seqid} placeholder are a sequence id of the chunk, for idempotent uploads.")
+	fmt.Fprintln(out, "With -chunk-via-file, the chunk is in a temporary file instead of stdin, its path replaces {file} in")
+	fmt.Fprintln(out, "CHUNK PROCESSOR, or is appended if there is no {file}, and is in CCHUNK_FILE.")
+	fmt.Fprintln(out, "With -chunk-fd, the chunk is on file descriptor 3 instead, and stdin is a JSON line with the index, offset,")
+	fmt.Fprintln(out, "length, cut_fingerprint and raw_length of the chunk.")
+	fmt.Fprintln(out, "With -persistent, CHUNK PROCESSOR is started once and reads each chunk from stdin as a big endian uint64")
+	fmt.Fprintln(out, "length followed by the chunk data, printing exactly one result line to stdout per chunk.")
+	fmt.Fprintln(out, "With -workers N, N such persistent processors are started and each chunk goes to an idle one.")
+	fmt.Fprintln(out, "With -boundaries-only, no CHUNK PROCESSOR is run, an 'offset length cut-fingerprint' line is printed per chunk.")
+	fmt.Fprintln(out, "With -emit framed, no CHUNK PROCESSOR is run, each chunk is written to stdout as a big endian uint64 length")
+	fmt.Fprintln(out, "followed by the chunk data.")
+	fmt.Fprintln(out, "With -hash, no CHUNK PROCESSOR is run, instead a 'hash size offset' line is printed per chunk.")
+	fmt.Fprintln(out, "With -processor-grpc, chunks are sent to a gRPC service implementing proto/processor.proto instead.")
+	fmt.Fprintln(out, "With -backend, each chunk is put in the backend if missing and a 'hash size offset' line is printed,")
+	fmt.Fprintln(out, "s3://bucket/prefix uses the usual AWS environment and accepts endpoint, region and path-style query parameters.")
+	fmt.Fprintln(out, "sftp://user@host/path uploads over one ssh connection using ssh-agent or the default keys in ~/.ssh.")
+	fmt.Fprintln(out, "dir:///path writes a local chunk store like cchunker store, dir:///path?fsync=false skips syncing to disk.")
+	fmt.Fprintln(out, "With -compress, chunks are compressed first and CCHUNK_RAW_LENGTH or a fourth manifest field gives the raw size.")
+	fmt.Fprintln(out, "With -previous MANIFEST, chunks whose -hash (default sha256) is in MANIFEST are not processed, a")
+	fmt.Fprintln(out, "'hash size offset' line is printed for them instead, so a processor printing such lines gives a full manifest.")
+	fmt.Fprintln(out, "With -dedup-cache PATH, the output of each chunk is saved by its hash and repeated for chunks seen before.")
+	fmt.Fprintln(out, "With -encrypt-keyfile, chunks are then encrypted, use -convergent to keep deduplication of identical chunks.")
+	fmt.Fprintln(out, "-previous, -skip-hashes and -dedup-cache can not be used with -compress or -encrypt-keyfile.")
+	fmt.Fprintln(out, "With -rate-limit, chunks skipped by -previous or -dedup-cache do not count towards the rate.")
+}
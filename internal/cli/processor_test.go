@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestProcessorFlagErrorsAreUsageErrors(t *testing.T) {
+	for _, tc := range []struct {
+		args    []string
+		cmdArgs []string
+	}{
+		{[]string{"-hash", "sha256"}, []string{"cat"}},
+		{[]string{"-jobs", "0"}, []string{"cat"}},
+		{[]string{"-retries", "-1"}, []string{"cat"}},
+		{[]string{"-rate-limit", "fast"}, []string{"cat"}},
+		{[]string{"-chunk-via-file", "-chunk-fd"}, []string{"cat"}},
+		{[]string{"-hash", "sha256", "-previous", "manifest", "-compress", "zstd"}, nil},
+		{[]string{"-hash", "sha256", "-skip-hashes", "hashes", "-encrypt-keyfile", "key"}, nil},
+		{[]string{"-dedup-cache", "cache", "-encrypt-keyfile", "key", "-convergent"}, []string{"cat"}},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		f := addProcessorFlags(fs)
+		err := fs.Parse(tc.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, closer, err := f.processor(tc.cmdArgs)
+		if closer != nil {
+			closer.Close()
+		}
+		if err == nil {
+			t.Fatalf("%v: expected an error", tc.args)
+		}
+		if code := exitCode(err); code != exitUsage {
+			t.Fatalf("%v: %q exits with %d, expected %d", tc.args, err, code, exitUsage)
+		}
+	}
+}
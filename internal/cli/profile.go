@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+// profileFlags select a named profile from a configuration file.
+//
+// A configuration file is TOML with a table per profile, each key is the
+// name of a command line flag, plus an optional processor array holding the
+// chunk processor command:
+//
+//	[profiles.backup]
+//	polynomial = 16869119511044249
+//	avg-size = "1MiB"
+//	jobs = 4
+//	processor = ["sh", "-c", "cat > /store/{sha256}"]
+//
+// Flags given on the command line take precedence over the profile, keys
+// naming flags the running command does not have are ignored.
+type profileFlags struct {
+	config  *string
+	profile *string
+}
+
+// defaultConfigPath returns the default configuration file path.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cchunker", "config.toml")
+}
+
+// addProfileFlags registers the -config and -profile flags on fs.
+func addProfileFlags(fs *flag.FlagSet) *profileFlags {
+	return &profileFlags{
+		config:  fs.String("config", defaultConfigPath(), "configuration file containing named profiles"),
+		profile: fs.String("profile", "", "named profile from the configuration file to use as flag defaults"),
+	}
+}
+
+type configFile struct {
+	Profiles map[string]map[string]interface{} `toml:"profiles"`
+}
+
+// apply sets every flag of fs named by the selected profile that was not
+// given on the command line, and returns the profile processor command.
+// It must be called after fs has been parsed. Errors are usage errors.
+func (f *profileFlags) apply(fs *flag.FlagSet) ([]string, error) {
+	processor, err := f.load(fs)
+	if err != nil {
+		return nil, &usageError{err}
+	}
+	return processor, nil
+}
+
+func (f *profileFlags) load(fs *flag.FlagSet) ([]string, error) {
+	if *f.profile == "" {
+		return nil, nil
+	}
+
+	var cfg configFile
+	_, err := toml.DecodeFile(*f.config, &cfg)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("config file %s does not exist", *f.config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %s", err)
+	}
+
+	profile, ok := cfg.Profiles[*f.profile]
+	if !ok {
+		return nil, fmt.Errorf("profile %q not found in %s", *f.profile, *f.config)
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(fl *flag.Flag) {
+		explicit[fl.Name] = true
+	})
+
+	var processor []string
+	for key, value := range profile {
+		if key == "processor" {
+			values, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("profile %q processor must be an array of strings", *f.profile)
+			}
+			for _, v := range values {
+				s, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("profile %q processor must be an array of strings", *f.profile)
+				}
+				processor = append(processor, s)
+			}
+			continue
+		}
+
+		if fs.Lookup(key) == nil || explicit[key] || key == "config" || key == "profile" {
+			continue
+		}
+
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		case int64, bool:
+			s = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("profile %q key %s has an unsupported value", *f.profile, key)
+		}
+		err = fs.Set(key, s)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q key %s: %s", *f.profile, key, err)
+		}
+	}
+
+	return processor, nil
+}
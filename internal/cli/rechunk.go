@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func rechunkMain(args []string) {
+	fs := flag.NewFlagSet("rechunk", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Read the data of a manifest back out of a chunk store and chunk it again with new chunk")
+		fmt.Fprintln(os.Stderr, "parameters, storing the new chunks and printing a new manifest, so a store converges on new")
+		fmt.Fprintln(os.Stderr, "sizes or a new polynomial. Every old chunk is verified as it is read. Once every manifest is")
+		fmt.Fprintln(os.Stderr, "rechunked, cchunker gc with the new manifests removes the old chunks.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker rechunk OLD_MANIFEST -dir STORE [-flags...]")
+		fmt.Fprintln(os.Stderr, "OLD_MANIFEST is the output of cchunker store, or - for stdin.")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	dir := fs.String("dir", "", "chunk store directory to read the old chunks from")
+	to := fs.String("to", "", "chunk store directory to write the new chunks to, defaults to -dir")
+	hashName := fs.String("hash", "sha256", "hash naming each chunk, one of sha256 or blake3")
+	keyFile := fs.String("encrypt-keyfile", "", "key file the old chunks were encrypted with, or fd:N, cmd:COMMAND or prompt, new chunks are not encrypted")
+	jobs := fs.Int("jobs", 1, "number of chunks to store concurrently")
+	fsync := fs.Bool("fsync", true, "sync each new chunk and its directory to disk before it is printed in the manifest")
+	var packThreshold cchunker.Size
+	fs.Var(&packThreshold, "pack-threshold", "append chunks smaller than this, e.g. 64KiB, to pack files instead of writing a file each")
+	elide := addElideZeroFlag(fs)
+	maxChunks := addMaxChunksFlag(fs)
+	cf := addChunkFlags(fs)
+	pf := addProfileFlags(fs)
+	lf := addLogFlags(fs)
+
+	positional := parseInterspersed(fs, args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	_, err := pf.apply(fs)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if *dir == "" || len(positional) != 1 {
+		fs.Usage()
+	}
+	if *to == "" {
+		*to = *dir
+	}
+	if *jobs < 1 {
+		slog.Error("-jobs must be at least 1")
+		os.Exit(exitUsage)
+	}
+	_, err = cchunker.NewHash(*hashName)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	params, err := cf.params()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	params.SkipHoles = *elide
+
+	var manifest io.Reader = os.Stdin
+	if positional[0] != "-" {
+		f, err := os.Open(positional[0])
+		if err != nil {
+			slog.Error("error opening manifest", "err", err)
+			os.Exit(exitCode(err))
+		}
+		defer f.Close()
+		manifest = f
+	}
+	sections, err := cchunker.ReadManifest(manifest)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	src := &cchunker.Store{
+		Dir:  *dir,
+		Hash: *hashName,
+	}
+	if *keyFile != "" {
+		src.Key, err = readKey(*keyFile)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+	}
+	dst := &cchunker.Store{
+		Dir:           *to,
+		Hash:          *hashName,
+		Sync:          *fsync,
+		PackThreshold: int(packThreshold),
+	}
+
+	p := cchunker.Pipeline{
+		Params:    params,
+		Processor: lf.processor(elideZero(*elide, "raw", dst.Processor())),
+		Jobs:      *jobs,
+		MaxChunks: *maxChunks,
+	}
+
+	signals := handleSignals()
+	p.Stop = signals.stop
+	for _, section := range sections {
+		if section.Path != "" {
+			err = cchunker.WriteFileHeader(os.Stdout, section.Path)
+			if err != nil {
+				break
+			}
+		}
+		// Chunks of a range keep their offsets in the input.
+		p.Resume = cchunker.Checkpoint{Offset: section.Offset}
+		data := src.OpenSection(section)
+		_, err = p.Run(io.NewSectionReader(data, 0, data.Size()), os.Stdout)
+		if err != nil {
+			break
+		}
+	}
+	if err2 := dst.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(signals.exitCode(err))
+	}
+}
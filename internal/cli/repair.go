@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func repairMain(args []string) {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Rebuild the missing or corrupt chunks of a manifest stored with -parity from the surviving")
+		fmt.Fprintln(os.Stderr, "chunks and parity chunks of their group, printing a 'repaired ID' line for each rebuilt chunk.")
+		fmt.Fprintln(os.Stderr, "Groups that lost more chunks than they have parity chunks are printed as 'unrecoverable ID'")
+		fmt.Fprintln(os.Stderr, "lines, and the exit code is non zero if there are any.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker repair MANIFEST -dir STORE [-flags...]")
+		fmt.Fprintln(os.Stderr, "MANIFEST is the output of cchunker store -parity, or - for stdin.")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	dir := fs.String("dir", "", "chunk store directory")
+	hashName := fs.String("hash", "sha256", "hash naming each chunk, one of sha256 or blake3")
+	fsync := fs.Bool("fsync", true, "sync each rebuilt chunk and its directory to disk")
+	lf := addLogFlags(fs)
+
+	positional := parseInterspersed(fs, args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if *dir == "" || len(positional) != 1 {
+		fs.Usage()
+	}
+	_, err := cchunker.NewHash(*hashName)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	var manifest io.Reader = os.Stdin
+	if positional[0] != "-" {
+		f, err := os.Open(positional[0])
+		if err != nil {
+			slog.Error("error opening manifest", "err", err)
+			os.Exit(exitCode(err))
+		}
+		defer f.Close()
+		manifest = f
+	}
+
+	store := &cchunker.Store{
+		Dir:  *dir,
+		Hash: *hashName,
+		Sync: *fsync,
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	result, err := store.Repair(manifest, out)
+	if err2 := out.Flush(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if result.Groups == 0 {
+		slog.Error("the manifest has no parity groups, it was not stored with -parity")
+		os.Exit(exitFailure)
+	}
+	slog.Info(fmt.Sprintf("checked %d parity groups, %d chunks repaired, %d unrecoverable", result.Groups, result.Repaired, result.Unrecoverable))
+	if result.Unrecoverable != 0 {
+		os.Exit(exitFailure)
+	}
+}
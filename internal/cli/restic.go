@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func exportResticMain(args []string) {
+	fs := flag.NewFlagSet("export-restic", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Write the chunks of a manifest in a sha256 chunk store as a restic repository index, in the")
+		fmt.Fprintln(os.Stderr, "JSON format restic encrypts into its index files. Chunk ids only match restic's blob ids when")
+		fmt.Fprintln(os.Stderr, "chunked with -small-chunks and the -polynomial of the restic repository.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker export-restic MANIFEST -dir STORE")
+		fmt.Fprintln(os.Stderr, "MANIFEST is the output of cchunker store, or - for stdin.")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	dir := fs.String("dir", "", "chunk store directory")
+	lf := addLogFlags(fs)
+
+	positional := parseInterspersed(fs, args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if *dir == "" || len(positional) != 1 {
+		fs.Usage()
+	}
+
+	var manifest io.Reader = os.Stdin
+	if positional[0] != "-" {
+		f, err := os.Open(positional[0])
+		if err != nil {
+			slog.Error("error opening manifest", "err", err)
+			os.Exit(exitCode(err))
+		}
+		defer f.Close()
+		manifest = f
+	}
+	sections, err := cchunker.ReadManifest(manifest)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	store := &cchunker.Store{
+		Dir:  *dir,
+		Hash: "sha256",
+	}
+	index, err := store.ResticIndex(sections)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(index)
+	if err != nil {
+		slog.Error("error writing restic index", "err", err)
+		os.Exit(exitCode(err))
+	}
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func selftestMain(args []string) {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Chunk built in deterministic pseudo-random data with each chunking algorithm and compare")
+		fmt.Fprintln(os.Stderr, "the boundaries and cut fingerprints with known good values, printing an 'ok NAME' or")
+		fmt.Fprintln(os.Stderr, "'FAIL NAME ...' line per case and exiting non zero if any fail. A build that fails")
+		fmt.Fprintln(os.Stderr, "cuts different chunks, which won't deduplicate against data chunked by other builds.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker selftest")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+	}
+
+	failed, err := cchunker.SelfTest(os.Stdout)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if failed != 0 {
+		slog.Error(fmt.Sprintf("%d self test cases failed", failed))
+		os.Exit(exitFailure)
+	}
+}
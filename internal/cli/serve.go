@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func serveMain(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Serve content defined chunking over a unix socket or windows named pipe, HTTP, or both.")
+		fmt.Fprintln(os.Stderr, "Clients send streams as frames of a big endian uint64 length followed by the data,")
+		fmt.Fprintln(os.Stderr, "a zero length frame ends a stream. For each chunk a line of JSON with the index, offset,")
+		fmt.Fprintln(os.Stderr, "length and cut fingerprint is sent back, followed by {\"end\":true,\"chunks\":N}.")
+		fmt.Fprintln(os.Stderr, "Over HTTP, the body of a POST to /chunk is chunked and the response is a JSON manifest")
+		fmt.Fprintln(os.Stderr, "{\"chunks\":[...]} of the same records.")
+		fmt.Fprintln(os.Stderr, "With -dir, chunk data is also written to a content addressed store and records include its id.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker serve [-socket PATH] [-http ADDR] [-flags...]")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	socket := fs.String("socket", "", "path of the unix socket to listen on, or on windows a named pipe such as \\\\.\\pipe\\cchunker")
+	httpAddr := fs.String("http", "", "address to serve HTTP on, e.g. :8080")
+	dir := fs.String("dir", "", "chunk store directory to write chunk data to")
+	hashName := fs.String("hash", "sha256", "hash naming each stored chunk, one of sha256 or blake3")
+	metricsAddr := addMetricsFlag(fs)
+	cf := addChunkFlags(fs)
+	pf := addProfileFlags(fs)
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	_, err := pf.apply(fs)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if (*socket == "" && *httpAddr == "") || fs.NArg() != 0 {
+		fs.Usage()
+	}
+
+	params, err := cf.params()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	s := &cchunker.Server{
+		Params: params,
+	}
+	if *dir != "" {
+		_, err = cchunker.NewHash(*hashName)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+		s.Store = &cchunker.Store{
+			Dir:  *dir,
+			Hash: *hashName,
+		}
+	}
+
+	s.Metrics, err = serveMetrics(*metricsAddr)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	errc := make(chan error, 2)
+	if *socket != "" {
+		var l net.Listener
+		if cchunker.IsPipeName(*socket) {
+			l, err = cchunker.ListenPipe(*socket)
+		} else {
+			l, err = net.Listen("unix", *socket)
+		}
+		if err != nil {
+			slog.Error("unable to listen", "err", err)
+			os.Exit(exitCode(err))
+		}
+		go func() {
+			errc <- s.Serve(l)
+		}()
+	}
+	if *httpAddr != "" {
+		l, err := net.Listen("tcp", *httpAddr)
+		if err != nil {
+			slog.Error("unable to listen", "err", err)
+			os.Exit(exitCode(err))
+		}
+		go func() {
+			errc <- http.Serve(l, s)
+		}()
+	}
+
+	err = <-errc
+	slog.Error(err.Error())
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+// signedOutput returns the writer a manifest should be written to, and a
+// function that appends its signature once it is complete. Without a key
+// file the manifest is written to stdout unsigned.
+func signedOutput(keyFile string) (io.Writer, func() error, error) {
+	if keyFile == "" {
+		return os.Stdout, func() error { return nil }, nil
+	}
+	key, err := readSignKey(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer := cchunker.NewManifestSigner(os.Stdout, key)
+	return signer, signer.Sign, nil
+}
+
+func genSignKeyMain(args []string) {
+	fs := flag.NewFlagSet("gen-sign-key", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Generate a new ed25519 manifest signing key, writing it to KEYFILE")
+		fmt.Fprintln(os.Stderr, "and printing the public key for cchunker verify-manifest on stdout.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker gen-sign-key KEYFILE")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+	}
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		slog.Error("unable to generate key", "err", err)
+		os.Exit(exitCode(err))
+	}
+
+	f, err := os.OpenFile(fs.Arg(0), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		slog.Error("unable to create key file", "err", err)
+		os.Exit(exitCode(err))
+	}
+	_, err = fmt.Fprintf(f, "%x\n", key.Seed())
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		slog.Error("unable to write key file", "err", err)
+		os.Exit(exitCode(err))
+	}
+
+	_, err = fmt.Printf("%x\n", pub)
+	if err != nil {
+		slog.Error("unable to print public key", "err", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+func verifyManifestMain(args []string) {
+	fs := flag.NewFlagSet("verify-manifest", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Verify a manifest was signed with -sign-key by the holder of PUBLIC KEY,")
+		fmt.Fprintln(os.Stderr, "exiting with a non zero exit code if it was not.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker verify-manifest -public-key PUBLIC KEY MANIFEST")
+		fmt.Fprintln(os.Stderr, "MANIFEST is a signed manifest, or - for stdin.")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	publicKey := fs.String("public-key", "", "hex public key printed by cchunker gen-sign-key")
+	lf := addLogFlags(fs)
+
+	positional := parseInterspersed(fs, args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if *publicKey == "" || len(positional) != 1 {
+		fs.Usage()
+	}
+	pub, err := hex.DecodeString(*publicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		slog.Error("-public-key must be 64 hex characters")
+		os.Exit(exitUsage)
+	}
+
+	var manifest io.Reader = os.Stdin
+	if positional[0] != "-" {
+		f, err := os.Open(positional[0])
+		if err != nil {
+			slog.Error("error opening manifest", "err", err)
+			os.Exit(exitCode(err))
+		}
+		defer f.Close()
+		manifest = f
+	}
+
+	err = cchunker.VerifyManifest(manifest, pub)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+}
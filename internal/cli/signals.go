@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+// signalHandler stops a run gracefully on SIGINT or SIGTERM, the chunks
+// being processed are finished so their output and any checkpoint are
+// written. A second signal kills the running processors and exits
+// immediately.
+type signalHandler struct {
+	stop chan struct{}
+
+	mu     sync.Mutex
+	signal os.Signal
+}
+
+func handleSignals() *signalHandler {
+	h := &signalHandler{stop: make(chan struct{})}
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		h.mu.Lock()
+		h.signal = sig
+		h.mu.Unlock()
+		slog.Warn(fmt.Sprintf("received %s, finishing the chunks in progress, signal again to exit now", sig))
+		close(h.stop)
+		<-signals
+		cchunker.KillProcessors()
+		os.Exit(exitCodeForSignal(sig))
+	}()
+	return h
+}
+
+// exitCode is the exit code for a run failed with err, which is 128 plus
+// the signal number if the run was stopped by a signal.
+func (h *signalHandler) exitCode(err error) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.signal == nil {
+		return exitCode(err)
+	}
+	return exitCodeForSignal(h.signal)
+}
+
+func exitCodeForSignal(sig os.Signal) int {
+	if sig, ok := sig.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return exitFailure
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func skipFilterMain(args []string) {
+	fs := flag.NewFlagSet("skip-filter", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Write a Bloom filter of the chunk hashes in each FILE, or stdin, to stdout, for -skip-hashes.")
+		fmt.Fprintln(os.Stderr, "Each line of FILE has a hash as its first field, so FILE may be a list of hashes or a manifest.")
+		fmt.Fprintln(os.Stderr, "The filter is far smaller than the list, but a false positive skips a chunk that is missing.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker skip-filter [-flags...] [FILE...]")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	rate := fs.Float64("rate", 0.0001, "false positive rate of the filter, the chance a hash not given is reported present")
+	lf := addLogFlags(fs)
+
+	paths := parseInterspersed(fs, args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if *rate <= 0 || *rate >= 1 {
+		slog.Error("-rate must be between 0 and 1")
+		os.Exit(exitUsage)
+	}
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	ids := make(map[string]bool)
+	for _, path := range paths {
+		var r io.Reader = os.Stdin
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				slog.Error("error opening hashes", "err", err)
+				os.Exit(exitInput)
+			}
+			defer f.Close()
+			r = f
+		}
+		fileIDs, err := cchunker.ReadManifestIDs(r)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitInput)
+		}
+		for id := range fileIDs {
+			ids[id] = true
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	err := cchunker.WriteBloomFilter(out, ids, *rate)
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	slog.Info(fmt.Sprintf("wrote a filter of %d hashes", len(ids)))
+}
@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func splitMain(args []string) {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Chunk data piped into stdin or read from -input, writing each chunk to its own sequentially")
+		fmt.Fprintln(os.Stderr, "numbered file in -out, a content defined replacement for split(1). cat the files in order to")
+		fmt.Fprintln(os.Stderr, "restore the data. With -link-dedup, chunk files are hard links to a copy of each distinct chunk")
+		fmt.Fprintln(os.Stderr, "kept in -link-dir, so chunks repeated within or across runs take their space once.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker split -out DIR [-pattern chunk-%08d] [-flags...]")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	outDir := fs.String("out", "", "directory to write the chunk files to, created if missing")
+	pattern := fs.String("pattern", "chunk-%08d", "name of each chunk file, formatting the chunk number from zero")
+	jobs := fs.Int("jobs", 1, "number of chunk files to write concurrently")
+	linkDedup := fs.Bool("link-dedup", false, "hard link each chunk file to a read only copy of the chunk, named by its hash, written once")
+	linkDir := fs.String("link-dir", "", "directory of the -link-dedup chunk copies, on the same filesystem as -out, defaults to .chunks in -out")
+	inputFiles := addInputFlag(fs)
+	decompress := addDecompressFlag(fs)
+	useMmap := addMmapFlag(fs)
+	maxChunks := addMaxChunksFlag(fs)
+	cf := addChunkFlags(fs)
+	pf := addProfileFlags(fs)
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	_, err := pf.apply(fs)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if *outDir == "" || fs.NArg() != 0 {
+		fs.Usage()
+	}
+	if *jobs < 1 {
+		slog.Error("-jobs must be at least 1")
+		os.Exit(exitUsage)
+	}
+	if len(*inputFiles) > 1 {
+		slog.Error("split numbers the chunks of a single input, -input can only be given once")
+		os.Exit(exitUsage)
+	}
+
+	params, err := cf.params()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if *linkDir != "" && !*linkDedup {
+		slog.Error("-link-dir requires -link-dedup")
+		os.Exit(exitUsage)
+	}
+	if *linkDir == "" {
+		*linkDir = filepath.Join(*outDir, ".chunks")
+	}
+
+	var processor cchunker.Processor
+	if *linkDedup {
+		processor, err = cchunker.SplitLinkProcessor(*outDir, *pattern, *linkDir)
+	} else {
+		processor, err = cchunker.SplitProcessor(*outDir, *pattern)
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	err = os.MkdirAll(*outDir, 0o755)
+	if err != nil {
+		slog.Error("error creating output directory", "err", err)
+		os.Exit(exitCode(err))
+	}
+
+	input, err := openInput(inputFiles.first())
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	src, _, err := mapInput(input, *useMmap)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	data, _, err := decompressInput(src, *decompress)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	p := cchunker.Pipeline{
+		Params:    params,
+		Processor: lf.processor(processor),
+		Jobs:      *jobs,
+		MaxChunks: *maxChunks,
+	}
+	signals := handleSignals()
+	p.Stop = signals.stop
+	_, err = p.Run(data, io.Discard)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(signals.exitCode(err))
+	}
+}
@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func addStatsFlag(fs *flag.FlagSet) *string {
+	return fs.String("stats", "", "write a JSON summary of the run to this file on exit, with the byte and chunk counts, chunk sizes, processor time and failures")
+}
+
+// runStats is the JSON written to a -stats file.
+type runStats struct {
+	cchunker.RunStats
+	Error string `json:"error,omitempty"`
+}
+
+// writeStats writes the stats of a run that ended with runErr to path.
+func writeStats(path string, recorder *cchunker.StatsRecorder, runErr error) error {
+	stats := runStats{RunStats: recorder.Stats()}
+	if runErr != nil {
+		stats.Error = runErr.Error()
+	}
+	buf, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding stats: %s", err)
+	}
+	err = os.WriteFile(path, append(buf, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("error writing stats: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func storeMain(args []string) {
+	fs := flag.NewFlagSet("store", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Chunk data piped into stdin or read from -input into a content addressed directory, each chunk is")
+		fmt.Fprintln(os.Stderr, "written to ab/cd/abcd... named by its hash, already present chunks are not rewritten.")
+		fmt.Fprintln(os.Stderr, "A 'hash size offset' manifest line is printed per chunk.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker store -dir PATH [-flags...]")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	dir := fs.String("dir", "", "chunk store directory")
+	hashName := fs.String("hash", "sha256", "hash naming each chunk, one of sha256 or blake3")
+	jobs := fs.Int("jobs", 1, "number of chunks to store concurrently")
+	fsync := fs.Bool("fsync", true, "sync each new chunk and its directory to disk before it is printed in the manifest")
+	var packThreshold cchunker.Size
+	fs.Var(&packThreshold, "pack-threshold", "append chunks smaller than this, e.g. 64KiB, to pack files instead of writing a file each")
+	var parity cchunker.Parity
+	fs.Var(&parity, "parity", "store M parity chunks for every K chunks, given as K/M, so repair can rebuild up to M lost chunks of each group")
+	signKey := fs.String("sign-key", "", "append an ed25519 signature of the manifest made with the key in this file, or fd:N, cmd:COMMAND or prompt, see gen-sign-key")
+	streamHash := addStreamHashFlag(fs)
+	provenance := fs.Bool("provenance", true, "start the manifest with a line recording the cchunker version and chunk parameters, which cchunker commands reading manifests skip")
+	inputFiles := addInputFlag(fs)
+	elide := addElideZeroFlag(fs)
+	decompress := addDecompressFlag(fs)
+	useMmap := addMmapFlag(fs)
+	rf := addRangeFlags(fs)
+	maxChunks := addMaxChunksFlag(fs)
+	statsFile := addStatsFlag(fs)
+	metricsAddr := addMetricsFlag(fs)
+	otlpEndpoint := addTracingFlag(fs)
+	epf := addErrorPolicyFlags(fs)
+	hf := addHistogramFlags(fs)
+	cf := addChunkFlags(fs)
+	pf := addProfileFlags(fs)
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	_, err := pf.apply(fs)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if *dir == "" || fs.NArg() != 0 {
+		fs.Usage()
+	}
+	if *jobs < 1 {
+		slog.Error("-jobs must be at least 1")
+		os.Exit(exitUsage)
+	}
+	_, err = cchunker.NewHash(*hashName)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	params, err := cf.params()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	params.SkipHoles = *elide
+
+	if rf.set() && (len(*inputFiles) > 1 || *decompress != "none") {
+		slog.Error("-offset and -length can only be used with a single input and without -decompress")
+		os.Exit(exitUsage)
+	}
+
+	err = checkStreamHash(*streamHash)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	store := &cchunker.Store{
+		Dir:           *dir,
+		Hash:          *hashName,
+		Sync:          *fsync,
+		PackThreshold: int(packThreshold),
+	}
+
+	var parityWriter *cchunker.ParityWriter
+	processor := store.Processor()
+	if parity.Data != 0 {
+		parityWriter, err = cchunker.NewParityWriter(store, parity)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+		processor = parityWriter.Processor()
+	}
+	processor = elideZero(*elide, "raw", processor)
+	processor = lf.processor(processor)
+
+	metrics, err := serveMetrics(*metricsAddr)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if metrics != nil {
+		processor = metrics.Processor(processor)
+	}
+
+	traces, err := startTracing(*otlpEndpoint, "cchunker store")
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if traces != nil {
+		processor = traces.processor(processor)
+	}
+
+	var recorder *cchunker.StatsRecorder
+	if *statsFile != "" {
+		recorder = cchunker.NewStatsRecorder()
+		processor = recorder.Processor(processor)
+	}
+
+	histogram, err := hf.histogram()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if histogram != nil {
+		processor = histogram.Processor(processor)
+	}
+
+	failures, failuresCloser, err := epf.failureLog()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if failures != nil {
+		processor = failures.Processor(processor)
+	}
+
+	p := cchunker.Pipeline{
+		Params:    params,
+		Processor: processor,
+		Jobs:      *jobs,
+		MaxChunks: *maxChunks,
+		Resume:    cchunker.Checkpoint{Offset: uint64(rf.offset)},
+	}
+
+	out, sign, err := signedOutput(*signKey)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if *provenance {
+		err = cchunker.WriteProvenance(out, params)
+		if err != nil {
+			slog.Error("error writing manifest", "err", err)
+			os.Exit(exitCode(err))
+		}
+	}
+
+	signals := handleSignals()
+	p.Stop = signals.stop
+	err = forEachInput(*inputFiles, func(path string) error {
+		return cchunker.WriteFileHeader(out, path)
+	}, func(input *os.File) error {
+		src, release, err := mapInput(input, *useMmap)
+		if err != nil {
+			return err
+		}
+		defer release()
+		if *decompress == "none" {
+			r, err := rf.reader(src, p.Resume.Offset)
+			if err != nil {
+				return err
+			}
+			_, err = runStreamHashed(&p, r, out, *streamHash, cchunker.WriteStreamHash)
+			return err
+		}
+		r, done, err := decompressInput(src, *decompress)
+		if err != nil {
+			return err
+		}
+		defer done()
+		_, err = runStreamHashed(&p, r, out, *streamHash, cchunker.WriteStreamHash)
+		return err
+	})
+	if err == nil && parityWriter != nil {
+		err = parityWriter.Flush(out)
+	}
+	if err2 := store.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = sign()
+	}
+	if recorder != nil {
+		if err2 := writeStats(*statsFile, recorder, err); err == nil {
+			err = err2
+		}
+	}
+	if histogram != nil {
+		if err2 := hf.print(histogram, params); err == nil {
+			err = err2
+		}
+	}
+	if failures != nil {
+		err = finishFailures(failures, failuresCloser, err)
+	}
+	if traces != nil {
+		if err2 := traces.finish(err); err == nil {
+			err = err2
+		}
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(signals.exitCode(err))
+	}
+}
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func addStreamHashFlag(fs *flag.FlagSet) *string {
+	return fs.String("stream-hash", "", "hash the whole of each input with this hash, e.g. sha256, ending its manifest with a '# stream HASH DIGEST' trailer that cat checks restores against")
+}
+
+// checkStreamHash returns a usage error if name is set and not a hash.
+func checkStreamHash(name string) error {
+	if name == "" {
+		return nil
+	}
+	_, err := cchunker.NewHash(name)
+	if err != nil {
+		return &usageError{fmt.Errorf("bad -stream-hash: %s", err)}
+	}
+	return nil
+}
+
+// runStreamHashed runs p on r, then if name is set writes the digest of
+// all of r by the hash name to out with trailer.
+func runStreamHashed(p *cchunker.Pipeline, r io.Reader, out io.Writer, name string, trailer func(out io.Writer, name string, sum []byte) error) (int64, error) {
+	if name == "" {
+		return p.Run(r, out)
+	}
+	h, err := cchunker.NewHash(name)
+	if err != nil {
+		return 0, err
+	}
+	p.StreamHash = h
+	defer func() { p.StreamHash = nil }()
+	nChunks, err := p.Run(r, out)
+	if err != nil {
+		return nChunks, err
+	}
+	return nChunks, trailer(out, name, h.Sum(nil))
+}
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func synthMain(args []string) {
+	fs := flag.NewFlagSet("synth", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Write reproducible pseudo-random data to stdout, the same -seed always gives the same data.")
+		fmt.Fprintln(os.Stderr, "-mutate changes a fraction of it with localized overwrites, insertions and deletions of up")
+		fmt.Fprintln(os.Stderr, "to 8KiB, giving a new version of the data to benchmark and test deduplication against.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker synth -size SIZE [-seed N] [-mutate 1%] [-mutate-seed N]")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	var size cchunker.Size
+	fs.Var(&size, "size", "number of bytes to write, e.g. 10GiB")
+	seed := fs.Uint64("seed", 0, "seed of the data")
+	mutate := fs.String("mutate", "0", "fraction of the data to mutate, e.g. 1% or 0.01")
+	mutateSeed := fs.Uint64("mutate-seed", 1, "seed of the mutations, giving different versions of the same data")
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if size == 0 || fs.NArg() != 0 {
+		fs.Usage()
+	}
+	fraction, err := parseFraction(*mutate)
+	if err != nil {
+		slog.Error("invalid -mutate", "err", err)
+		os.Exit(exitCode(err))
+	}
+
+	r, err := cchunker.NewSynthReader(cchunker.SynthOptions{
+		Size:       int64(size),
+		Seed:       *seed,
+		Mutate:     fraction,
+		MutateSeed: *mutateSeed,
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	out := bufio.NewWriterSize(os.Stdout, 1<<20)
+	_, err = io.Copy(out, r)
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		slog.Error("error writing data", "err", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// parseFraction parses a fraction given as a percentage such as 1% or a
+// number such as 0.01.
+func parseFraction(v string) (float64, error) {
+	s, percent := strings.CutSuffix(strings.TrimSpace(v), "%")
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a fraction or percentage", v)
+	}
+	if percent {
+		f /= 100
+	}
+	if f < 0 || f > 1 {
+		return 0, fmt.Errorf("%q is not between 0 and 100%%", v)
+	}
+	return f, nil
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package cli
+
+import "os"
+
+// openTerminal opens the controlling terminal, returning the file to read
+// input from and the file to write prompts to, both /dev/tty.
+func openTerminal() (in, out *os.File, err error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tty, tty, nil
+}
@@ -0,0 +1,20 @@
+//go:build windows
+
+package cli
+
+import "os"
+
+// openTerminal opens the console, returning the file to read input from
+// and the file to write prompts to, which on windows are separate.
+func openTerminal() (in, out *os.File, err error) {
+	in, err = os.OpenFile("CONIN$", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err = os.OpenFile("CONOUT$", os.O_WRONLY, 0)
+	if err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+	return in, out, nil
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/andrewchambers/cchunker"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func addTracingFlag(fs *flag.FlagSet) *string {
+	return fs.String("otlp-endpoint", "", "send OpenTelemetry traces of the run to this OTLP/HTTP endpoint, e.g. http://localhost:4318")
+}
+
+// tracing is the span covering a whole run, exported with OTLP.
+type tracing struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	ctx      context.Context
+	span     trace.Span
+}
+
+// startTracing starts a span named spanName exported to endpoint,
+// returning nil if endpoint is empty.
+func startTracing(endpoint, spanName string) (*tracing, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP endpoint: %s", err)
+	}
+	// Like OTEL_EXPORTER_OTLP_ENDPOINT, a bare endpoint gets the
+	// standard traces path.
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(u.String()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create OTLP exporter: %s", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("cchunker"))),
+	)
+	tracer := provider.Tracer("github.com/andrewchambers/cchunker")
+	ctx, span := tracer.Start(context.Background(), spanName)
+	return &tracing{
+		provider: provider,
+		tracer:   tracer,
+		ctx:      ctx,
+		span:     span,
+	}, nil
+}
+
+// processor records a span for each chunk p processes.
+func (t *tracing) processor(p cchunker.Processor) cchunker.Processor {
+	return cchunker.TracingProcessor(t.ctx, t.tracer, p)
+}
+
+// finish ends the run span of a run that ended with runErr and flushes
+// the spans to the exporter.
+func (t *tracing) finish(runErr error) error {
+	if runErr != nil {
+		t.span.RecordError(runErr)
+		t.span.SetStatus(codes.Error, runErr.Error())
+	}
+	t.span.End()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := t.provider.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("error exporting traces: %s", err)
+	}
+	return nil
+}
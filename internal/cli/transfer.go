@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func sendMain(args []string) {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Chunk data piped into stdin or read from -input, sending the chunks to a cchunker receive")
+		fmt.Fprintln(os.Stderr, "store at HOST:PORT. The receiver reports which chunks it already has and only the missing")
+		fmt.Fprintln(os.Stderr, "chunks are sent. A 'hash size offset' manifest line is printed per chunk, restore the data")
+		fmt.Fprintln(os.Stderr, "with cchunker cat on the receiving store. A summary of the data sent is printed to stderr.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker send HOST:PORT [-flags...]")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	hashName := fs.String("hash", "sha256", "hash naming each chunk, one of sha256 or blake3, must match the receiver")
+	jobs := fs.Int("jobs", 1, "number of chunks to hash concurrently")
+	inputFiles := addInputFlag(fs)
+	decompress := addDecompressFlag(fs)
+	useMmap := addMmapFlag(fs)
+	maxChunks := addMaxChunksFlag(fs)
+	cf := addChunkFlags(fs)
+	pf := addProfileFlags(fs)
+	lf := addLogFlags(fs)
+
+	positional := parseInterspersed(fs, args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	_, err := pf.apply(fs)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if len(positional) != 1 {
+		fs.Usage()
+	}
+	if *jobs < 1 {
+		slog.Error("-jobs must be at least 1")
+		os.Exit(exitUsage)
+	}
+
+	params, err := cf.params()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	conn, err := net.Dial("tcp", positional[0])
+	if err != nil {
+		slog.Error("unable to connect", "err", err)
+		os.Exit(exitCode(err))
+	}
+	defer conn.Close()
+
+	sender, err := cchunker.NewSender(conn, *hashName)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	p := cchunker.Pipeline{
+		Params:    params,
+		Processor: lf.processor(sender.Processor()),
+		Jobs:      *jobs,
+		MaxChunks: *maxChunks,
+	}
+
+	signals := handleSignals()
+	p.Stop = signals.stop
+	err = forEachInput(*inputFiles, func(path string) error {
+		return cchunker.WriteFileHeader(os.Stdout, path)
+	}, func(input *os.File) error {
+		src, release, err := mapInput(input, *useMmap)
+		if err != nil {
+			return err
+		}
+		defer release()
+		if *decompress == "none" {
+			_, err = p.Run(src, os.Stdout)
+			return err
+		}
+		r, done, err := decompressInput(src, *decompress)
+		if err != nil {
+			return err
+		}
+		defer done()
+		_, err = p.Run(r, os.Stdout)
+		return err
+	})
+	if err == nil {
+		err = sender.Close()
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(signals.exitCode(err))
+	}
+
+	r := sender.Result()
+	slog.Info(fmt.Sprintf("sent %d of %d chunks, %s of %s",
+		r.SentChunks, r.Chunks, cchunker.FormatSize(uint64(r.SentBytes)), cchunker.FormatSize(uint64(r.Bytes))))
+}
+
+func receiveMain(args []string) {
+	fs := flag.NewFlagSet("receive", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Receive chunks from cchunker send into a content addressed directory, telling each sender")
+		fmt.Fprintln(os.Stderr, "which of its chunks are already stored so only the missing chunks are transferred.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker receive -dir PATH [-listen ADDR] [-flags...]")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	dir := fs.String("dir", "", "chunk store directory")
+	listen := fs.String("listen", "127.0.0.1:9700", "address to listen for senders on, the protocol is unauthenticated so only listen where trusted senders can connect")
+	hashName := fs.String("hash", "sha256", "hash naming each chunk, one of sha256 or blake3")
+	fsync := fs.Bool("fsync", true, "sync each new chunk and its directory to disk before it is acknowledged")
+	var packThreshold cchunker.Size
+	fs.Var(&packThreshold, "pack-threshold", "append chunks smaller than this, e.g. 64KiB, to pack files instead of writing a file each")
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if *dir == "" || fs.NArg() != 0 {
+		fs.Usage()
+	}
+	_, err := cchunker.NewHash(*hashName)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	r := &cchunker.Receiver{
+		Store: &cchunker.Store{
+			Dir:           *dir,
+			Hash:          *hashName,
+			Sync:          *fsync,
+			PackThreshold: int(packThreshold),
+		},
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		slog.Error("unable to listen", "err", err)
+		os.Exit(exitCode(err))
+	}
+	err = r.Serve(l)
+	slog.Error(err.Error())
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func tuneMain(args []string) {
+	fs := flag.NewFlagSet("tune", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Chunk a sample from the start of the data piped into stdin or read from -input with several")
+		fmt.Fprintln(os.Stderr, "min, average and max chunk sizes, reporting the chunk size distribution, the dedup within the")
+		fmt.Fprintln(os.Stderr, "sample and how much of a copy with small edits reuses its chunks, then recommending the sizes")
+		fmt.Fprintln(os.Stderr, "for the full data. The algorithm, polynomial and seed flags apply to every candidate.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker tune [-sample 1GiB] [-flags...]")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	sampleSize := cchunker.Size(1024 * 1024 * 1024)
+	fs.Var(&sampleSize, "sample", "number of bytes from the start of the input to chunk, held in memory twice")
+	edits := fs.Int("edits", 64, "number of small insertions, deletions and overwrites in the edited copy of the sample")
+	seed := fs.Uint64("seed", 1, "seed of the edits")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "number of candidates to chunk concurrently")
+	inputFiles := addInputFlag(fs)
+	decompress := addDecompressFlag(fs)
+	cf := addChunkFlags(fs)
+	pf := addProfileFlags(fs)
+	lf := addLogFlags(fs)
+
+	fs.Parse(args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	_, err := pf.apply(fs)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if sampleSize == 0 || fs.NArg() != 0 {
+		fs.Usage()
+	}
+	if *jobs < 1 {
+		slog.Error("-jobs must be at least 1")
+		os.Exit(exitUsage)
+	}
+	if len(*inputFiles) > 1 {
+		slog.Error("tune samples a single input, -input can only be given once")
+		os.Exit(exitUsage)
+	}
+
+	params, err := cf.params()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if params.Algorithm == "fixed" {
+		slog.Error("only content defined chunking can be tuned, -fixed-size can not be used")
+		os.Exit(exitUsage)
+	}
+
+	input, err := openInput(inputFiles.first())
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	data, _, err := decompressInput(input, *decompress)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	sample, err := io.ReadAll(io.LimitReader(data, int64(sampleSize)))
+	if err != nil {
+		err = &cchunker.InputError{Err: fmt.Errorf("error reading sample: %s", err)}
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	candidates := cchunker.TuneCandidates(params, int64(len(sample)))
+	if len(candidates) == 0 {
+		slog.Error(fmt.Sprintf("a %s sample is too small to tune with, it needs to cut enough chunks of at least 64KiB",
+			approxSize(float64(len(sample)))))
+		os.Exit(exitInput)
+	}
+	results, err := cchunker.Tune(sample, candidates, cchunker.TuneOptions{
+		Edits: *edits,
+		Seed:  *seed,
+		Jobs:  *jobs,
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	fmt.Printf("sample %s, %d edits\n", approxSize(float64(len(sample))), *edits)
+	fmt.Printf("%-9s %-9s %-9s %8s %9s %9s %7s %9s %10s\n",
+		"min", "avg", "max", "chunks", "mean", "stddev", "at max", "duplicate", "edit reuse")
+	for _, r := range results {
+		fmt.Printf("%-9s %-9s %-9s %8d %9s %9s %6.1f%% %8.1f%% %9.1f%%\n",
+			cchunker.FormatSize(uint64(r.Params.MinSize)),
+			cchunker.FormatSize(1<<r.Params.AverageBits),
+			cchunker.FormatSize(uint64(r.Params.MaxSize)),
+			r.Chunks,
+			approxSize(r.MeanSize),
+			approxSize(r.StdDev),
+			100*r.AtMax, 100*r.Duplicate, 100*r.EditReuse)
+	}
+	best := results[cchunker.RecommendTune(results)]
+	fmt.Printf("recommended -min-size %s -avg-size %s -max-size %s\n",
+		cchunker.FormatSize(uint64(best.Params.MinSize)),
+		cchunker.FormatSize(1<<best.Params.AverageBits),
+		cchunker.FormatSize(uint64(best.Params.MaxSize)))
+}
+
+// approxSize formats n bytes to one decimal place in the largest unit it
+// is at least one of.
+func approxSize(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	for n >= 1024 && unit < len(units)-1 {
+		n /= 1024
+		unit += 1
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%.0fB", n)
+	}
+	return fmt.Sprintf("%.1f%s", n, units[unit])
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func verifyMain(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Check every chunk referenced by a manifest is in a chunk store and matches its hash,")
+		fmt.Fprintln(os.Stderr, "printing a 'missing ID' or 'corrupt ID REASON' line for each chunk that is not,")
+		fmt.Fprintln(os.Stderr, "and exiting with a non zero exit code if there are any.")
+		fmt.Fprint(os.Stderr, "\n\n")
+		fmt.Fprintln(os.Stderr, "usage:")
+		fmt.Fprintln(os.Stderr, "cchunker verify MANIFEST -dir STORE [-flags...]")
+		fmt.Fprintln(os.Stderr, "MANIFEST is the output of cchunker store, or - for stdin.")
+		fs.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	dir := fs.String("dir", "", "chunk store directory")
+	hashName := fs.String("hash", "sha256", "hash naming each chunk, one of sha256 or blake3")
+	lf := addLogFlags(fs)
+
+	positional := parseInterspersed(fs, args)
+	if err := lf.setup(); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	if *dir == "" || len(positional) != 1 {
+		fs.Usage()
+	}
+	_, err := cchunker.NewHash(*hashName)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+
+	var manifest io.Reader = os.Stdin
+	if positional[0] != "-" {
+		f, err := os.Open(positional[0])
+		if err != nil {
+			slog.Error("error opening manifest", "err", err)
+			os.Exit(exitCode(err))
+		}
+		defer f.Close()
+		manifest = f
+	}
+
+	store := &cchunker.Store{
+		Dir:  *dir,
+		Hash: *hashName,
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	result, err := store.Verify(manifest, out)
+	if err2 := out.Flush(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	slog.Info(fmt.Sprintf("checked %d chunks, %d missing, %d corrupt", result.Chunks, result.Missing, result.Corrupt))
+	if !result.OK() {
+		os.Exit(exitFailure)
+	}
+}
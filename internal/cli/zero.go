@@ -0,0 +1,24 @@
+package cli
+
+import (
+	"flag"
+
+	"github.com/andrewchambers/cchunker"
+)
+
+func addElideZeroFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("elide-zero", false, "print a 'zero size offset' line for all zero chunks instead of processing them")
+}
+
+// elideZero makes p skip all zero chunks if enabled, printing their
+// manifest line in the output format instead.
+func elideZero(enabled bool, format string, p cchunker.Processor) cchunker.Processor {
+	if !enabled {
+		return p
+	}
+	zero := cchunker.ZeroChunkProcessor()
+	if format == "jsonl" {
+		zero = cchunker.JSONLinesProcessor(zero)
+	}
+	return cchunker.ElideZeroProcessor(zero, p)
+}
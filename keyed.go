@@ -0,0 +1,124 @@
+package cchunker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"github.com/restic/chunker"
+)
+
+// keyedSplitter is a content defined splitter whose boundaries depend on a
+// secret key. It rolls a gear hash, h = h<<1 + table[b], over the input,
+// with a table of 256 random values derived from the key, and cuts a chunk
+// where the top AverageBits bits of the hash are clear, never cutting
+// before MinSize or after MaxSize bytes. The polynomial, buzhash seed and
+// window are not used.
+//
+// As the table is never public, someone who knows a file but not the key
+// can't compute where it would be cut, so the sizes of its encrypted
+// chunks don't identify it. The key is not meant to resist an attacker
+// who can have chosen data chunked and observe the chunk sizes, who may
+// learn enough of the table to predict boundaries.
+type keyedSplitter struct {
+	rd      io.Reader
+	table   [256]uint64
+	mask    uint64
+	minSize int
+	maxSize int
+
+	data   []byte
+	start  int
+	end    int
+	offset uint
+	eof    bool
+}
+
+// keyedGearWindow is the number of bytes a gear hash depends on, each
+// byte is shifted out of the 64 bit hash after that many more.
+const keyedGearWindow = 64
+
+// keyedGearTable derives the gear table of key.
+func keyedGearTable(key []byte) [256]uint64 {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("cchunker keyed chunking"))
+	src := &hashStream{seed: mac.Sum(nil)}
+
+	var table [256]uint64
+	var b [8]byte
+	for i := range table {
+		io.ReadFull(src, b[:])
+		table[i] = binary.LittleEndian.Uint64(b[:])
+	}
+	return table
+}
+
+func newKeyedSplitter(r io.Reader, p Params) *keyedSplitter {
+	return &keyedSplitter{
+		rd:      r,
+		table:   keyedGearTable(p.Key),
+		mask:    ^uint64(0) << uint(64-p.AverageBits),
+		minSize: int(p.MinSize),
+		maxSize: int(p.MaxSize),
+		data:    make([]byte, p.MaxSize),
+	}
+}
+
+// fill reads until a whole max size chunk is buffered or the input ends.
+func (s *keyedSplitter) fill() error {
+	copy(s.data, s.data[s.start:s.end])
+	s.end -= s.start
+	s.start = 0
+	if s.eof {
+		return nil
+	}
+	n, err := io.ReadFull(s.rd, s.data[s.end:])
+	s.end += n
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		s.eof = true
+		return nil
+	}
+	return err
+}
+
+func (s *keyedSplitter) Next(buf []byte) (chunker.Chunk, error) {
+	if s.end-s.start < s.maxSize {
+		err := s.fill()
+		if err != nil {
+			return chunker.Chunk{}, err
+		}
+	}
+	data := s.data[s.start:s.end]
+	if len(data) == 0 {
+		return chunker.Chunk{}, io.EOF
+	}
+
+	// The hash at a possible cut only depends on the window before it,
+	// so hashing starts one window before the min size.
+	length := len(data)
+	var sum uint64
+	if length > s.minSize {
+		i := max(s.minSize-keyedGearWindow, 0)
+		for ; i < s.minSize-1; i++ {
+			sum = sum<<1 + s.table[data[i]]
+		}
+		for ; i < length; i++ {
+			sum = sum<<1 + s.table[data[i]]
+			if sum&s.mask == 0 {
+				length = i + 1
+				break
+			}
+		}
+	}
+
+	chunk := chunker.Chunk{
+		Start:  s.offset,
+		Length: uint(length),
+		Cut:    sum,
+		Data:   append(buf[:0], data[:length]...),
+	}
+	s.start += length
+	s.offset += uint(length)
+	return chunk, nil
+}
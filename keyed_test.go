@@ -0,0 +1,89 @@
+package cchunker
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// chunkEnds returns the end offsets of the chunks params cuts data into.
+func chunkEnds(t *testing.T, params Params, data []byte) []uint {
+	t.Helper()
+	s := params.newChunker(bytes.NewReader(data))
+	var ends []uint
+	for {
+		c, err := s.Next(nil)
+		if err == io.EOF {
+			return ends
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ends = append(ends, c.Start+c.Length)
+	}
+}
+
+func equalEnds(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestKeyedSplitterDependsOnKey(t *testing.T) {
+	data := testData(1 * miB)
+	params := Params{Polynomial: DefaultPolynomial, MinSize: 2 * kiB, MaxSize: 64 * kiB, AverageBits: 13}
+	unkeyed := chunkEnds(t, params, data)
+
+	params.Key = []byte("first key")
+	first := chunkEnds(t, params, data)
+	if !equalEnds(first, chunkEnds(t, params, data)) {
+		t.Fatal("keyed chunking is not deterministic")
+	}
+	if equalEnds(first, unkeyed) {
+		t.Fatal("keyed chunking cut the same chunks as rabin")
+	}
+
+	params.Key = []byte("second key")
+	second := chunkEnds(t, params, data)
+	shared := make(map[uint]bool)
+	for _, end := range first[:len(first)-1] {
+		shared[end] = true
+	}
+	n := 0
+	for _, end := range second[:len(second)-1] {
+		if shared[end] {
+			n++
+		}
+	}
+	// Unrelated keys should share about as many boundaries as chance.
+	if n > len(first)/10 {
+		t.Fatalf("%d of %d boundaries are the same with another key", n, len(first))
+	}
+}
+
+func TestKeyedSplitterInvariants(t *testing.T) {
+	data := testData(256 * kiB)
+	prefix := testData(777)
+	for _, params := range []Params{
+		{MinSize: 64, MaxSize: 4 * kiB, AverageBits: 8},
+		{MinSize: 64, MaxSize: 64, AverageBits: 63},
+		{MinSize: 100, MaxSize: 200, AverageBits: 0},
+		{MinSize: 1 * kiB, MaxSize: 16 * kiB, AverageBits: 12},
+		// Min sizes under the gear window.
+		{Algorithm: "buzhash", MinSize: 0, MaxSize: 4 * kiB, AverageBits: 8, WindowSize: 1},
+		{Algorithm: "buzhash", MinSize: 1, MaxSize: 100, AverageBits: 3, WindowSize: 1},
+	} {
+		params.Polynomial = DefaultPolynomial
+		params.Key = []byte("invariants key")
+		err := CheckInvariants(data, prefix, params)
+		if err != nil {
+			t.Fatalf("%+v: %s", params, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package cchunker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ManifestRecord is a single chunk entry of a JSON lines manifest.
+type ManifestRecord struct {
+	Index      int64  `json:"index"`
+	Offset     uint64 `json:"offset"`
+	Length     int    `json:"length"`
+	RawLength  int    `json:"raw_length,omitempty"`
+	Output     string `json:"output"`
+	ExitStatus int    `json:"exit_status"`
+}
+
+// JSONLinesProcessor wraps p so that instead of the raw output of p,
+// a ManifestRecord is written as a line of JSON per chunk.
+//
+// If p fails with a non zero exit status the record is still written
+// before the error is returned.
+func JSONLinesProcessor(p Processor) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		var output bytes.Buffer
+		err := p.Process(c, &output)
+		exitStatus := 0
+		if err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				return err
+			}
+			exitStatus = exitErr.ExitCode()
+		}
+
+		line, jsonErr := json.Marshal(ManifestRecord{
+			Index:      c.Index,
+			Offset:     c.Offset,
+			Length:     len(c.Data),
+			RawLength:  c.RawLength,
+			Output:     output.String(),
+			ExitStatus: exitStatus,
+		})
+		if jsonErr != nil {
+			return fmt.Errorf("error encoding manifest record: %s", jsonErr)
+		}
+		_, writeErr := out.Write(append(line, '\n'))
+		if writeErr != nil {
+			return fmt.Errorf("error writing manifest record: %s", writeErr)
+		}
+		return err
+	})
+}
+
+// writeManifestLine writes the "hash size offset" line for chunk c, with
+// its raw size as a fourth field if the chunk data has been transformed.
+func writeManifestLine(out io.Writer, id string, c Chunk) error {
+	var err error
+	if c.RawLength != 0 {
+		_, err = fmt.Fprintf(out, "%s %d %d %d\n", id, len(c.Data), c.Offset, c.RawLength)
+	} else {
+		_, err = fmt.Fprintf(out, "%s %d %d\n", id, len(c.Data), c.Offset)
+	}
+	return err
+}
+
+// fileHeaderPrefix starts the line naming the input file of the manifest
+// section after it, when one run chunks several files.
+const fileHeaderPrefix = "# file "
+
+// WriteFileHeader writes the line starting the manifest section of the
+// input file path, the offsets of each section start from zero.
+func WriteFileHeader(out io.Writer, path string) error {
+	_, err := fmt.Fprintf(out, "%s%s\n", fileHeaderPrefix, strconv.Quote(path))
+	if err != nil {
+		return fmt.Errorf("error writing file header: %s", err)
+	}
+	return nil
+}
+
+// WriteJSONFileHeader writes a JSON object naming the input file path as
+// a line, starting its section of a JSON lines manifest.
+func WriteJSONFileHeader(out io.Writer, path string) error {
+	line, err := json.Marshal(struct {
+		File string `json:"file"`
+	}{path})
+	if err == nil {
+		_, err = out.Write(append(line, '\n'))
+	}
+	if err != nil {
+		return fmt.Errorf("error writing file header: %s", err)
+	}
+	return nil
+}
+
+// parseFileHeader returns the path named by a file header line.
+func parseFileHeader(line string) (string, bool) {
+	if !strings.HasPrefix(line, fileHeaderPrefix) {
+		return "", false
+	}
+	path, err := strconv.Unquote(strings.TrimSpace(line[len(fileHeaderPrefix):]))
+	if err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// trimCR returns line with a "\r\n" ending replaced by "\n", as windows
+// programs end their lines.
+func trimCR(line []byte) []byte {
+	if bytes.HasSuffix(line, []byte("\r\n")) {
+		line = append(line[:len(line)-2], '\n')
+	}
+	return line
+}
@@ -0,0 +1,112 @@
+package cchunker
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// histogram is a Prometheus style histogram with fixed bucket bounds.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i] += 1
+		}
+	}
+	h.sum += v
+	h.count += 1
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'f', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func writeCounter(w io.Writer, name, help string, v uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+}
+
+// Metrics collects counters and histograms of chunking activity and
+// serves them in the Prometheus text format.
+type Metrics struct {
+	mu                sync.Mutex
+	chunks            uint64
+	bytes             uint64
+	processorErrors   uint64
+	chunkSizes        *histogram
+	processorDuration *histogram
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	var sizeBounds []float64
+	for size := 64 << 10; size <= 64<<20; size *= 2 {
+		sizeBounds = append(sizeBounds, float64(size))
+	}
+	return &Metrics{
+		chunkSizes:        newHistogram(sizeBounds),
+		processorDuration: newHistogram([]float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300}),
+	}
+}
+
+// ObserveChunk records a chunk of the given size.
+func (m *Metrics) ObserveChunk(size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chunks += 1
+	m.bytes += uint64(size)
+	m.chunkSizes.observe(float64(size))
+}
+
+// Processor returns a Processor that runs p, recording each chunk along
+// with the processor latency and any error.
+func (m *Metrics) Processor(p Processor) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		start := time.Now()
+		err := p.Process(c, out)
+		elapsed := time.Since(start)
+
+		m.ObserveChunk(len(c.Data))
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.processorDuration.observe(elapsed.Seconds())
+		if err != nil {
+			m.processorErrors += 1
+		}
+		return err
+	})
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeCounter(w, "cchunker_chunks_total", "Chunks produced.", m.chunks)
+	writeCounter(w, "cchunker_bytes_total", "Bytes chunked.", m.bytes)
+	writeCounter(w, "cchunker_processor_errors_total", "Chunks the processor failed on.", m.processorErrors)
+	m.chunkSizes.write(w, "cchunker_chunk_size_bytes", "Size of each chunk.")
+	m.processorDuration.write(w, "cchunker_processor_duration_seconds", "Time the processor took for each chunk.")
+}
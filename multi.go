@@ -0,0 +1,462 @@
+package cchunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/restic/chunker"
+)
+
+// MultiLevelChunker repeatedly chunks a stream, then chunks the output of the
+// processor from the previous iteration, until only a single chunk remains.
+// The processor is expected to print a single line per chunk, so the final
+// output is a single summary line that is the root of a tree of keys.
+// SummaryRecordProcessor checks processors keep to this.
+//
+// Each iteration's output is prefixed with a line containing the iteration
+// number. A run fails rather than going on forever if a summary record is
+// larger than the summary chunks, or an iteration doesn't cut fewer chunks
+// than the one before.
+type MultiLevelChunker struct {
+	Params    Params
+	Processor Processor
+	// NodeProcessor, if set, is used instead of Processor for the summary
+	// iterations after the first, so the tree nodes can be handled
+	// differently to the data chunks.
+	NodeProcessor Processor
+	// Jobs is the number of chunks processed concurrently,
+	// see Pipeline.Jobs. Every iteration's output is still in chunk order,
+	// so the summary is the same whatever Jobs is, which deduplication
+	// across runs depends on.
+	Jobs int
+	// SummaryPolynomial is used for iterations after the first,
+	// if zero Params.Polynomial is used.
+	SummaryPolynomial chunker.Pol
+	// SummaryMinSize, SummaryMaxSize and SummaryAverageBits override the
+	// chunk sizes of Params for iterations after the first when not zero.
+	// Summary data is tiny compared to the input, so chunk sizes suited to
+	// the input make a degenerate tree of very few, very wide nodes.
+	SummaryMinSize     uint
+	SummaryMaxSize     uint
+	SummaryAverageBits int
+	// PerLevelPolynomials derives a distinct polynomial for each summary
+	// iteration from the summary polynomial.
+	PerLevelPolynomials bool
+	// SpillThreshold is the size in bytes above which summary data is moved
+	// from memory to a temporary file in SpillDir, zero keeps it in memory.
+	SpillThreshold int64
+	// SpillDir is where spill files are created, if empty os.TempDir is used.
+	SpillDir string
+	// MaxMemory, if not zero, bounds the bytes held by chunk buffers and
+	// summary data. Jobs is lowered so the chunk buffers of the concurrent
+	// processors and the chunk read ahead fit, and the rest is split
+	// between the summary being read and the one being written, which are
+	// spilled to disk past their share.
+	MaxMemory int64
+	// MaxChunks limits the chunks of every iteration, see
+	// Pipeline.MaxChunks.
+	MaxChunks int64
+	// Stop ends the run early when closed, see Pipeline.Stop. No summary
+	// is written for an interrupted run.
+	Stop <-chan struct{}
+	// Tree, if set, has a TreeNode written to it as a line of JSON for
+	// every chunk of every iteration, describing the whole tree rather
+	// than only its root.
+	Tree io.Writer
+	// NulRecords ends the iteration number records with a NUL byte
+	// instead of a newline, for processors wrapped by
+	// SummaryRecordProcessor with nulRecords set.
+	NulRecords bool
+}
+
+// SummaryRecordProcessor returns a Processor running p and checking its
+// output is a single summary record, so a processor printing several lines
+// or none fails instead of silently corrupting the summary stream.
+//
+// By default a record is one newline terminated line, a line ending with
+// "\r\n" is written ending with "\n". If nulRecords is
+// set, the output of p is taken as is, newlines and all, and terminated
+// with a NUL byte, so records can hold several lines or binary data as
+// long as it contains no NUL bytes.
+func SummaryRecordProcessor(p Processor, nulRecords bool) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		var buf bytes.Buffer
+		err := p.Process(c, &buf)
+		if err != nil {
+			return err
+		}
+		record := buf.Bytes()
+		if len(record) == 0 {
+			return fmt.Errorf("processor printed no summary record for chunk %d", c.Index)
+		}
+		if nulRecords {
+			if bytes.IndexByte(record, 0) != -1 {
+				return fmt.Errorf("processor output for chunk %d contains a NUL byte, which ends summary records", c.Index)
+			}
+			record = append(record, 0)
+		} else {
+			// Summaries are the same whichever system the processor
+			// ran on.
+			record = trimCR(record)
+			if record[len(record)-1] != '\n' {
+				return fmt.Errorf("processor output for chunk %d is not a newline terminated line", c.Index)
+			}
+			if bytes.IndexByte(record[:len(record)-1], '\n') != -1 {
+				return fmt.Errorf("processor printed several lines for chunk %d, summary records must be a single line", c.Index)
+			}
+		}
+		_, err = out.Write(record)
+		if err != nil {
+			return fmt.Errorf("error writing chunk output: %s", err)
+		}
+		return nil
+	})
+}
+
+// TreeNode is a chunk of one iteration of a MultiLevelChunker run.
+type TreeNode struct {
+	// Level is the iteration the chunk was cut in, level 0 chunks are of
+	// the input data.
+	Level int64 `json:"level"`
+	Index int64 `json:"index"`
+	// Offset and Length are the extent of the chunk in the stream of its
+	// level, for levels after 0 the previous level's summary data.
+	Offset uint64 `json:"offset"`
+	Length int    `json:"length"`
+	// Output is the processor output for the chunk.
+	Output string `json:"output"`
+	// Children are the indexes of the chunks of the previous level whose
+	// output is in this chunk, the first and last may only be partly in it.
+	Children []int64 `json:"children,omitempty"`
+}
+
+// treeLevel records the nodes of a level as they complete, tracking where
+// their output is in the summary data the next level chunks.
+type treeLevel struct {
+	w          io.Writer
+	level      int64
+	offset     uint64
+	summaryPos uint64
+	// children are the extents of the previous level's output in this
+	// level's input, indexed by chunk.
+	children []extent
+	next     int
+	// outputs are the extents of this level's output.
+	outputs []extent
+}
+
+type extent struct {
+	start, end uint64
+}
+
+func (t *treeLevel) checkpoint(cp Checkpoint, output []byte) error {
+	node := TreeNode{
+		Level:  t.level,
+		Index:  cp.Chunks - 1,
+		Offset: t.offset,
+		Length: int(cp.Offset - t.offset),
+		Output: string(output),
+	}
+	// Children overlapping this chunk, the last one may continue into the
+	// next chunk so is considered again.
+	for i := t.next; i < len(t.children) && t.children[i].start < cp.Offset; i += 1 {
+		if t.children[i].end > t.offset {
+			node.Children = append(node.Children, int64(i))
+		}
+		if t.children[i].end <= cp.Offset {
+			t.next = i + 1
+		}
+	}
+	t.offset = cp.Offset
+	t.outputs = append(t.outputs, extent{t.summaryPos, t.summaryPos + uint64(len(output))})
+	t.summaryPos += uint64(len(output))
+
+	line, err := json.Marshal(node)
+	if err != nil {
+		return fmt.Errorf("error encoding tree node: %s", err)
+	}
+	_, err = t.w.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("error writing tree node: %s", err)
+	}
+	return nil
+}
+
+// Run reduces r to a single summary written to out.
+func (m *MultiLevelChunker) Run(r io.Reader, out io.Writer) error {
+	summaryPolynomial := m.Params.Polynomial
+	if m.SummaryPolynomial != 0 {
+		summaryPolynomial = m.SummaryPolynomial
+	}
+
+	// XXX TODO test with multi terrabytes of data.
+
+	err := m.Params.Validate()
+	if err != nil {
+		return err
+	}
+	summaryParams, err := m.summaryParams(summaryPolynomial, 1)
+	if err != nil {
+		return err
+	}
+	err = summaryParams.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid summary chunk parameters: %s", err)
+	}
+	summaryMaxSize := summaryParams.MaxSize
+	// The budget is worked out once, for the largest chunks any
+	// iteration can cut.
+	maxSize := m.Params.MaxSize
+	if m.SummaryMaxSize > maxSize {
+		maxSize = m.SummaryMaxSize
+	}
+	jobs, spillThreshold, err := m.budget(maxSize)
+	if err != nil {
+		return err
+	}
+
+	newSummary := func() *spillBuffer {
+		return &spillBuffer{threshold: spillThreshold, dir: m.SpillDir}
+	}
+
+	summaryData := newSummary()
+	defer func() { summaryData.Close() }()
+	var prevSummaryData *spillBuffer
+	defer func() {
+		if prevSummaryData != nil {
+			prevSummaryData.Close()
+		}
+	}()
+
+	input := r
+	iteration := int64(0)
+	prevChunks := int64(0)
+	var tree *treeLevel
+
+	sep := "\n"
+	if m.NulRecords {
+		sep = "\x00"
+	}
+
+	for {
+		header := fmt.Sprintf("%d%s", iteration, sep)
+		_, err := io.WriteString(summaryData, header)
+		if err != nil {
+			return fmt.Errorf("error writing iteration number: %s", err)
+		}
+		if m.Tree != nil {
+			var children []extent
+			if tree != nil {
+				children = tree.outputs
+			}
+			tree = &treeLevel{
+				w:          m.Tree,
+				level:      iteration,
+				summaryPos: uint64(len(header)),
+				children:   children,
+			}
+		}
+
+		params := m.Params
+		if iteration != 0 {
+			params, err = m.summaryParams(summaryPolynomial, iteration)
+			if err != nil {
+				return err
+			}
+		}
+
+		processor := m.Processor
+		if iteration != 0 && m.NodeProcessor != nil {
+			processor = m.NodeProcessor
+		}
+		var longest recordLength
+		processor = longest.processor(processor)
+
+		p := Pipeline{
+			Params:    params,
+			Processor: processor,
+			Jobs:      jobs,
+			Stop:      m.Stop,
+			MaxChunks: m.MaxChunks,
+		}
+		if tree != nil {
+			p.Checkpoint = tree.checkpoint
+		}
+		nChunks, err := p.Run(input, summaryData)
+		if err != nil {
+			return err
+		}
+
+		if nChunks == 0 || nChunks == 1 {
+			break
+		}
+		// The summary chunks must hold several records for the summary
+		// to shrink each iteration, or the run would never end.
+		if longest.max() > int64(summaryMaxSize) {
+			return fmt.Errorf("summary records of up to %d bytes can not fit in summary chunks of at most %d bytes", longest.max(), summaryMaxSize)
+		}
+		if iteration != 0 && nChunks >= prevChunks {
+			return fmt.Errorf("iteration %d cut %d chunks from the %d of the iteration before, the summary chunk sizes are too small for the summary to shrink", iteration, nChunks, prevChunks)
+		}
+		prevChunks = nChunks
+
+		if prevSummaryData != nil {
+			err = prevSummaryData.Close()
+			if err != nil {
+				return fmt.Errorf("error removing summary spill file: %s", err)
+			}
+		}
+		input, err = summaryData.Reader()
+		if err != nil {
+			return err
+		}
+		prevSummaryData = summaryData
+		summaryData = newSummary()
+		iteration += 1
+	}
+
+	summary, err := summaryData.Reader()
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, summary)
+	if err != nil {
+		return fmt.Errorf("error writing summary line: %s", err)
+	}
+	return nil
+}
+
+// summaryParams returns the chunk parameters of the summary iteration
+// iteration, chunking summaryPolynomial unless m.PerLevelPolynomials.
+func (m *MultiLevelChunker) summaryParams(summaryPolynomial chunker.Pol, iteration int64) (Params, error) {
+	// Summary streams are lines of processor output.
+	params := m.Params
+	params.TarAware = false
+	params.AnchorDelim = nil
+	params.SkipHoles = false
+	if m.SummaryMinSize != 0 {
+		params.MinSize = m.SummaryMinSize
+	}
+	if m.SummaryMaxSize != 0 {
+		params.MaxSize = m.SummaryMaxSize
+	}
+	if m.SummaryAverageBits != 0 {
+		params.AverageBits = m.SummaryAverageBits
+	}
+	params.Polynomial = summaryPolynomial
+	if m.PerLevelPolynomials {
+		var err error
+		params.Polynomial, err = deriveLevelPolynomial(summaryPolynomial, iteration)
+		if err != nil {
+			return params, fmt.Errorf("unable to derive polynomial for iteration %d: %s", iteration, err)
+		}
+	}
+	return params, nil
+}
+
+// recordLength tracks the length of the longest summary record of an
+// iteration.
+type recordLength struct {
+	longest atomic.Int64
+}
+
+// processor returns p, recording the length of its output for each chunk.
+func (l *recordLength) processor(p Processor) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		cw := &countingWriter{w: out}
+		err := p.Process(c, cw)
+		for {
+			longest := l.longest.Load()
+			if cw.n <= longest || l.longest.CompareAndSwap(longest, cw.n) {
+				break
+			}
+		}
+		return err
+	})
+}
+
+func (l *recordLength) max() int64 {
+	return l.longest.Load()
+}
+
+// budget returns the number of jobs and the summary spill threshold
+// keeping a run with chunks of up to maxSize bytes within m.MaxMemory.
+func (m *MultiLevelChunker) budget(maxSize uint) (int, int64, error) {
+	if m.MaxMemory == 0 {
+		return m.Jobs, m.SpillThreshold, nil
+	}
+
+	// Every chunk being processed holds a buffer, as does the chunk
+	// being read ahead of them, even when running serially.
+	jobs := m.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	buffers := m.MaxMemory / int64(maxSize)
+	if buffers < 2 {
+		return 0, 0, fmt.Errorf("max memory %s is less than the two %s chunk buffers a run needs",
+			FormatSize(uint64(m.MaxMemory)), FormatSize(uint64(maxSize)))
+	}
+	if int64(jobs) > buffers-1 {
+		jobs = int(buffers - 1)
+	}
+
+	// What is left is shared by the summary being read and the one being
+	// written, a threshold of zero would never spill so at least one
+	// byte is kept.
+	threshold := (m.MaxMemory - int64(jobs+1)*int64(maxSize)) / 2
+	if threshold < 1 {
+		threshold = 1
+	}
+	if m.SpillThreshold != 0 && m.SpillThreshold < threshold {
+		threshold = m.SpillThreshold
+	}
+	return jobs, threshold, nil
+}
+
+// deriveLevelPolynomial deterministically derives an irreducible polynomial
+// for a summary iteration, so each level of the tree cuts chunks
+// independently of the levels above and below it.
+func deriveLevelPolynomial(base chunker.Pol, iteration int64) (chunker.Pol, error) {
+	var seed [16]byte
+	binary.LittleEndian.PutUint64(seed[0:8], uint64(base))
+	binary.LittleEndian.PutUint64(seed[8:16], uint64(iteration))
+	return chunker.DerivePolynomial(&hashStream{seed: seed[:]})
+}
+
+// hashStream is an endless reader of sha256(seed || counter) blocks.
+type hashStream struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+func (h *hashStream) Read(p []byte) (int, error) {
+	if len(h.buf) == 0 {
+		var ctr [8]byte
+		binary.LittleEndian.PutUint64(ctr[:], h.counter)
+		h.counter += 1
+		sum := sha256.Sum256(append(append([]byte{}, h.seed...), ctr[:]...))
+		h.buf = sum[:]
+	}
+	n := copy(p, h.buf)
+	h.buf = h.buf[n:]
+	return n, nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
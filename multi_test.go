@@ -0,0 +1,87 @@
+package cchunker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runMulti runs m on data, failing the test if it doesn't finish.
+func runMulti(t *testing.T, m *MultiLevelChunker, data []byte) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Run(bytes.NewReader(data), &out)
+	}()
+	select {
+	case err := <-done:
+		return out.String(), err
+	case <-time.After(10 * time.Second):
+		t.Fatal("run did not finish")
+		return "", nil
+	}
+}
+
+// recordProcessor prints a record of size bytes, including its newline.
+func recordProcessor(size int) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		record := fmt.Sprintf("%0*d\n", size-1, c.Index)
+		_, err := io.WriteString(out, record)
+		return err
+	})
+}
+
+func TestMultiLevelChunkerReduces(t *testing.T) {
+	m := &MultiLevelChunker{
+		Params:    Params{Algorithm: "fixed", MaxSize: 64},
+		Processor: recordProcessor(20),
+	}
+	summary, err := runMulti(t, m, make([]byte, 64*100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(summary, "\n") != 2 {
+		t.Fatalf("summary %q is not an iteration number and a record", summary)
+	}
+}
+
+func TestMultiLevelChunkerRecordTooLarge(t *testing.T) {
+	m := &MultiLevelChunker{
+		Params:    Params{Algorithm: "fixed", MaxSize: 64},
+		Processor: recordProcessor(65),
+	}
+	_, err := runMulti(t, m, make([]byte, 64*10))
+	if err == nil || !strings.Contains(err.Error(), "can not fit") {
+		t.Fatalf("expected a record size error, got %v", err)
+	}
+}
+
+func TestMultiLevelChunkerNoProgress(t *testing.T) {
+	// Every 64 byte chunk holds a single 64 byte record, plus the
+	// iteration number, so the summary never shrinks.
+	m := &MultiLevelChunker{
+		Params:    Params{Algorithm: "fixed", MaxSize: 64},
+		Processor: recordProcessor(64),
+	}
+	_, err := runMulti(t, m, make([]byte, 64*10))
+	if err == nil || !strings.Contains(err.Error(), "too small for the summary to shrink") {
+		t.Fatalf("expected a progress error, got %v", err)
+	}
+}
+
+func TestMultiLevelChunkerInvalidSummaryParams(t *testing.T) {
+	m := &MultiLevelChunker{
+		Params:         Params{Algorithm: "fixed", MaxSize: 64},
+		Processor:      recordProcessor(20),
+		SummaryMinSize: 128,
+		SummaryMaxSize: 100,
+	}
+	_, err := runMulti(t, m, make([]byte, 64*10))
+	if err == nil || !strings.Contains(err.Error(), "invalid summary chunk parameters") {
+		t.Fatalf("expected a summary parameters error, got %v", err)
+	}
+}
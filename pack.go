@@ -0,0 +1,259 @@
+package cchunker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// packDirName is the directory of a Store holding its pack files.
+const packDirName = "packs"
+
+// packMaxSize is the size a pack file is written up to before the next
+// chunk starts a new one.
+const packMaxSize = 64 * 1024 * 1024
+
+// packs is the pack files of a Store. Chunks smaller than the store's
+// PackThreshold are appended to a pack file instead of having a file each,
+// then a "id offset size" line locating the chunk is appended to the index
+// file of the same name with a .idx suffix. The data is always written
+// before its index line, so an interrupted write at worst leaves unindexed
+// bytes at the end of a pack. Each Store writes its own pack files, so
+// several processes can add to the same store.
+type packs struct {
+	mu     sync.Mutex
+	loaded bool
+	index  map[string]packLocation
+
+	pack *os.File
+	idx  *os.File
+	size int64
+}
+
+// packLocation is where a packed chunk is.
+type packLocation struct {
+	pack   string
+	offset int64
+	size   int64
+}
+
+func (s *Store) packDir() string {
+	return filepath.Join(s.Dir, packDirName)
+}
+
+// load reads the index files of every pack in dir, if not done already.
+// Callers must hold p.mu.
+func (p *packs) load(dir string) error {
+	if p.loaded {
+		return nil
+	}
+	p.index = make(map[string]packLocation)
+	idxPaths, err := filepath.Glob(filepath.Join(dir, "*.idx"))
+	if err != nil {
+		return err
+	}
+	for _, idxPath := range idxPaths {
+		err = p.loadIndex(idxPath)
+		if err != nil {
+			return err
+		}
+	}
+	p.loaded = true
+	return nil
+}
+
+func (p *packs) loadIndex(idxPath string) error {
+	f, err := os.Open(idxPath)
+	if err != nil {
+		return fmt.Errorf("error reading pack index: %s", err)
+	}
+	defer f.Close()
+
+	pack := strings.TrimSuffix(idxPath, ".idx") + ".pack"
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			// A line cut short by an interrupted write.
+			continue
+		}
+		offset, err1 := strconv.ParseInt(fields[1], 10, 64)
+		size, err2 := strconv.ParseInt(fields[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		p.index[fields[0]] = packLocation{pack: pack, offset: offset, size: size}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("error reading pack index %s: %s", idxPath, err)
+	}
+	return nil
+}
+
+// lookupPacked returns where the chunk id is packed, if it is.
+func (s *Store) lookupPacked(id string) (packLocation, bool, error) {
+	s.packs.mu.Lock()
+	defer s.packs.mu.Unlock()
+	err := s.packs.load(s.packDir())
+	if err != nil {
+		return packLocation{}, false, err
+	}
+	loc, ok := s.packs.index[id]
+	return loc, ok, nil
+}
+
+// readPacked reads the packed chunk id, the error wraps os.ErrNotExist if
+// it isn't packed.
+func (s *Store) readPacked(id string) ([]byte, error) {
+	loc, ok, err := s.lookupPacked(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("chunk %s is not packed: %w", id, os.ErrNotExist)
+	}
+	f, err := os.Open(loc.pack)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, loc.size)
+	_, err = f.ReadAt(data, loc.offset)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// putPacked appends data to the current pack file as the chunk id, unless
+// it is already packed.
+func (s *Store) putPacked(id string, data []byte) error {
+	s.packs.mu.Lock()
+	defer s.packs.mu.Unlock()
+	p := &s.packs
+	dir := s.packDir()
+	err := p.load(dir)
+	if err != nil {
+		return err
+	}
+	if loc, ok := p.index[id]; ok {
+		err = touch(loc.pack)
+		if err != nil {
+			return fmt.Errorf("error refreshing the pack of chunk %s: %s", id, err)
+		}
+		return nil
+	}
+
+	if p.pack == nil || p.size >= packMaxSize {
+		err = s.startPack()
+		if err != nil {
+			return fmt.Errorf("error starting pack file: %s", err)
+		}
+	}
+
+	_, err = p.pack.Write(data)
+	if err == nil && s.Sync {
+		err = p.pack.Sync()
+	}
+	if err == nil {
+		_, err = fmt.Fprintf(p.idx, "%s %d %d\n", id, p.size, len(data))
+	}
+	if err == nil && s.Sync {
+		err = p.idx.Sync()
+	}
+	if err != nil {
+		// The pack may now end part way into a chunk, start a new one.
+		p.closeFiles()
+		return fmt.Errorf("error packing chunk %s: %s", id, err)
+	}
+	p.index[id] = packLocation{pack: p.pack.Name(), offset: p.size, size: int64(len(data))}
+	p.size += int64(len(data))
+	return nil
+}
+
+// startPack closes any current pack and creates a new one with its index.
+// Callers must hold s.packs.mu.
+func (s *Store) startPack() error {
+	p := &s.packs
+	err := p.closeFiles()
+	if err != nil {
+		return err
+	}
+	dir := s.packDir()
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	pack, err := os.CreateTemp(dir, "pack-*.pack")
+	if err != nil {
+		return err
+	}
+	idx, err := os.OpenFile(strings.TrimSuffix(pack.Name(), ".pack")+".idx", os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0644)
+	if err != nil {
+		pack.Close()
+		os.Remove(pack.Name())
+		return err
+	}
+	if s.Sync {
+		err = syncDir(dir)
+		if err == nil {
+			err = syncDir(s.Dir)
+		}
+		if err != nil {
+			pack.Close()
+			idx.Close()
+			return err
+		}
+	}
+	p.pack, p.idx, p.size = pack, idx, 0
+	return nil
+}
+
+// closeFiles closes the pack being written, if any.
+func (p *packs) closeFiles() error {
+	if p.pack == nil {
+		return nil
+	}
+	err := p.pack.Close()
+	if err2 := p.idx.Close(); err == nil {
+		err = err2
+	}
+	p.pack, p.idx = nil, nil
+	return err
+}
+
+// Close closes the pack file being written, the store can still be used
+// afterwards and starts a new pack if needed.
+func (s *Store) Close() error {
+	s.packs.mu.Lock()
+	defer s.packs.mu.Unlock()
+	err := s.packs.closeFiles()
+	if err != nil {
+		return fmt.Errorf("error closing pack file: %s", err)
+	}
+	return nil
+}
+
+// packedChunks returns the ids of the chunks in each pack file.
+func (s *Store) packedChunks() (map[string][]string, error) {
+	s.packs.mu.Lock()
+	defer s.packs.mu.Unlock()
+	err := s.packs.load(s.packDir())
+	if err != nil {
+		return nil, err
+	}
+	chunks := make(map[string][]string)
+	for id, loc := range s.packs.index {
+		chunks[loc.pack] = append(chunks[loc.pack], id)
+	}
+	return chunks, nil
+}
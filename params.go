@@ -0,0 +1,170 @@
+package cchunker
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/restic/chunker"
+)
+
+const (
+	kiB = 1024
+	miB = 1024 * kiB
+)
+
+// rabinWindowSize is the size of the window of the restic rabin chunker,
+// which it fills from each chunk before looking for a cut.
+const rabinWindowSize = 64
+
+// DefaultPolynomial is the polynomial used when none is supplied.
+const DefaultPolynomial = chunker.Pol(0x3DA3358B4DC173)
+
+// Params controls where chunk boundaries are placed.
+type Params struct {
+	// Algorithm is "rabin", the default if empty, "buzhash" or "fixed",
+	// fixed cuts chunks of exactly MaxSize bytes.
+	Algorithm  string
+	Polynomial chunker.Pol
+	MinSize    uint
+	MaxSize    uint
+	// AverageBits is a bit mask that determines chunking with probability,
+	// (assuming the fingerprint of bytes coming in are random)
+	// a chunk is cut one out of every 2^AverageBits bytes.
+	AverageBits int
+	// Seed is xored into the buzhash table.
+	Seed uint32
+	// WindowSize is the size of the buzhash rolling window.
+	WindowSize int
+	// Key, if set, replaces the content defined algorithms with a gear
+	// hash keyed by this secret, see keyedSplitter, so the chunk sizes of
+	// encrypted chunks can't be used to fingerprint known files. It is
+	// never serialized, so it doesn't leak into checkpoint files.
+	Key []byte `json:"-"`
+	// TarAware forces cuts at the entry boundaries of tar input, see
+	// tarSplitter.
+	TarAware bool
+	// AnchorDelim, if set, snaps cut points to the end of the records
+	// it delimits, see anchorSplitter.
+	AnchorDelim []byte
+	// SkipHoles skips reading the holes of sparse regular files, which
+	// are returned as zero chunks of at most MaxSize bytes, see
+	// sparseSplitter.
+	SkipHoles bool
+}
+
+var (
+	// SmallParams has a min size 512 KiB, max size 8 MiB and an average of 1MiB.
+	SmallParams = Params{
+		Polynomial: DefaultPolynomial,
+		MinSize:    512 * kiB,
+		MaxSize:    8 * miB,
+		// >>> int('0b' + '1' * 20, base=2)
+		// one out of every ~ 1 million will split.
+		AverageBits: 20,
+	}
+
+	// StandardParams has a min size 512 KiB, max size 16 MiB and an average of 4MiB.
+	StandardParams = Params{
+		Polynomial: DefaultPolynomial,
+		MinSize:    512 * kiB,
+		MaxSize:    16 * miB,
+		// >>> int('0b' + '1' * 22, base=2)
+		// one out of every 4 million will split.
+		AverageBits: 22,
+	}
+
+	// LargeParams has a min size 1 MiB, max size 32 MiB and an average of 8MiB.
+	LargeParams = Params{
+		Polynomial: DefaultPolynomial,
+		MinSize:    1024 * kiB,
+		MaxSize:    32 * miB,
+		// >>> int('0b' + '1' * 23, base=2)
+		// one out of every 8 million will split.
+		AverageBits: 23,
+	}
+
+	// BuzhashParams matches the BorgBackup chunker defaults,
+	// CHUNK_MIN_EXP 19, CHUNK_MAX_EXP 23, HASH_MASK_BITS 21 and
+	// HASH_WINDOW_SIZE 4095.
+	BuzhashParams = Params{
+		Algorithm:   "buzhash",
+		MinSize:     1 << 19,
+		MaxSize:     1 << 23,
+		AverageBits: 21,
+		WindowSize:  4095,
+	}
+)
+
+// FixedParams returns parameters that cut chunks of exactly size bytes.
+func FixedParams(size uint) Params {
+	return Params{
+		Algorithm: "fixed",
+		MinSize:   size,
+		MaxSize:   size,
+	}
+}
+
+// Validate checks the parameters are usable.
+func (p Params) Validate() error {
+	if p.MinSize > p.MaxSize {
+		return fmt.Errorf("min chunk size %d is larger than max chunk size %d", p.MinSize, p.MaxSize)
+	}
+	if p.AverageBits < 0 || p.AverageBits > 63 {
+		return fmt.Errorf("average bits %d is out of range", p.AverageBits)
+	}
+	switch p.Algorithm {
+	case "", "rabin":
+		if p.MinSize < rabinWindowSize {
+			return fmt.Errorf("rabin min chunk size %d is smaller than the %d byte window", p.MinSize, rabinWindowSize)
+		}
+	case "buzhash":
+		if p.AverageBits > 32 {
+			return fmt.Errorf("buzhash average bits %d is more than 32", p.AverageBits)
+		}
+		if p.WindowSize < 1 {
+			return fmt.Errorf("buzhash window size must be positive")
+		}
+		if uint(p.WindowSize)+p.MinSize+1 > p.MaxSize {
+			return fmt.Errorf("buzhash window size + min size + 1 must not exceed max size")
+		}
+	case "fixed":
+		if p.MaxSize == 0 {
+			return fmt.Errorf("fixed chunk size must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown chunking algorithm %q", p.Algorithm)
+	}
+	return nil
+}
+
+// splitter finds chunk boundaries in a stream.
+type splitter interface {
+	Next(buf []byte) (chunker.Chunk, error)
+}
+
+func (p Params) newChunker(r io.Reader) splitter {
+	if f, ok := r.(*os.File); ok && p.SkipHoles {
+		if s := newSparseSplitter(f, p); s != nil {
+			return s
+		}
+	}
+	if p.TarAware {
+		return newTarSplitter(r, p)
+	}
+	if len(p.AnchorDelim) != 0 {
+		return newAnchorSplitter(r, p)
+	}
+	if len(p.Key) != 0 && p.Algorithm != "fixed" {
+		return newKeyedSplitter(r, p)
+	}
+	switch p.Algorithm {
+	case "buzhash":
+		return newBuzhashChunker(r, p)
+	case "fixed":
+		return &fixedChunker{rd: r, size: int(p.MaxSize)}
+	}
+	c := chunker.NewWithBoundaries(r, p.Polynomial, p.MinSize, p.MaxSize)
+	c.SetAverageBits(p.AverageBits)
+	return c
+}
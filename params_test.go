@@ -0,0 +1,36 @@
+package cchunker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRabinWindow(t *testing.T) {
+	for _, algorithm := range []string{"", "rabin"} {
+		p := Params{Algorithm: algorithm, Polynomial: DefaultPolynomial, MinSize: 32, MaxSize: 48, AverageBits: 4}
+		err := p.Validate()
+		if err == nil || !strings.Contains(err.Error(), "smaller than the 64 byte window") {
+			t.Fatalf("%q: expected a window error, got %v", algorithm, err)
+		}
+		p.MinSize, p.MaxSize = 64, 64
+		err = p.Validate()
+		if err != nil {
+			t.Fatalf("%q: %s", algorithm, err)
+		}
+	}
+
+	// Other algorithms have no such window.
+	err := FixedParams(16).Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFuzzParamsValid(t *testing.T) {
+	for seed := uint64(0); seed < 1000; seed++ {
+		err := FuzzParams(seed).Validate()
+		if err != nil {
+			t.Fatalf("seed %d: %s", seed, err)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package cchunker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// Parity is a K/M erasure coding scheme flag.Value, every K data chunks get
+// M parity chunks, so any M of the K+M chunks of a group can be lost.
+type Parity struct {
+	Data   int
+	Parity int
+}
+
+func (p *Parity) String() string {
+	if p.Data == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", p.Data, p.Parity)
+}
+
+// Set parses a K/M scheme such as 10/2.
+func (p *Parity) Set(v string) error {
+	k, m, ok := strings.Cut(v, "/")
+	if !ok {
+		return fmt.Errorf("invalid parity %q, expected K/M", v)
+	}
+	data, err1 := strconv.Atoi(k)
+	parity, err2 := strconv.Atoi(m)
+	if err1 != nil || err2 != nil {
+		return fmt.Errorf("invalid parity %q, expected K/M", v)
+	}
+	if data < 1 || parity < 1 || data+parity > 256 {
+		return fmt.Errorf("invalid parity %q, K and M must be at least 1 and K+M at most 256", v)
+	}
+	p.Data, p.Parity = data, parity
+	return nil
+}
+
+// parityLinePrefix starts the manifest line recording a parity group, it is
+// a comment so readers that don't know about parity skip it.
+const parityLinePrefix = "# parity "
+
+// parityGroup is the chunks of a "# parity SHARDSIZE ID:SIZE,... ID,..."
+// manifest line. Data chunks are padded with zeros to the shard size,
+// the size of every parity chunk.
+type parityGroup struct {
+	shardSize int
+	data      []ManifestChunk
+	parity    []ManifestChunk
+}
+
+func (g parityGroup) chunks() []ManifestChunk {
+	return append(append([]ManifestChunk(nil), g.data...), g.parity...)
+}
+
+// ParityWriter stores chunks like Store.Processor, grouping them and
+// storing parity chunks for each group, so lost or corrupt chunks can be
+// rebuilt by Store.Repair. Groups are recorded as manifest comment lines.
+type ParityWriter struct {
+	store  *Store
+	parity Parity
+	enc    reedsolomon.Encoder
+
+	mu    sync.Mutex
+	group []parityShard
+}
+
+type parityShard struct {
+	id   string
+	data []byte
+}
+
+// NewParityWriter returns a ParityWriter storing chunks and their parity
+// in s.
+func NewParityWriter(s *Store, p Parity) (*ParityWriter, error) {
+	enc, err := reedsolomon.New(p.Data, p.Parity)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parity %d/%d: %s", p.Data, p.Parity, err)
+	}
+	return &ParityWriter{store: s, parity: p, enc: enc}, nil
+}
+
+// Processor returns a Processor that puts each chunk in the store, writing
+// a "hash size offset" line per chunk and a parity line once a group is
+// complete. Groups are filled in the order chunks are processed, the data
+// of a group is kept in memory until its parity is stored.
+func (w *ParityWriter) Processor() Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		id, err := w.store.Put(c.Data)
+		if err != nil {
+			return err
+		}
+		err = writeManifestLine(out, id, c)
+		if err != nil {
+			return fmt.Errorf("error writing manifest line: %s", err)
+		}
+
+		w.mu.Lock()
+		// The chunk data is only valid until the processor returns.
+		w.group = append(w.group, parityShard{id: id, data: append([]byte(nil), c.Data...)})
+		var group []parityShard
+		if len(w.group) == w.parity.Data {
+			group, w.group = w.group, nil
+		}
+		w.mu.Unlock()
+
+		if group == nil {
+			return nil
+		}
+		return w.writeGroup(group, out)
+	})
+}
+
+// Flush stores the parity of any incomplete group, writing its parity line
+// to out. Call it once all chunks are processed.
+func (w *ParityWriter) Flush(out io.Writer) error {
+	w.mu.Lock()
+	group := w.group
+	w.group = nil
+	w.mu.Unlock()
+	if len(group) == 0 {
+		return nil
+	}
+	return w.writeGroup(group, out)
+}
+
+// writeGroup stores the parity chunks of group and writes its parity line.
+func (w *ParityWriter) writeGroup(group []parityShard, out io.Writer) error {
+	enc := w.enc
+	if len(group) != w.parity.Data {
+		// The last group of a run may be short.
+		var err error
+		enc, err = reedsolomon.New(len(group), w.parity.Parity)
+		if err != nil {
+			return fmt.Errorf("error computing parity: %s", err)
+		}
+	}
+	shardSize := 0
+	for _, s := range group {
+		shardSize = max(shardSize, len(s.data))
+	}
+	shards := make([][]byte, len(group)+w.parity.Parity)
+	for i := range shards {
+		shards[i] = make([]byte, shardSize)
+		if i < len(group) {
+			copy(shards[i], group[i].data)
+		}
+	}
+	err := enc.Encode(shards)
+	if err != nil {
+		return fmt.Errorf("error computing parity: %s", err)
+	}
+
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s%d ", parityLinePrefix, shardSize)
+	for i, s := range group {
+		if i != 0 {
+			line.WriteByte(',')
+		}
+		fmt.Fprintf(&line, "%s:%d", s.id, len(s.data))
+	}
+	line.WriteByte(' ')
+	for i, shard := range shards[len(group):] {
+		id, err := w.store.Put(shard)
+		if err != nil {
+			return err
+		}
+		if i != 0 {
+			line.WriteByte(',')
+		}
+		line.WriteString(id)
+	}
+	line.WriteByte('\n')
+	_, err = io.WriteString(out, line.String())
+	if err != nil {
+		return fmt.Errorf("error writing parity line: %s", err)
+	}
+	return nil
+}
+
+// readParityGroups reads the parity lines of a manifest.
+func readParityGroups(manifest io.Reader) ([]parityGroup, error) {
+	scanner := bufio.NewScanner(manifest)
+	var groups []parityGroup
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line, ok := strings.CutPrefix(scanner.Text(), parityLinePrefix)
+		if !ok {
+			continue
+		}
+		g, err := parseParityLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("manifest line %d has invalid parity: %s", lineNo, err)
+		}
+		groups = append(groups, g)
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %s", err)
+	}
+	return groups, nil
+}
+
+func parseParityLine(line string) (parityGroup, error) {
+	var g parityGroup
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return g, errors.New("expected a shard size, data chunks and parity chunks")
+	}
+	var err error
+	g.shardSize, err = strconv.Atoi(fields[0])
+	if err != nil || g.shardSize < 0 {
+		return g, fmt.Errorf("invalid shard size %q", fields[0])
+	}
+	for _, chunk := range strings.Split(fields[1], ",") {
+		id, size, ok := strings.Cut(chunk, ":")
+		n, err := strconv.ParseUint(size, 10, 64)
+		if !ok || err != nil || n > uint64(g.shardSize) {
+			return g, fmt.Errorf("invalid data chunk %q", chunk)
+		}
+		g.data = append(g.data, ManifestChunk{ID: id, Size: n})
+	}
+	for _, id := range strings.Split(fields[2], ",") {
+		g.parity = append(g.parity, ManifestChunk{ID: id, Size: uint64(g.shardSize)})
+	}
+	if len(g.data)+len(g.parity) > 256 {
+		return g, errors.New("more than 256 chunks")
+	}
+	return g, nil
+}
+
+// RepairResult counts the chunks of the parity groups checked by
+// Store.Repair.
+type RepairResult struct {
+	Groups        int64
+	Repaired      int64
+	Unrecoverable int64
+}
+
+// Repair checks the chunks of every parity group of manifest, rebuilding
+// missing or corrupt chunks from the rest of their group and writing a
+// "repaired ID" line to report for each. If a group has lost more chunks
+// than it has parity chunks, an "unrecoverable ID" line is written for each
+// of its bad chunks. The error is only for failing to read the manifest,
+// write to the store or write the report.
+func (s *Store) Repair(manifest io.Reader, report io.Writer) (RepairResult, error) {
+	var result RepairResult
+	groups, err := readParityGroups(manifest)
+	if err != nil {
+		return result, err
+	}
+	for _, g := range groups {
+		result.Groups += 1
+		err = s.repairGroup(g, &result, report)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (s *Store) repairGroup(g parityGroup, result *RepairResult, report io.Writer) error {
+	enc, err := reedsolomon.New(len(g.data), len(g.parity))
+	if err != nil {
+		return fmt.Errorf("invalid parity group: %s", err)
+	}
+
+	chunks := g.chunks()
+	shards := make([][]byte, len(chunks))
+	var bad []int
+	for i, c := range chunks {
+		// Missing and unreadable chunks are rebuilt like corrupt ones.
+		data, err := s.read(c.ID)
+		if err == nil {
+			reason, err := s.checkChunk(c, data)
+			if err != nil {
+				return err
+			}
+			if reason != "" {
+				data = nil
+			}
+		}
+		if err != nil || data == nil {
+			bad = append(bad, i)
+			continue
+		}
+		shards[i] = make([]byte, g.shardSize)
+		copy(shards[i], data)
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+
+	writeReport := func(format string, id string) error {
+		_, err := fmt.Fprintf(report, format, id)
+		if err != nil {
+			return fmt.Errorf("error writing repair report: %s", err)
+		}
+		return nil
+	}
+	if len(bad) > len(g.parity) {
+		for _, i := range bad {
+			result.Unrecoverable += 1
+			err = writeReport("unrecoverable %s\n", chunks[i].ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err = enc.Reconstruct(shards)
+	if err != nil {
+		return fmt.Errorf("error rebuilding chunks: %s", err)
+	}
+	for _, i := range bad {
+		c := chunks[i]
+		data := shards[i][:c.Size]
+		id, err := s.ID(data)
+		if err != nil {
+			return err
+		}
+		if id != c.ID {
+			return fmt.Errorf("rebuilt chunk %s hashes to %s", c.ID, id)
+		}
+		// A corrupt chunk must be replaced, Put would keep it.
+		err = s.put(c.ID, data)
+		if err != nil {
+			return err
+		}
+		result.Repaired += 1
+		err = writeReport("repaired %s\n", c.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package cchunker
+
+import (
+	"fmt"
+
+	"github.com/restic/chunker"
+	"golang.org/x/crypto/argon2"
+)
+
+// passphraseSalt is fixed so the same passphrase always gives the same
+// polynomial, it only separates this use of the passphrase from others.
+const passphraseSalt = "cchunker polynomial from passphrase v1"
+
+// PolynomialFromPassphrase deterministically derives an irreducible
+// polynomial from a passphrase, stretched with argon2id, so a secret
+// polynomial can be reproduced without storing it.
+func PolynomialFromPassphrase(passphrase []byte) (chunker.Pol, error) {
+	if len(passphrase) == 0 {
+		return 0, fmt.Errorf("passphrase is empty")
+	}
+	seed := argon2.IDKey(passphrase, []byte(passphraseSalt), 3, 64*1024, 4, 32)
+	p, err := chunker.DerivePolynomial(&hashStream{seed: seed})
+	if err != nil {
+		return 0, fmt.Errorf("unable to derive polynomial from passphrase: %s", err)
+	}
+	return p, nil
+}
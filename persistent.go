@@ -0,0 +1,182 @@
+package cchunker
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+// PersistentProcessor is a Processor that launches the processing command
+// once and feeds it every chunk over its stdin.
+//
+// Each chunk is framed as a big endian uint64 length followed by the chunk
+// data, after each chunk the command must print exactly one result line on
+// stdout, which may end with "\r\n" as is usual on windows, it is passed on
+// ending with "\n". When there are no more chunks, stdin is closed and the command
+// should exit successfully.
+type PersistentProcessor struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   *bufio.Reader
+	timeout  time.Duration
+	timedOut atomic.Bool
+}
+
+// PersistentOptions controls how a persistent processing command is run.
+type PersistentOptions struct {
+	// Timeout, if not zero, is how long the command may take to process
+	// a chunk before it is killed along with its process group, failing
+	// that chunk and every chunk after it.
+	Timeout time.Duration
+	// Commander makes the command, the SystemCommander if nil.
+	Commander Commander
+}
+
+// StartPersistentProcessor launches the command+arguments in args.
+func StartPersistentProcessor(args []string, opts PersistentOptions) (*PersistentProcessor, error) {
+	cmd := commander(opts.Commander).Command(context.Background(), args)
+	cmd.Stderr = os.Stderr
+	setProcessGroup(cmd)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating processor stdin: %s", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating processor stdout: %s", err)
+	}
+
+	err = startCommand(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("error starting chunk processing command: %s", err)
+	}
+
+	return &PersistentProcessor{
+		cmd:     cmd,
+		stdin:   stdin,
+		stdout:  bufio.NewReader(stdout),
+		timeout: opts.Timeout,
+	}, nil
+}
+
+// Process sends c to the command and copies its result line to out.
+func (p *PersistentProcessor) Process(c Chunk, out io.Writer) error {
+	if p.timedOut.Load() {
+		return fmt.Errorf("chunk processing command was killed after timing out")
+	}
+	if p.timeout != 0 {
+		timer := time.AfterFunc(p.timeout, func() {
+			p.timedOut.Store(true)
+			killProcessGroup(p.cmd)
+		})
+		defer timer.Stop()
+	}
+
+	var hdr [8]byte
+	binary.BigEndian.PutUint64(hdr[:], uint64(len(c.Data)))
+	_, err := p.stdin.Write(hdr[:])
+	if err == nil {
+		_, err = p.stdin.Write(c.Data)
+	}
+	if err != nil && p.timedOut.Load() {
+		return fmt.Errorf("chunk processing command timed out after %s", p.timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("error sending chunk to processing command: %s", err)
+	}
+
+	line, err := p.stdout.ReadBytes('\n')
+	if err != nil && p.timedOut.Load() {
+		return fmt.Errorf("chunk processing command timed out after %s", p.timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading result line from processing command: %s", err)
+	}
+	line = trimCR(line)
+
+	_, err = out.Write(line)
+	if err != nil {
+		return fmt.Errorf("error writing result line: %s", err)
+	}
+	return nil
+}
+
+// Close signals the end of the chunk stream and waits for the command to exit.
+func (p *PersistentProcessor) Close() error {
+	err := p.stdin.Close()
+	if err != nil {
+		return fmt.Errorf("error closing processor stdin: %s", err)
+	}
+	err = p.cmd.Wait()
+	commandDone(p.cmd)
+	if p.timedOut.Load() {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error running chunk processing command: %s", err)
+	}
+	return nil
+}
+
+// PersistentPool is a Processor that launches several persistent processing
+// commands once and hands each chunk to an idle one, so chunks can be
+// processed concurrently without starting a command per chunk. Run it with
+// Pipeline.Jobs set to the number of workers, the pipeline puts the results
+// back in chunk order.
+type PersistentPool struct {
+	workers []*PersistentProcessor
+	idle    chan *PersistentProcessor
+}
+
+// StartPersistentPool launches n copies of the command+arguments in args,
+// each speaking the PersistentProcessor protocol. A worker timing out fails
+// every chunk later handed to it, see PersistentOptions.
+func StartPersistentPool(args []string, n int, opts PersistentOptions) (*PersistentPool, error) {
+	p := &PersistentPool{idle: make(chan *PersistentProcessor, n)}
+	for i := 0; i < n; i += 1 {
+		w, err := StartPersistentProcessor(args, opts)
+		if err != nil {
+			p.kill()
+			return nil, err
+		}
+		p.workers = append(p.workers, w)
+		p.idle <- w
+	}
+	return p, nil
+}
+
+// Process sends c to the next idle worker and copies its result line to out.
+func (p *PersistentPool) Process(c Chunk, out io.Writer) error {
+	w := <-p.idle
+	defer func() { p.idle <- w }()
+	return w.Process(c, out)
+}
+
+// Close signals the end of the chunk stream to every worker and waits for
+// them all to exit, returning the first error.
+func (p *PersistentPool) Close() error {
+	var err error
+	for _, w := range p.workers {
+		if err2 := w.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// kill stops the workers started so far.
+func (p *PersistentPool) kill() {
+	for _, w := range p.workers {
+		killProcessGroup(w.cmd)
+		w.stdin.Close()
+		w.cmd.Wait()
+		commandDone(w.cmd)
+	}
+}
@@ -0,0 +1,18 @@
+package cchunker
+
+import "strings"
+
+// pipePrefix starts the names of windows named pipes on this machine.
+const pipePrefix = `\\.\pipe\`
+
+// IsPipeName reports if name is a windows named pipe, e.g. \\.\pipe\cchunker,
+// to be listened on with ListenPipe.
+func IsPipeName(name string) bool {
+	return len(name) > len(pipePrefix) && strings.EqualFold(name[:len(pipePrefix)], pipePrefix)
+}
+
+// pipeAddr is the net.Addr of a named pipe.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
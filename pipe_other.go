@@ -0,0 +1,13 @@
+//go:build !windows
+
+package cchunker
+
+import (
+	"errors"
+	"net"
+)
+
+// ListenPipe is only supported on windows, use a unix socket instead.
+func ListenPipe(name string) (net.Listener, error) {
+	return nil, errors.New("named pipes are only supported on windows, use a unix socket")
+}
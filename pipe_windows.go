@@ -0,0 +1,140 @@
+//go:build windows
+
+package cchunker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/windows"
+)
+
+// pipeBufferSize is the size of the pipe buffers in each direction.
+const pipeBufferSize = 64 * 1024
+
+// ListenPipe listens on the named pipe name, e.g. \\.\pipe\cchunker, for
+// connections from processes on this machine. Only the user running it and
+// administrators can connect, as with the default access of a named pipe.
+// It fails if another process is already listening on the pipe.
+func ListenPipe(name string) (net.Listener, error) {
+	if !IsPipeName(name) {
+		return nil, fmt.Errorf("%q is not a named pipe, expected \\\\.\\pipe\\NAME", name)
+	}
+	path, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %s", name, err)
+	}
+	l := &pipeListener{name: name, path: path}
+	l.next, err = l.newInstance(true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %s", name, err)
+	}
+	return l, nil
+}
+
+// pipeListener accepts connections on a named pipe. A pipe instance only
+// serves one client, so there is always one more instance waiting for the
+// next client than there are clients connected.
+type pipeListener struct {
+	name string
+	path *uint16
+
+	mu        sync.Mutex
+	next      windows.Handle
+	accepting bool
+	closed    bool
+}
+
+func (l *pipeListener) newInstance(first bool) (windows.Handle, error) {
+	flags := uint32(windows.PIPE_ACCESS_DUPLEX)
+	if first {
+		// Fail rather than share the pipe with another server.
+		flags |= windows.FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+	mode := uint32(windows.PIPE_TYPE_BYTE | windows.PIPE_READMODE_BYTE | windows.PIPE_WAIT | windows.PIPE_REJECT_REMOTE_CLIENTS)
+	return windows.CreateNamedPipe(l.path, flags, mode, windows.PIPE_UNLIMITED_INSTANCES, pipeBufferSize, pipeBufferSize, 0, nil)
+}
+
+// Accept waits for a client to connect to the pipe.
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	h := l.next
+	l.accepting = true
+	l.mu.Unlock()
+
+	err := windows.ConnectNamedPipe(h, nil)
+	if errors.Is(err, windows.ERROR_PIPE_CONNECTED) {
+		// The client connected before we waited.
+		err = nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.accepting = false
+	if l.closed {
+		// Woken by Close.
+		windows.CloseHandle(h)
+		return nil, net.ErrClosed
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error accepting connection on %s: %s", l.name, err)
+	}
+	l.next, err = l.newInstance(false)
+	if err != nil {
+		l.closed = true
+		windows.CloseHandle(h)
+		return nil, fmt.Errorf("error creating pipe instance of %s: %s", l.name, err)
+	}
+	return &pipeConn{File: os.NewFile(uintptr(h), l.name), addr: pipeAddr(l.name)}, nil
+}
+
+// Close stops listening, connections already accepted are left open.
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	accepting := l.accepting
+	if !accepting {
+		windows.CloseHandle(l.next)
+	}
+	l.mu.Unlock()
+
+	if accepting {
+		// ConnectNamedPipe can't be interrupted, so connect to the
+		// waiting instance to wake Accept, which then closes it.
+		h, err := windows.CreateFile(l.path, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING, 0, 0)
+		if err == nil {
+			windows.CloseHandle(h)
+		}
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr(l.name)
+}
+
+// pipeConn is a connected pipe instance. Deadlines are not supported.
+type pipeConn struct {
+	*os.File
+	addr pipeAddr
+}
+
+func (c *pipeConn) Close() error {
+	windows.FlushFileBuffers(windows.Handle(c.Fd()))
+	windows.DisconnectNamedPipe(windows.Handle(c.Fd()))
+	return c.File.Close()
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.addr }
@@ -0,0 +1,380 @@
+// Package cchunker does content defined chunking of data streams, handing
+// each chunk to a processor. Content chunking has the special property that
+// chunks will be shared across similar data, this makes these chunks suitable
+// for deduplicating backup programs.
+package cchunker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/restic/chunker"
+)
+
+// ErrInterrupted is returned by a run ended early by its Stop channel.
+var ErrInterrupted = errors.New("interrupted")
+
+// InputError is a run failing to read the stream being chunked.
+type InputError struct {
+	Err error
+}
+
+func (e *InputError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
+
+// ProcessorError is a run failing because its Processor returned Err.
+type ProcessorError struct {
+	Err error
+}
+
+func (e *ProcessorError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProcessorError) Unwrap() error {
+	return e.Err
+}
+
+// Chunk is a single content defined chunk of the input stream.
+type Chunk struct {
+	// Index is the position of the chunk in the stream, starting at zero.
+	Index int64
+	// Offset is the byte offset of the chunk in the stream.
+	Offset uint64
+	// Cut is the rabin fingerprint at the chunk boundary.
+	Cut uint64
+	// Data is only valid until the processor returns, its buffer is
+	// then released to be reused for a later chunk.
+	Data []byte
+	// RawLength is the length of the chunk in the stream when Data has
+	// been transformed, for example compressed, or zero if it has not.
+	RawLength int
+	// SequenceID, if not empty, is the id set by SequenceIDProcessor
+	// identifying the chunk at its position in the stream.
+	SequenceID string
+}
+
+// Processor does an arbitrary action with a chunk, writing any output for
+// the chunk to out. A processor keeping the chunk data after Process
+// returns must copy it.
+type Processor interface {
+	Process(c Chunk, out io.Writer) error
+}
+
+// ProcessorFunc adapts an ordinary function into a Processor.
+type ProcessorFunc func(c Chunk, out io.Writer) error
+
+// Process calls f(c, out).
+func (f ProcessorFunc) Process(c Chunk, out io.Writer) error {
+	return f(c, out)
+}
+
+// Pipeline splits a stream into chunks and runs a processor on each chunk.
+type Pipeline struct {
+	Params    Params
+	Processor Processor
+	// Jobs is the number of chunks processed concurrently, output is still
+	// written in chunk order. If Jobs is greater than one, Processor
+	// must be safe for concurrent use.
+	Jobs int
+	// Resume continues an interrupted run from a Checkpoint, or starts a
+	// run part way into a stream, the reader passed to Run must already
+	// be positioned at its offset.
+	Resume Checkpoint
+	// Checkpoint, if set, is called in chunk order once the output of each
+	// chunk is written, with that output and the state needed to resume
+	// the run after the chunk.
+	Checkpoint func(cp Checkpoint, output []byte) error
+	// Stop, if set, ends the run early when it is closed. No more chunks
+	// are started, chunks already being processed are finished and their
+	// output written, then Run returns ErrInterrupted.
+	Stop <-chan struct{}
+	// MaxChunks, if not zero, fails the run before processing a chunk
+	// with an index of MaxChunks or more, protecting whatever consumes
+	// the output from inputs or sizes cutting far more chunks than
+	// expected.
+	MaxChunks int64
+	// StreamHash, if set, is written the data of every chunk in stream
+	// order as it is split, so once Run returns without error it holds
+	// the digest of the whole stream.
+	StreamHash hash.Hash
+}
+
+// Checkpoint is the state of a Pipeline run between two chunks. The chunkers
+// start afresh at every chunk boundary, so resuming a run only needs the
+// position of the boundary.
+type Checkpoint struct {
+	// Chunks is the number of chunks before the boundary.
+	Chunks int64 `json:"chunks"`
+	// Offset is the stream offset of the boundary.
+	Offset uint64 `json:"offset"`
+}
+
+// after returns the checkpoint following the chunk c.
+func after(c Chunk) Checkpoint {
+	return Checkpoint{
+		Chunks: c.Index + 1,
+		Offset: c.Offset + uint64(len(c.Data)),
+	}
+}
+
+// Run chunks all of r, writing processor output to out. It returns the
+// number of chunks processed, not including any before p.Resume.
+func (p *Pipeline) Run(r io.Reader, out io.Writer) (int64, error) {
+	err := p.Params.Validate()
+	if err != nil {
+		return 0, err
+	}
+
+	if p.Jobs > 1 {
+		return p.runParallel(r, out)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	chunks := p.readAhead(r, done)
+
+	nChunks := int64(0)
+	for {
+		select {
+		case <-p.Stop:
+			return nChunks, ErrInterrupted
+		default:
+		}
+
+		next := <-chunks
+		chunk, err := next.chunk, next.err
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nChunks, &InputError{fmt.Errorf("error getting next data chunk: %s", err)}
+		}
+
+		c := Chunk{
+			Index:  p.Resume.Chunks + nChunks,
+			Offset: p.Resume.Offset + uint64(chunk.Start),
+			Cut:    chunk.Cut,
+			Data:   chunk.Data,
+		}
+		err = p.checkLimit(c)
+		if err != nil {
+			return nChunks, err
+		}
+		if p.Checkpoint == nil {
+			err = p.Processor.Process(c, out)
+			if err != nil {
+				return nChunks, &ProcessorError{err}
+			}
+		} else {
+			// The output is needed for the checkpoint too.
+			var output bytes.Buffer
+			err = p.Processor.Process(c, &output)
+			_, writeErr := out.Write(output.Bytes())
+			if writeErr != nil {
+				return nChunks, fmt.Errorf("error writing chunk output: %s", writeErr)
+			}
+			if err != nil {
+				return nChunks, &ProcessorError{err}
+			}
+			err = p.Checkpoint(after(c), output.Bytes())
+			if err != nil {
+				return nChunks, err
+			}
+		}
+		next.release()
+
+		nChunks += 1
+	}
+
+	return nChunks, nil
+}
+
+// checkLimit returns an error if c is past p.MaxChunks.
+func (p *Pipeline) checkLimit(c Chunk) error {
+	if p.MaxChunks != 0 && c.Index >= p.MaxChunks {
+		return fmt.Errorf("input has more than the limit of %d chunks, check the chunk sizes suit the input", p.MaxChunks)
+	}
+	return nil
+}
+
+// chunkBuffers holds MaxSize buffers for chunk data, shared by all runs
+// so the buffers aren't reallocated for every run or chunk.
+var chunkBuffers sync.Pool
+
+// getChunkBuffer returns a buffer of size bytes, reusing a released one
+// if it is large enough.
+func getChunkBuffer(size uint) []byte {
+	buf, _ := chunkBuffers.Get().([]byte)
+	if uint(cap(buf)) < size {
+		buf = make([]byte, size)
+	}
+	return buf[:size]
+}
+
+// putChunkBuffer releases buf for reuse, the data in it must no longer
+// be used.
+func putChunkBuffer(buf []byte) {
+	chunkBuffers.Put(buf)
+}
+
+// readChunk is a chunk read ahead of the one being processed, its buffer
+// must be released once the chunk is done with.
+type readChunk struct {
+	chunk   chunker.Chunk
+	err     error
+	release func()
+}
+
+// readAhead splits r in the background, so the next chunk is read while
+// the processor runs on the current one. Each chunk is split into its own
+// pooled buffer, so the data of a chunk stays valid until it is released.
+// The splitter stops at the first error, or once done is closed.
+func (p *Pipeline) readAhead(r io.Reader, done <-chan struct{}) <-chan readChunk {
+	cchunker := p.Params.newChunker(r)
+	// Unbuffered, so only the chunk being processed and the one read
+	// ahead hold buffers.
+	chunks := make(chan readChunk)
+	go func() {
+		for {
+			buf := getChunkBuffer(p.Params.MaxSize)
+			chunk, err := cchunker.Next(buf)
+			if err == nil && p.StreamHash != nil {
+				p.StreamHash.Write(chunk.Data)
+			}
+			next := readChunk{
+				chunk:   chunk,
+				err:     err,
+				release: func() { putChunkBuffer(buf) },
+			}
+			select {
+			case chunks <- next:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return chunks
+}
+
+type pendingChunk struct {
+	next Checkpoint
+	out  bytes.Buffer
+	err  error
+	done chan struct{}
+}
+
+func (p *Pipeline) runParallel(r io.Reader, out io.Writer) (int64, error) {
+	cchunker := p.Params.newChunker(r)
+
+	sem := make(chan struct{}, p.Jobs)
+	pending := make(chan *pendingChunk, p.Jobs)
+	failed := make(chan struct{})
+	writeErr := make(chan error, 1)
+
+	// Write results in chunk order as they complete. Chunks are queued on
+	// pending in index order and each one is waited on in turn, so the
+	// output is byte identical however the processors are scheduled.
+	go func() {
+		var err error
+		for c := range pending {
+			<-c.done
+			if err != nil {
+				continue
+			}
+			// Output of a failed chunk is still written, as it
+			// would be when running serially.
+			_, err = out.Write(c.out.Bytes())
+			if err != nil {
+				err = fmt.Errorf("error writing chunk output: %s", err)
+			} else {
+				err = c.err
+			}
+			if err == nil && p.Checkpoint != nil {
+				err = p.Checkpoint(c.next, c.out.Bytes())
+			}
+			if err != nil {
+				close(failed)
+			}
+		}
+		writeErr <- err
+	}()
+
+	var readErr error
+	nChunks := int64(0)
+loop:
+	for {
+		select {
+		case <-failed:
+			break loop
+		case <-p.Stop:
+			readErr = ErrInterrupted
+			break loop
+		default:
+		}
+
+		// Each chunk is split into its own buffer, handed to the
+		// processor without a copy and released once it returns.
+		buf := getChunkBuffer(p.Params.MaxSize)
+		chunk, err := cchunker.Next(buf)
+		if err == io.EOF {
+			putChunkBuffer(buf)
+			break
+		}
+		if err != nil {
+			putChunkBuffer(buf)
+			readErr = &InputError{fmt.Errorf("error getting next data chunk: %s", err)}
+			break
+		}
+		if p.StreamHash != nil {
+			p.StreamHash.Write(chunk.Data)
+		}
+
+		c := Chunk{
+			Index:  p.Resume.Chunks + nChunks,
+			Offset: p.Resume.Offset + uint64(chunk.Start),
+			Cut:    chunk.Cut,
+			Data:   chunk.Data,
+		}
+		err = p.checkLimit(c)
+		if err != nil {
+			putChunkBuffer(buf)
+			readErr = err
+			break
+		}
+		pc := &pendingChunk{next: after(c), done: make(chan struct{})}
+
+		sem <- struct{}{}
+		pending <- pc
+		go func() {
+			err := p.Processor.Process(c, &pc.out)
+			if err != nil {
+				pc.err = &ProcessorError{err}
+			}
+			putChunkBuffer(buf)
+			<-sem
+			close(pc.done)
+		}()
+
+		nChunks += 1
+	}
+
+	close(pending)
+	err := <-writeErr
+	if err != nil {
+		return nChunks, err
+	}
+	return nChunks, readErr
+}
@@ -0,0 +1,24 @@
+//go:build !unix && !windows
+
+package cchunker
+
+import (
+	"context"
+	"os/exec"
+)
+
+func systemCommand(ctx context.Context, args []string) *exec.Cmd {
+	return exec.CommandContext(ctx, args[0], args[1:]...)
+}
+
+func shellArgs(line string) []string {
+	return []string{"sh", "-c", line}
+}
+
+// setProcessGroup does nothing, process groups are only supported on unix.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills cmd.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package cchunker
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+func systemCommand(ctx context.Context, args []string) *exec.Cmd {
+	return exec.CommandContext(ctx, args[0], args[1:]...)
+}
+
+func shellArgs(line string) []string {
+	return []string{"sh", "-c", line}
+}
+
+// setProcessGroup makes cmd the leader of a new process group, so
+// killProcessGroup also kills any processes it starts.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills cmd and its process group.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
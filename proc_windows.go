@@ -0,0 +1,99 @@
+//go:build windows
+
+package cchunker
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func systemCommand(ctx context.Context, args []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if len(args) == 4 && isShellCommand(args[0]) && strings.EqualFold(args[1], "/S") && strings.EqualFold(args[2], "/C") {
+		// cmd.exe does not unquote its command line like other programs,
+		// with /S it runs whatever is between the first and last quote
+		// as it is, so the line must not be escaped.
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			CmdLine: syscall.EscapeArg(args[0]) + ` /S /C "` + args[3] + `"`,
+		}
+	}
+	return cmd
+}
+
+// shell returns the path of cmd.exe.
+func shell() string {
+	comspec := os.Getenv("ComSpec")
+	if comspec == "" {
+		return "cmd.exe"
+	}
+	return comspec
+}
+
+func shellArgs(line string) []string {
+	return []string{shell(), "/S", "/C", line}
+}
+
+// setProcessGroup starts cmd in a new process group, so a Ctrl-C in the
+// console is left to cchunker to handle rather than reaching the command.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+// killProcessGroup kills cmd and the processes it started. Windows
+// process groups can't be killed as one, so the processes descended from
+// cmd are found by their parent process ids.
+func killProcessGroup(cmd *exec.Cmd) error {
+	children := childProcesses()
+	err := cmd.Process.Kill()
+	killDescendants(children, uint32(cmd.Process.Pid), make(map[uint32]bool))
+	return err
+}
+
+// childProcesses returns the ids of the running processes by the id of
+// their parent process.
+func childProcesses() map[uint32][]uint32 {
+	children := make(map[uint32][]uint32)
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return children
+	}
+	defer windows.CloseHandle(snapshot)
+	var entry windows.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		children[entry.ParentProcessID] = append(children[entry.ParentProcessID], entry.ProcessID)
+	}
+	return children
+}
+
+// killDescendants kills the processes descended from pid. Parent process
+// ids are not cleared when the parent exits and may have been reused, so
+// the ids seen are tracked to stop at any cycle.
+func killDescendants(children map[uint32][]uint32, pid uint32, seen map[uint32]bool) {
+	seen[pid] = true
+	for _, child := range children[pid] {
+		if seen[child] {
+			continue
+		}
+		h, err := windows.OpenProcess(windows.PROCESS_TERMINATE, false, child)
+		if err == nil {
+			windows.TerminateProcess(h, 1)
+			windows.CloseHandle(h)
+		}
+		killDescendants(children, child, seen)
+	}
+}
+
+// isShellCommand reports if name is cmd.exe.
+func isShellCommand(name string) bool {
+	return strings.EqualFold(name, shell())
+}
@@ -0,0 +1,209 @@
+package cchunker
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// provenancePrefix starts the line recording the version and settings a
+// manifest was made with, manifest readers skip lines starting with '#'.
+const provenancePrefix = "# cchunker "
+
+// Version returns the version of cchunker from the build information
+// embedded in the binary, the module version when built with go install,
+// otherwise "devel" with any VCS revision, suffixed by -dirty if the
+// checkout was modified.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	version := "devel"
+	var revision string
+	var modified bool
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if revision != "" {
+		version += "+" + revision[:min(len(revision), 12)]
+		if modified {
+			version += "-dirty"
+		}
+	}
+	return version
+}
+
+// Provenance is the cchunker version and chunk parameters a manifest or
+// summary was made with.
+type Provenance struct {
+	Version   string
+	Algorithm string
+	// Fingerprint identifies the polynomial, buzhash seed and window and
+	// any chunk key, without revealing them, so secret parameters can be
+	// compared.
+	Fingerprint string
+	MinSize     uint
+	MaxSize     uint
+	AverageBits int
+	TarAware    bool
+	AnchorDelim string
+}
+
+// NewProvenance returns the provenance of a run of this build with params.
+func NewProvenance(params Params) Provenance {
+	algorithm := params.Algorithm
+	if algorithm == "" {
+		algorithm = "rabin"
+	}
+	p := Provenance{
+		Version:     Version(),
+		Algorithm:   algorithm,
+		MinSize:     params.MinSize,
+		MaxSize:     params.MaxSize,
+		AverageBits: params.AverageBits,
+		TarAware:    params.TarAware,
+		AnchorDelim: hex.EncodeToString(params.AnchorDelim),
+	}
+	if algorithm != "fixed" {
+		h := sha256.New()
+		var buf [8]byte
+		for _, v := range []uint64{uint64(params.Polynomial), uint64(params.Seed), uint64(params.WindowSize)} {
+			binary.BigEndian.PutUint64(buf[:], v)
+			h.Write(buf[:])
+		}
+		h.Write(params.Key)
+		p.Fingerprint = hex.EncodeToString(h.Sum(nil)[:8])
+	}
+	return p
+}
+
+// String returns the provenance line, without a newline.
+func (p Provenance) String() string {
+	fields := []string{
+		"version=" + p.Version,
+		"algorithm=" + p.Algorithm,
+	}
+	if p.Fingerprint != "" {
+		fields = append(fields, "fingerprint="+p.Fingerprint)
+	}
+	fields = append(fields,
+		"min-size="+FormatSize(uint64(p.MinSize)),
+		"avg-bits="+strconv.Itoa(p.AverageBits),
+		"max-size="+FormatSize(uint64(p.MaxSize)),
+	)
+	if p.TarAware {
+		fields = append(fields, "tar-aware=true")
+	}
+	if p.AnchorDelim != "" {
+		fields = append(fields, "anchor-delim="+p.AnchorDelim)
+	}
+	return provenancePrefix + strings.Join(fields, " ")
+}
+
+// WriteProvenance writes the provenance line of a run with params.
+func WriteProvenance(out io.Writer, params Params) error {
+	_, err := fmt.Fprintln(out, NewProvenance(params))
+	if err != nil {
+		return fmt.Errorf("error writing provenance: %s", err)
+	}
+	return nil
+}
+
+// ParseProvenance parses a provenance line, unknown fields are ignored so
+// lines of later versions still parse.
+func ParseProvenance(line string) (Provenance, bool) {
+	if !strings.HasPrefix(line, provenancePrefix) {
+		return Provenance{}, false
+	}
+	var p Provenance
+	for _, field := range strings.Fields(line[len(provenancePrefix):]) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Provenance{}, false
+		}
+		var err error
+		switch key {
+		case "version":
+			p.Version = value
+		case "algorithm":
+			p.Algorithm = value
+		case "fingerprint":
+			p.Fingerprint = value
+		case "min-size", "max-size":
+			var size Size
+			err = size.Set(value)
+			if key == "min-size" {
+				p.MinSize = uint(size)
+			} else {
+				p.MaxSize = uint(size)
+			}
+		case "avg-bits":
+			p.AverageBits, err = strconv.Atoi(value)
+		case "tar-aware":
+			p.TarAware, err = strconv.ParseBool(value)
+		case "anchor-delim":
+			p.AnchorDelim = value
+		}
+		if err != nil {
+			return Provenance{}, false
+		}
+	}
+	return p, true
+}
+
+// ReadProvenance returns the first provenance line of manifest, reporting
+// false if it has none, as manifests of earlier versions don't.
+func ReadProvenance(manifest io.Reader) (Provenance, bool, error) {
+	scanner := bufio.NewScanner(manifest)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		p, ok := ParseProvenance(scanner.Text())
+		if ok {
+			return p, true, nil
+		}
+	}
+	err := scanner.Err()
+	if err != nil {
+		return Provenance{}, false, fmt.Errorf("error reading manifest: %s", err)
+	}
+	return Provenance{}, false, nil
+}
+
+// Compatible returns an error listing the settings of p and other that
+// differ in a way that cuts different chunk boundaries, so data chunked
+// with one doesn't deduplicate against data chunked with the other. The
+// versions may differ.
+func (p Provenance) Compatible(other Provenance) error {
+	var diffs []string
+	check := func(name string, a, b any) {
+		if a != b {
+			diffs = append(diffs, fmt.Sprintf("%s %v and %v", name, a, b))
+		}
+	}
+	check("algorithm", p.Algorithm, other.Algorithm)
+	check("fingerprint", p.Fingerprint, other.Fingerprint)
+	check("min size", p.MinSize, other.MinSize)
+	check("average bits", p.AverageBits, other.AverageBits)
+	check("max size", p.MaxSize, other.MaxSize)
+	check("tar aware", p.TarAware, other.TarAware)
+	check("anchor delimiter", p.AnchorDelim, other.AnchorDelim)
+	if len(diffs) != 0 {
+		return fmt.Errorf("chunk parameters differ: %s", strings.Join(diffs, ", "))
+	}
+	return nil
+}
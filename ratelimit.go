@@ -0,0 +1,68 @@
+package cchunker
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseRate parses a human readable byte rate such as 10MiB/s, the /s is
+// optional.
+func ParseRate(v string) (uint64, error) {
+	n, err := ParseSize(strings.TrimSuffix(strings.TrimSpace(v), "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", v)
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("rate %q must be greater than zero", v)
+	}
+	return n, nil
+}
+
+// RateLimiter is a token bucket limiting the bytes per second handed to a
+// processor, allowing a burst of one second worth of bytes.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing bytesPerSecond.
+func NewRateLimiter(bytesPerSecond uint64) *RateLimiter {
+	return &RateLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until n bytes may be sent. Chunks larger than the burst are
+// allowed through by going into debt, delaying the following chunks.
+func (l *RateLimiter) Wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// Processor returns a Processor that waits for the size of each chunk
+// before running p.
+func (l *RateLimiter) Processor(p Processor) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		l.Wait(len(c.Data))
+		return p.Process(c, out)
+	})
+}
@@ -0,0 +1,112 @@
+package cchunker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ResticIndex is an index in the JSON format of a restic repository's
+// index files, before restic encrypts it.
+type ResticIndex struct {
+	Packs []ResticPack `json:"packs"`
+}
+
+// ResticPack is a file holding chunks, identified by the sha256 of its
+// contents as restic identifies its pack files.
+type ResticPack struct {
+	ID    string       `json:"id"`
+	Blobs []ResticBlob `json:"blobs"`
+}
+
+// ResticBlob is a chunk at Offset in its pack, restic blob ids are the
+// sha256 of the chunk data.
+type ResticBlob struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// ResticIndex returns the restic index of the chunks the manifest sections
+// reference, the store must be named by sha256. A chunk in its own file is
+// a pack of that one blob, whose id is the chunk id, and the chunks of a
+// pack file are blobs of a pack named by the sha256 of the pack file.
+// Restic only cuts the same chunks, so shares blob ids, when chunking with
+// SmallParams and the polynomial of the restic repository.
+func (s *Store) ResticIndex(sections []ManifestSection) (ResticIndex, error) {
+	var index ResticIndex
+	if s.Hash != "sha256" {
+		return index, fmt.Errorf("restic blob ids are sha256 hashes, the store is named by %s", s.Hash)
+	}
+
+	seen := make(map[string]bool)
+	// packs are the index in index.Packs of each pack file.
+	packs := make(map[string]int)
+	for _, section := range sections {
+		for _, c := range section.Chunks {
+			if c.ID == ZeroChunkID || seen[c.ID] {
+				continue
+			}
+			seen[c.ID] = true
+			if c.RawSize != 0 {
+				return index, fmt.Errorf("chunk %s is compressed or encrypted, restic blobs are of the chunk data", c.ID)
+			}
+
+			info, err := os.Stat(s.Path(c.ID))
+			if err == nil {
+				index.Packs = append(index.Packs, ResticPack{
+					ID:    c.ID,
+					Blobs: []ResticBlob{{ID: c.ID, Type: "data", Length: info.Size()}},
+				})
+				continue
+			}
+			if !errors.Is(err, os.ErrNotExist) {
+				return index, fmt.Errorf("error checking for chunk %s: %s", c.ID, err)
+			}
+
+			loc, ok, err := s.lookupPacked(c.ID)
+			if err != nil {
+				return index, err
+			}
+			if !ok {
+				return index, fmt.Errorf("chunk %s is missing from the store", c.ID)
+			}
+			i, ok := packs[loc.pack]
+			if !ok {
+				id, err := hashFile(loc.pack)
+				if err != nil {
+					return index, err
+				}
+				i = len(index.Packs)
+				packs[loc.pack] = i
+				index.Packs = append(index.Packs, ResticPack{ID: id})
+			}
+			index.Packs[i].Blobs = append(index.Packs[i].Blobs, ResticBlob{
+				ID:     c.ID,
+				Type:   "data",
+				Offset: loc.offset,
+				Length: loc.size,
+			})
+		}
+	}
+	return index, nil
+}
+
+// hashFile returns the hex sha256 of the contents of path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", fmt.Errorf("error hashing %s: %s", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,39 @@
+package cchunker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// RetryProcessor returns a Processor that runs p again, up to retries more
+// times, when it fails on a chunk. It waits backoff before the first retry,
+// doubling the wait before each retry after that, and notes each failure on
+// log if it is not nil. Only the output of the successful attempt is written.
+func RetryProcessor(retries int, backoff time.Duration, log io.Writer, p Processor) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		var output bytes.Buffer
+		delay := backoff
+		for attempt := 0; ; attempt += 1 {
+			output.Reset()
+			err := p.Process(c, &output)
+			if err == nil {
+				break
+			}
+			if attempt == retries {
+				return err
+			}
+			if log != nil {
+				fmt.Fprintf(log, "chunk %d at offset %d failed, retrying in %s: %s\n", c.Index, c.Offset, delay, err)
+			}
+			time.Sleep(delay)
+			delay *= 2
+		}
+		_, err := out.Write(output.Bytes())
+		if err != nil {
+			return fmt.Errorf("error writing chunk output: %s", err)
+		}
+		return nil
+	})
+}
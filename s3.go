@@ -0,0 +1,121 @@
+package cchunker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+func init() {
+	backends["s3"] = openS3Backend
+}
+
+// s3Backend stores chunks as objects named prefix/id in an S3 compatible bucket.
+type s3Backend struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// openS3Backend opens s3://bucket/prefix, credentials and region are taken from
+// the usual AWS environment variables and configuration files. The endpoint,
+// region and path-style query parameters allow use with S3 compatible
+// services such as MinIO, e.g.
+// s3://bucket/prefix?endpoint=http://localhost:9000&region=us-east-1
+func openS3Backend(u *url.URL) (Backend, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("s3 backend url %q has no bucket", u.String())
+	}
+
+	q := u.Query()
+	var opts []func(*config.LoadOptions) error
+	if region := q.Get("region"); region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error loading aws configuration: %s", err)
+	}
+
+	endpoint := q.Get("endpoint")
+	// Most S3 compatible services do not support virtual hosted buckets.
+	pathStyle := endpoint != "" || q.Get("path-style") == "true"
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.UsePathStyle = pathStyle
+	})
+
+	return &s3Backend{
+		client: client,
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+	}, nil
+}
+
+func (b *s3Backend) key(id string) string {
+	return path.Join(b.prefix, id)
+}
+
+func (b *s3Backend) Has(id string) (bool, error) {
+	_, err := b.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(id)),
+	})
+	if err != nil {
+		// HeadObject has no body, so a missing object is only
+		// reported as a generic NotFound error code.
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotFound" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *s3Backend) Put(id string, data []byte) error {
+	_, err := b.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:        aws.String(b.bucket),
+		Key:           aws.String(b.key(id)),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	return err
+}
+
+// PutSequenced puts the chunk with its sequence id as the
+// cchunker-sequence-id user metadata of the object.
+func (b *s3Backend) PutSequenced(id, sequenceID string, data []byte) error {
+	_, err := b.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:        aws.String(b.bucket),
+		Key:           aws.String(b.key(id)),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		Metadata:      map[string]string{"cchunker-sequence-id": sequenceID},
+	})
+	return err
+}
+
+func (b *s3Backend) Get(id string) ([]byte, error) {
+	resp, err := b.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(id)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,128 @@
+package cchunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// selfTestCase is a set of chunk parameters with the boundaries they must
+// find in the self test data.
+type selfTestCase struct {
+	name   string
+	params Params
+	// chunks is the number of chunks, digest is the hex sha256 of an
+	// "offset length cut" line per chunk.
+	chunks int
+	digest string
+}
+
+// selfTestSize is the size of the self test data, enough for a few chunks
+// of the default parameters.
+const selfTestSize = 24 * miB
+
+var selfTestCases = []selfTestCase{
+	{
+		name:   "rabin-default",
+		params: StandardParams,
+		chunks: 7,
+		digest: "d8e880afb04a63e68b3764cbf434ffa0082625fe87e618ba1c95b84012238b36",
+	},
+	{
+		name:   "rabin-small",
+		params: Params{Polynomial: DefaultPolynomial, MinSize: 8 * kiB, MaxSize: 128 * kiB, AverageBits: 15},
+		chunks: 615,
+		digest: "60510b9a8d030e7ee992a3ee0fd97da3e4db7b8c6bf0fb4c2e7e610d7cdca039",
+	},
+	{
+		name:   "keyed",
+		params: Params{Polynomial: DefaultPolynomial, MinSize: 8 * kiB, MaxSize: 128 * kiB, AverageBits: 15, Key: []byte("cchunker self test key")},
+		chunks: 586,
+		digest: "ee7e28593f1e41f23d38630f77c1fcae3a7dac75e1fceb7354a5da4eb2a3d7ff",
+	},
+	{
+		name:   "buzhash-default",
+		params: BuzhashParams,
+		chunks: 8,
+		digest: "fd481ac845427ca055b2715cf7450e1484d66991fa5339d3480c4462e9c2bc2a",
+	},
+	{
+		name:   "buzhash-small",
+		params: Params{Algorithm: "buzhash", MinSize: 8 * kiB, MaxSize: 128 * kiB, AverageBits: 15, WindowSize: 64, Seed: 0x9e3779b9},
+		chunks: 635,
+		digest: "ca111e56cd17b6525fff356ea1c0ee05d0d8711da57f8cbbea83c276248b725f",
+	},
+	{
+		name:   "fixed",
+		params: FixedParams(1000003),
+		chunks: 26,
+		digest: "ad2cbbeee96659bf0fd4209fa56d8435fb3bdbb8d4cafba7aee4e7000568e346",
+	},
+}
+
+// selfTestData returns n bytes of deterministic pseudo-random data, from
+// the splitmix64 generator so it is the same on every platform.
+func selfTestData(n int) []byte {
+	r, _ := NewSynthReader(SynthOptions{Size: int64(n), Seed: 0x6363_6875_6e6b_6572})
+	data := make([]byte, n)
+	io.ReadFull(r, data)
+	return data
+}
+
+// SelfTest chunks deterministic pseudo-random data with each algorithm and
+// compares the boundaries and cut fingerprints found with known good
+// values, writing an "ok NAME" or "FAIL NAME ..." line per case to report.
+// A build failing the self test cuts different chunks, which won't
+// deduplicate against data chunked by other builds. The error is only for
+// failing to chunk or write the report.
+func SelfTest(report io.Writer) (failed int, err error) {
+	data := selfTestData(selfTestSize)
+	for _, tc := range selfTestCases {
+		chunks, digest, err := selfTestBoundaries(tc.params, data)
+		if err != nil {
+			return failed, fmt.Errorf("self test %s: %s", tc.name, err)
+		}
+		var line string
+		if chunks == tc.chunks && digest == tc.digest {
+			line = fmt.Sprintf("ok %s %d chunks\n", tc.name, chunks)
+		} else {
+			failed += 1
+			line = fmt.Sprintf("FAIL %s got %d chunks with digest %s, expected %d chunks with digest %s\n",
+				tc.name, chunks, digest, tc.chunks, tc.digest)
+		}
+		_, err = io.WriteString(report, line)
+		if err != nil {
+			return failed, fmt.Errorf("error writing self test report: %s", err)
+		}
+	}
+	return failed, nil
+}
+
+// selfTestBoundaries chunks data with params, returning the number of
+// chunks and the digest of their boundaries.
+func selfTestBoundaries(params Params, data []byte) (int, string, error) {
+	err := params.Validate()
+	if err != nil {
+		return 0, "", err
+	}
+	buf := getChunkBuffer(params.MaxSize)
+	defer putChunkBuffer(buf)
+
+	h := sha256.New()
+	splitter := params.newChunker(bytes.NewReader(data))
+	chunks := 0
+	for {
+		c, err := splitter.Next(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		fmt.Fprintf(h, "%d %d %x\n", c.Start, c.Length, c.Cut)
+		chunks += 1
+	}
+	return chunks, hex.EncodeToString(h.Sum(nil)), nil
+}
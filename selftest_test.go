@@ -0,0 +1,17 @@
+package cchunker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	var report strings.Builder
+	failed, err := SelfTest(&report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed != 0 {
+		t.Fatalf("%d self test cases failed:\n%s", failed, report.String())
+	}
+}
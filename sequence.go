@@ -0,0 +1,41 @@
+package cchunker
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+)
+
+// SequenceID returns the hex sequence id of the chunk at index in the
+// stream named streamID, a sha256 digest of the stream id, the index and
+// the sha256 digest of the chunk data.
+//
+// Unlike the chunk hash, the id differs for identical chunks at different
+// positions, and unlike the index, it differs between streams and when the
+// data at a position changes, so it can be used as an idempotency key for
+// uploading the chunk: a run retried after a crash sends the same ids for
+// the chunks it sent before.
+func SequenceID(streamID string, index int64, data []byte) string {
+	dataSum := sha256.Sum256(data)
+	h := sha256.New()
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(len(streamID)))
+	h.Write(buf[:])
+	io.WriteString(h, streamID)
+	binary.BigEndian.PutUint64(buf[:], uint64(index))
+	h.Write(buf[:])
+	h.Write(dataSum[:])
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SequenceIDProcessor returns a Processor setting the SequenceID of each
+// chunk in the stream named streamID before running p. The id is of the
+// data as it is in the stream, so p should wrap any processors
+// transforming the data, such as CompressProcessor.
+func SequenceIDProcessor(streamID string, p Processor) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		c.SequenceID = SequenceID(streamID, c.Index, c.Data)
+		return p.Process(c, out)
+	})
+}
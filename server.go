@@ -0,0 +1,210 @@
+package cchunker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// Boundary describes a chunk found by a Server.
+type Boundary struct {
+	Index  int64  `json:"index"`
+	Offset uint64 `json:"offset"`
+	Length int    `json:"length"`
+	Cut    uint64 `json:"cut"`
+	// ID is the chunk id in the server store, if it has one.
+	ID string `json:"id,omitempty"`
+}
+
+// serverRecord is a single response line from a Server.
+type serverRecord struct {
+	*Boundary
+	End    bool   `json:"end,omitempty"`
+	Chunks int64  `json:"chunks,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Server chunks streams sent by clients, reporting the chunk boundaries back.
+//
+// A client sends one or more streams over a connection, each stream is sent
+// as frames of a big endian uint64 length followed by that many bytes of data,
+// a zero length frame ends the stream. For each chunk of the stream, the
+// server replies with a line of JSON {"index":..,"offset":..,"length":..,"cut":..},
+// after the stream ends it replies {"end":true,"chunks":N}. If the stream
+// can not be chunked, it replies {"error":"..."} and closes the connection.
+//
+// Server is also an http.Handler, a POST to /chunk has its request body
+// chunked and the response is a JSON manifest {"chunks":[...]} of the same
+// chunk records.
+//
+// If Store is set, the data of every chunk is put in the store and the
+// records include the chunk id.
+type Server struct {
+	Params  Params
+	Store   *Store
+	Metrics *Metrics
+}
+
+// Serve accepts connections on l, serving each one concurrently.
+func (s *Server) Serve(l net.Listener) error {
+	err := s.Params.Validate()
+	if err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			s.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn serves the streams sent over a single connection until the
+// client closes it.
+func (s *Server) ServeConn(conn io.ReadWriter) error {
+	in := bufio.NewReader(conn)
+	out := bufio.NewWriter(conn)
+	enc := json.NewEncoder(out)
+
+	for {
+		// Wait for the first frame of a stream, so a client closing the
+		// connection between streams is not an error.
+		_, err := in.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		stream := &frameReader{r: in}
+		nChunks, err := s.chunkStream(stream, func(b Boundary) error {
+			return enc.Encode(serverRecord{Boundary: &b})
+		})
+		if err != nil {
+			enc.Encode(serverRecord{Error: err.Error()})
+			out.Flush()
+			return err
+		}
+		err = enc.Encode(serverRecord{End: true, Chunks: nChunks})
+		if err == nil {
+			err = out.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ServeHTTP chunks the body of a POST to /chunk.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/chunk" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	manifest := struct {
+		Chunks []Boundary `json:"chunks"`
+	}{
+		Chunks: []Boundary{},
+	}
+	_, err := s.chunkStream(r.Body, func(b Boundary) error {
+		manifest.Chunks = append(manifest.Chunks, b)
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+func (s *Server) chunkStream(r io.Reader, emit func(b Boundary) error) (int64, error) {
+	buf := getChunkBuffer(s.Params.MaxSize)
+	defer putChunkBuffer(buf)
+
+	cchunker := s.Params.newChunker(r)
+	nChunks := int64(0)
+	for {
+		chunk, err := cchunker.Next(buf)
+		if err == io.EOF {
+			return nChunks, nil
+		}
+		if err != nil {
+			return nChunks, fmt.Errorf("error getting next data chunk: %s", err)
+		}
+		b := Boundary{
+			Index:  nChunks,
+			Offset: uint64(chunk.Start),
+			Length: int(chunk.Length),
+			Cut:    chunk.Cut,
+		}
+		if s.Store != nil {
+			b.ID, err = s.Store.Put(chunk.Data)
+			if err != nil {
+				return nChunks, err
+			}
+		}
+		if s.Metrics != nil {
+			s.Metrics.ObserveChunk(b.Length)
+		}
+		err = emit(b)
+		if err != nil {
+			return nChunks, err
+		}
+		nChunks += 1
+	}
+}
+
+// frameReader reads the data of length prefixed frames until a zero
+// length frame.
+type frameReader struct {
+	r         io.Reader
+	remaining uint64
+	done      bool
+}
+
+func (f *frameReader) Read(p []byte) (int, error) {
+	for f.remaining == 0 {
+		if f.done {
+			return 0, io.EOF
+		}
+		var hdr [8]byte
+		_, err := io.ReadFull(f.r, hdr[:])
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, fmt.Errorf("error reading stream frame: %w", err)
+		}
+		f.remaining = binary.BigEndian.Uint64(hdr[:])
+		if f.remaining == 0 {
+			f.done = true
+		}
+	}
+	if uint64(len(p)) > f.remaining {
+		p = p[:f.remaining]
+	}
+	n, err := f.r.Read(p)
+	f.remaining -= uint64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
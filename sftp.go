@@ -0,0 +1,181 @@
+package cchunker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	backends["sftp"] = openSFTPBackend
+}
+
+// sftpBackend stores chunks on a remote host in the same ab/cd/abcd...
+// layout as Store, all requests share a single ssh connection.
+type sftpBackend struct {
+	conn   *ssh.Client
+	client *sftp.Client
+	dir    string
+}
+
+// openSFTPBackend opens sftp://user@host[:port]/path, authenticating with a
+// password in the url, ssh-agent or the default keys in ~/.ssh, and
+// checking the host key against ~/.ssh/known_hosts.
+func openSFTPBackend(u *url.URL) (Backend, error) {
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("sftp backend url %q has no host", u.String())
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("sftp backend url %q has no path", u.String())
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("unable to find ssh configuration: %s", err)
+	}
+
+	username := u.User.Username()
+	if username == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("unable to determine ssh user: %s", err)
+		}
+		username = current.Username
+	}
+
+	var auth []ssh.AuthMethod
+	if password, ok := u.User.Password(); ok {
+		auth = append(auth, ssh.Password(password))
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		agentConn, err := net.Dial("unix", sock)
+		if err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+		}
+	}
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		key, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		// Keys protected by a passphrase are only usable through ssh-agent.
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) != 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+
+	hostKeyCallback, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("error loading ssh known hosts: %s", err)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(u.Hostname(), port), &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %s", u.Host, err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error starting sftp session: %s", err)
+	}
+
+	return &sftpBackend{
+		conn:   conn,
+		client: client,
+		dir:    u.Path,
+	}, nil
+}
+
+func (b *sftpBackend) path(id string) string {
+	return path.Join(b.dir, shardedName(id))
+}
+
+func (b *sftpBackend) Has(id string) (bool, error) {
+	_, err := b.client.Stat(b.path(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *sftpBackend) Put(id string, data []byte) error {
+	p := b.path(id)
+	err := b.client.MkdirAll(path.Dir(p))
+	if err != nil {
+		return err
+	}
+
+	// As with Store, write to a temporary file first so an interrupted
+	// upload never leaves a partial chunk under its final name.
+	tmp := path.Join(path.Dir(p), ".tmp-"+id+"-"+strconv.FormatUint(rand.Uint64(), 36))
+	f, err := b.client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, bytes.NewReader(data))
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = b.client.PosixRename(tmp, p)
+	}
+	if err != nil {
+		b.client.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (b *sftpBackend) Get(id string) ([]byte, error) {
+	f, err := b.client.Open(b.path(id))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	_, err = f.WriteTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (b *sftpBackend) Close() error {
+	err := b.client.Close()
+	if err2 := b.conn.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package cchunker
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// signaturePrefix starts the line appended to a signed manifest, manifest
+// readers such as Store.Cat skip lines starting with '#'.
+const signaturePrefix = "# ed25519 "
+
+// signatureContext separates manifest signatures from other uses of a key.
+const signatureContext = "cchunker manifest"
+
+// ReadSignKeyFile reads an ed25519 private key stored as the hex encoded
+// 32 byte seed, as written by cchunker gen-sign-key.
+func ReadSignKeyFile(path string) (ed25519.PrivateKey, error) {
+	seed, err := ReadKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ManifestSigner passes a manifest through to an underlying writer, hashing
+// it so a signature line can be appended once it is complete.
+type ManifestSigner struct {
+	w   io.Writer
+	key ed25519.PrivateKey
+	h   hash.Hash
+}
+
+// NewManifestSigner returns a ManifestSigner writing to w.
+func NewManifestSigner(w io.Writer, key ed25519.PrivateKey) *ManifestSigner {
+	return &ManifestSigner{
+		w:   w,
+		key: key,
+		h:   sha512.New(),
+	}
+}
+
+// Write writes p to the underlying writer.
+func (s *ManifestSigner) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.h.Write(p[:n])
+	return n, err
+}
+
+// Sign appends a line with the public key and an ed25519ph signature of
+// everything written so far.
+func (s *ManifestSigner) Sign() error {
+	sig, err := s.key.Sign(nil, s.h.Sum(nil), &ed25519.Options{
+		Hash:    crypto.SHA512,
+		Context: signatureContext,
+	})
+	if err != nil {
+		return fmt.Errorf("error signing manifest: %s", err)
+	}
+	pub := s.key.Public().(ed25519.PublicKey)
+	_, err = fmt.Fprintf(s.w, "%s%x %x\n", signaturePrefix, pub, sig)
+	if err != nil {
+		return fmt.Errorf("error writing manifest signature: %s", err)
+	}
+	return nil
+}
+
+// VerifyManifest checks the last line of the manifest in r is a valid
+// signature of the rest of it by pub.
+func VerifyManifest(r io.Reader, pub ed25519.PublicKey) error {
+	br := bufio.NewReader(r)
+	h := sha512.New()
+	var last []byte
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) != 0 {
+			h.Write(last)
+			last = line
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading manifest: %s", err)
+		}
+	}
+
+	sigLine := strings.TrimSuffix(string(last), "\n")
+	if !strings.HasPrefix(sigLine, signaturePrefix) {
+		return errors.New("manifest is not signed")
+	}
+	fields := strings.Fields(strings.TrimPrefix(sigLine, signaturePrefix))
+	if len(fields) != 2 {
+		return errors.New("manifest signature line is malformed")
+	}
+	linePub, err := hex.DecodeString(fields[0])
+	if err != nil {
+		return errors.New("manifest signature line is malformed")
+	}
+	if !bytes.Equal(linePub, pub) {
+		return fmt.Errorf("manifest is signed by %s, not the expected key", fields[0])
+	}
+	sig, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return errors.New("manifest signature line is malformed")
+	}
+	err = ed25519.VerifyWithOptions(pub, h.Sum(nil), sig, &ed25519.Options{
+		Hash:    crypto.SHA512,
+		Context: signatureContext,
+	})
+	if err != nil {
+		return errors.New("manifest signature is invalid")
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package cchunker
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var sizeSuffixes = []struct {
+	suffix string
+	scale  uint64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseSize parses a human readable byte size such as 4096, 256KiB or 8MiB.
+func ParseSize(v string) (uint64, error) {
+	s := strings.TrimSpace(v)
+	scale := uint64(1)
+	for _, suffix := range sizeSuffixes {
+		if strings.HasSuffix(s, suffix.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, suffix.suffix))
+			scale = suffix.scale
+			break
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	if n > math.MaxUint64/scale {
+		return 0, fmt.Errorf("size %q is too large", v)
+	}
+	return n * scale, nil
+}
+
+// FormatSize formats a byte size using the largest binary unit
+// that represents it exactly.
+func FormatSize(n uint64) string {
+	for _, suffix := range []string{"TiB", "GiB", "MiB", "KiB"} {
+		for _, s := range sizeSuffixes {
+			if s.suffix == suffix && n != 0 && n%s.scale == 0 {
+				return strconv.FormatUint(n/s.scale, 10) + suffix
+			}
+		}
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+// Size is a byte size flag.Value accepting human readable sizes.
+type Size uint64
+
+func (s *Size) String() string {
+	return FormatSize(uint64(*s))
+}
+
+// Set parses a human readable size.
+func (s *Size) Set(v string) error {
+	n, err := ParseSize(v)
+	if err != nil {
+		return err
+	}
+	*s = Size(n)
+	return nil
+}
+
+// AverageBitsForSize returns the average bits mask giving chunks closest to
+// avg bytes on average.
+func AverageBitsForSize(avg uint64) int {
+	if avg <= 1 {
+		return 0
+	}
+	return int(math.Round(math.Log2(float64(avg))))
+}
@@ -0,0 +1,95 @@
+package cchunker
+
+import (
+	"io"
+	"os"
+
+	"github.com/restic/chunker"
+)
+
+// fileRegion is a range of a file that is either data or a hole.
+type fileRegion struct {
+	start, length int64
+	hole          bool
+}
+
+// sparseSplitter chunks the data regions of a sparse file, each with a
+// fresh splitter, and returns the holes between them as zero chunks of at
+// most MaxSize bytes without reading them.
+type sparseSplitter struct {
+	f        *os.File
+	params   Params
+	base     int64
+	regions  []fileRegion
+	splitter splitter
+	pos      int64
+}
+
+// newSparseSplitter returns a splitter skipping the holes of f from its
+// current position, or nil if f isn't a regular file with holes.
+func newSparseSplitter(f *os.File, p Params) *sparseSplitter {
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	base, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	regions, err := fileRegions(f, base, info.Size())
+	if err != nil || len(regions) < 2 {
+		return nil
+	}
+	p.SkipHoles = false
+	return &sparseSplitter{f: f, params: p, base: base, regions: regions}
+}
+
+func (s *sparseSplitter) Next(buf []byte) (chunker.Chunk, error) {
+	for len(s.regions) != 0 {
+		region := &s.regions[0]
+		if region.hole {
+			if region.length == 0 {
+				s.regions = s.regions[1:]
+				continue
+			}
+			n := int64(s.params.MaxSize)
+			if n > region.length {
+				n = region.length
+			}
+			if int64(cap(buf)) < n {
+				buf = make([]byte, n)
+			}
+			data := buf[:n]
+			clear(data)
+			chunk := chunker.Chunk{
+				Start:  uint(region.start - s.base),
+				Length: uint(n),
+				Data:   data,
+			}
+			region.start += n
+			region.length -= n
+			return chunk, nil
+		}
+
+		if s.splitter == nil {
+			s.splitter = s.params.newChunker(io.NewSectionReader(s.f, region.start, region.length))
+		}
+		chunk, err := s.splitter.Next(buf)
+		if err == io.EOF {
+			s.splitter = nil
+			s.regions = s.regions[1:]
+			continue
+		}
+		if err != nil {
+			return chunk, err
+		}
+		chunk.Start += uint(region.start - s.base)
+		return chunk, nil
+	}
+	// Leave f at its end, as if it had been read.
+	_, err := s.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return chunker.Chunk{}, err
+	}
+	return chunker.Chunk{}, io.EOF
+}
@@ -0,0 +1,42 @@
+//go:build linux || darwin || freebsd
+
+package cchunker
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileRegions returns the data and hole regions of f between start and
+// size, found with SEEK_DATA and SEEK_HOLE.
+func fileRegions(f *os.File, start, size int64) ([]fileRegion, error) {
+	var regions []fileRegion
+	pos := start
+	for pos < size {
+		data, err := unix.Seek(int(f.Fd()), pos, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// The rest of the file is a hole.
+			data = size
+		} else if err != nil {
+			return nil, err
+		}
+		if data > pos {
+			regions = append(regions, fileRegion{start: pos, length: data - pos, hole: true})
+		}
+		if data >= size {
+			break
+		}
+		hole, err := unix.Seek(int(f.Fd()), data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		if hole > size {
+			hole = size
+		}
+		regions = append(regions, fileRegion{start: data, length: hole - data})
+		pos = hole
+	}
+	return regions, nil
+}
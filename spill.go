@@ -0,0 +1,70 @@
+package cchunker
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// spillBuffer holds data in memory until it grows past threshold bytes, then
+// moves it to a temporary file in dir. A threshold of zero never spills.
+type spillBuffer struct {
+	threshold int64
+	dir       string
+
+	mem bytes.Buffer
+	f   *os.File
+	w   *bufio.Writer
+}
+
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.f == nil && b.threshold > 0 && int64(b.mem.Len()+len(p)) > b.threshold {
+		f, err := os.CreateTemp(b.dir, "cchunker-summary-*")
+		if err != nil {
+			return 0, fmt.Errorf("error creating summary spill file: %s", err)
+		}
+		b.f = f
+		b.w = bufio.NewWriter(f)
+		_, err = b.w.Write(b.mem.Bytes())
+		if err != nil {
+			return 0, err
+		}
+		b.mem = bytes.Buffer{}
+	}
+	if b.w != nil {
+		return b.w.Write(p)
+	}
+	return b.mem.Write(p)
+}
+
+// Reader returns a reader over everything written so far,
+// the buffer must not be written to afterwards.
+func (b *spillBuffer) Reader() (io.Reader, error) {
+	if b.f == nil {
+		return bytes.NewReader(b.mem.Bytes()), nil
+	}
+	err := b.w.Flush()
+	if err != nil {
+		return nil, fmt.Errorf("error flushing summary spill file: %s", err)
+	}
+	_, err = b.f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("error rewinding summary spill file: %s", err)
+	}
+	return b.f, nil
+}
+
+// Close removes any spill file.
+func (b *spillBuffer) Close() error {
+	if b.f == nil {
+		return nil
+	}
+	err := b.f.Close()
+	if err2 := os.Remove(b.f.Name()); err == nil {
+		err = err2
+	}
+	b.f = nil
+	return err
+}
@@ -0,0 +1,105 @@
+package cchunker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SplitProcessor returns a Processor that writes each chunk to its own file
+// in dir, named by formatting the chunk index with pattern, e.g. chunk-%08d,
+// a content defined split(1). Nothing is written to the output.
+func SplitProcessor(dir, pattern string) (Processor, error) {
+	err := checkSplitPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		path := filepath.Join(dir, fmt.Sprintf(pattern, c.Index))
+		err := os.WriteFile(path, c.Data, 0o644)
+		if err != nil {
+			return fmt.Errorf("error writing chunk file: %s", err)
+		}
+		return nil
+	}), nil
+}
+
+// SplitLinkProcessor returns a Processor like SplitProcessor, except each
+// chunk file is a hard link to a read only copy of the chunk in linkDir,
+// named ab/cd/abcd... by its sha256 digest. Chunks already in linkDir,
+// from this or an earlier run, are linked without writing them again, so
+// repeated chunks only take their space once. linkDir must be on the same
+// filesystem as dir.
+func SplitLinkProcessor(dir, pattern, linkDir string) (Processor, error) {
+	err := checkSplitPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		sum := sha256.Sum256(c.Data)
+		object := filepath.Join(linkDir, shardedName(hex.EncodeToString(sum[:])))
+		_, err := os.Stat(object)
+		if os.IsNotExist(err) {
+			err = writeLinkObject(object, c.Data)
+		}
+		if err != nil {
+			return fmt.Errorf("error writing chunk file: %s", err)
+		}
+
+		// A file left by an earlier run into dir is replaced.
+		path := filepath.Join(dir, fmt.Sprintf(pattern, c.Index))
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error replacing chunk file: %s", err)
+		}
+		err = os.Link(object, path)
+		if err != nil {
+			return fmt.Errorf("error linking chunk file: %s", err)
+		}
+		return nil
+	}), nil
+}
+
+// writeLinkObject atomically writes data as the read only file path,
+// creating its directory, so a concurrent or interrupted write never
+// leaves a partial chunk to be linked.
+func writeLinkObject(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0o444)
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// checkSplitPattern returns an error if pattern can't name chunk files.
+func checkSplitPattern(pattern string) error {
+	name := fmt.Sprintf(pattern, 0)
+	if strings.Contains(name, "%!") {
+		return fmt.Errorf("split pattern %q must format the chunk number once, e.g. chunk-%%08d", pattern)
+	}
+	if strings.ContainsRune(name, filepath.Separator) {
+		return fmt.Errorf("split pattern %q must not contain a path separator", pattern)
+	}
+	return nil
+}
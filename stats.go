@@ -0,0 +1,77 @@
+package cchunker
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// RunStats summarises a run.
+type RunStats struct {
+	Bytes        uint64 `json:"bytes"`
+	Chunks       int64  `json:"chunks"`
+	MinChunkSize int    `json:"min_chunk_size"`
+	AvgChunkSize int    `json:"avg_chunk_size"`
+	MaxChunkSize int    `json:"max_chunk_size"`
+	// ProcessorSeconds is the total wall time spent in the processor,
+	// summed across concurrent jobs.
+	ProcessorSeconds float64 `json:"processor_seconds"`
+	// WallSeconds is the time since the StatsRecorder was created.
+	WallSeconds float64 `json:"wall_seconds"`
+	// Failures is the number of chunks the processor failed on.
+	Failures int64 `json:"failures"`
+}
+
+// StatsRecorder collects RunStats from the chunks passing through a
+// Processor.
+type StatsRecorder struct {
+	mu            sync.Mutex
+	start         time.Time
+	stats         RunStats
+	processorTime time.Duration
+}
+
+// NewStatsRecorder returns a StatsRecorder, the run wall time is measured
+// from when it is created.
+func NewStatsRecorder() *StatsRecorder {
+	return &StatsRecorder{start: time.Now()}
+}
+
+// Processor returns a Processor that runs p, recording each chunk.
+func (s *StatsRecorder) Processor(p Processor) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		start := time.Now()
+		err := p.Process(c, out)
+		elapsed := time.Since(start)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		size := len(c.Data)
+		if s.stats.Chunks == 0 || size < s.stats.MinChunkSize {
+			s.stats.MinChunkSize = size
+		}
+		if size > s.stats.MaxChunkSize {
+			s.stats.MaxChunkSize = size
+		}
+		s.stats.Chunks += 1
+		s.stats.Bytes += uint64(size)
+		s.processorTime += elapsed
+		if err != nil {
+			s.stats.Failures += 1
+		}
+		return err
+	})
+}
+
+// Stats returns the stats of the chunks recorded so far.
+func (s *StatsRecorder) Stats() RunStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	if stats.Chunks != 0 {
+		stats.AvgChunkSize = int(stats.Bytes / uint64(stats.Chunks))
+	}
+	stats.ProcessorSeconds = s.processorTime.Seconds()
+	stats.WallSeconds = time.Since(s.start).Seconds()
+	return stats
+}
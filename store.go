@@ -0,0 +1,530 @@
+package cchunker
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is a content addressed directory of chunks, each chunk is stored at
+// ab/cd/abcd... relative to Dir, named by the hex digest of its contents.
+type Store struct {
+	Dir string
+	// Hash is one of HashNames.
+	Hash string
+	// Sync flushes each new chunk and its directory to disk before Put
+	// returns, so chunks survive a crash once they are in a manifest.
+	Sync bool
+	// Key decrypts chunks encrypted by EncryptProcessor in Cat.
+	Key []byte
+	// PackThreshold, if not zero, appends chunks smaller than it to pack
+	// files in the packs directory instead of writing a file per chunk,
+	// so tiny chunks such as those of summaries don't make millions of
+	// files. Packed chunks are always read, whatever the threshold. A
+	// store packing chunks must be closed when done.
+	PackThreshold int
+	// CheckStreamHash makes Cat check the data of each section against
+	// its stream hash trailers, with the hash each trailer names, see
+	// WriteStreamHash. Sections without a trailer are not checked.
+	CheckStreamHash bool
+
+	packs packs
+}
+
+// Path returns the path of the chunk with the given hex digest.
+func (s *Store) Path(id string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(shardedName(id)))
+}
+
+// ID returns the hex digest of data.
+func (s *Store) ID(data []byte) (string, error) {
+	h, err := NewHash(s.Hash)
+	if err != nil {
+		return "", err
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Put adds data to the store if it is not already present,
+// returning its id. A chunk already present has its modification time, or
+// that of its pack, refreshed, so GC keeps it for another grace period.
+func (s *Store) Put(data []byte) (string, error) {
+	id, err := s.ID(data)
+	if err != nil {
+		return "", err
+	}
+
+	p := s.Path(id)
+	err = touch(p)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("error checking for chunk %s: %s", id, err)
+	}
+
+	if len(data) < s.PackThreshold {
+		err = s.putPacked(id, data)
+	} else {
+		err = s.put(id, data)
+	}
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// has reports if the chunk id is in the store, in its own file or packed.
+// Callers are about to reference a chunk found, so like Put it refreshes
+// the modification time of the chunk or its pack.
+func (s *Store) has(id string) (bool, error) {
+	err := touch(s.Path(id))
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	loc, packed, err := s.lookupPacked(id)
+	if err != nil || !packed {
+		return false, err
+	}
+	err = touch(loc.pack)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// touch sets the modification time of the file at path to now, GC only
+// removes files not modified within its grace period.
+func touch(path string) error {
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
+
+// put writes data as the chunk with the given id, replacing any existing chunk.
+func (s *Store) put(id string, data []byte) error {
+	p := s.Path(id)
+	dir := filepath.Dir(p)
+	_, err := os.Stat(dir)
+	created := errors.Is(err, os.ErrNotExist)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating chunk directory: %s", err)
+	}
+
+	// Write to a temporary file first so an interrupted write never
+	// leaves a partial chunk under its final name.
+	f, err := os.CreateTemp(dir, ".tmp-"+id+"-*")
+	if err != nil {
+		return fmt.Errorf("error creating chunk %s: %s", id, err)
+	}
+	_, err = f.Write(data)
+	if err == nil && s.Sync {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("error writing chunk %s: %s", id, err)
+	}
+
+	if s.Sync {
+		// The rename, and any shard directories it needed, are only
+		// durable once the directories containing them are synced.
+		dirs := []string{dir}
+		if created {
+			dirs = append(dirs, filepath.Dir(dir), filepath.Dir(filepath.Dir(dir)))
+		}
+		for _, d := range dirs {
+			err = syncDir(d)
+			if err != nil {
+				return fmt.Errorf("error syncing chunk %s: %s", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// read reads the chunk with the given id from its own file or a pack file,
+// without verifying it. The error wraps os.ErrNotExist if it is in neither.
+func (s *Store) read(id string) ([]byte, error) {
+	data, err := os.ReadFile(s.Path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return s.readPacked(id)
+	}
+	return data, err
+}
+
+// Get reads the chunk with the given id, verifying its contents.
+func (s *Store) Get(id string) ([]byte, error) {
+	data, err := s.read(id)
+	if err != nil {
+		return nil, fmt.Errorf("error reading chunk %s: %s", id, err)
+	}
+	actual, err := s.ID(data)
+	if err != nil {
+		return nil, err
+	}
+	if actual != id {
+		return nil, fmt.Errorf("chunk %s is corrupt, contents hash to %s", id, actual)
+	}
+	return data, nil
+}
+
+// Processor returns a Processor that puts each chunk in the store,
+// writing a "hash size offset" manifest line per chunk.
+func (s *Store) Processor() Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		id, err := s.Put(c.Data)
+		if err != nil {
+			return err
+		}
+		err = writeManifestLine(out, id, c)
+		if err != nil {
+			return fmt.Errorf("error writing manifest line: %s", err)
+		}
+		return nil
+	})
+}
+
+// Cat writes the original data described by a manifest of "hash size offset"
+// lines to out, verifying each chunk as it goes. Lines with a fourth raw size
+// field are chunks transformed by CompressProcessor or EncryptProcessor,
+// which are decrypted with Key if it is set and decompressed. Lines starting
+// with '#', such as manifest signatures, are skipped, except stream hash
+// trailers when CheckStreamHash is set, which fail Cat if the data written
+// since the start of their section or the trailer before doesn't match. The sections of a manifest of several files
+// are written one after the other.
+func (s *Store) Cat(manifest io.Reader, out io.Writer) error {
+	return s.cat(manifest, "", out)
+}
+
+// CatFile is like Cat, but only writes the data of the input file path
+// from a manifest of several files.
+func (s *Store) CatFile(manifest io.Reader, path string, out io.Writer) error {
+	return s.cat(manifest, path, out)
+}
+
+// cat writes the data of the section of file in manifest, or of every
+// section if file is empty.
+func (s *Store) cat(manifest io.Reader, file string, out io.Writer) error {
+	var stream streamCheck
+	if s.CheckStreamHash {
+		stream = newStreamCheck()
+	}
+	scanner := bufio.NewScanner(manifest)
+	offset := uint64(0)
+	started := false
+	inFile := file == ""
+	found := false
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if path, ok := parseFileHeader(scanner.Text()); ok {
+			offset = 0
+			started = false
+			if file != "" {
+				inFile = path == file
+				found = found || inFile
+			}
+			if stream != nil {
+				stream.reset()
+			}
+			continue
+		}
+		if name, sum, ok := parseStreamHash(scanner.Text()); ok && inFile && stream != nil {
+			err := stream.check(name, sum, lineNo)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || !inFile {
+			continue
+		}
+
+		c, err := parseManifestLine(fields, lineNo)
+		if err != nil {
+			return err
+		}
+		if len(fields) >= 3 && !started {
+			offset = c.Offset
+		}
+		started = true
+		if len(fields) >= 3 && c.Offset != offset {
+			return fmt.Errorf("manifest line %d is at offset %d, expected %d", lineNo, c.Offset, offset)
+		}
+		data, err := s.chunkData(c, len(fields) >= 2)
+		if err != nil {
+			return err
+		}
+
+		_, err = out.Write(data)
+		if err != nil {
+			return fmt.Errorf("error writing chunk data: %s", err)
+		}
+		if stream != nil {
+			stream.Write(data)
+		}
+		offset += uint64(len(data))
+	}
+	err := scanner.Err()
+	if err != nil {
+		return fmt.Errorf("error reading manifest: %s", err)
+	}
+	if file != "" && !found {
+		return fmt.Errorf("file %q is not in the manifest", file)
+	}
+	return nil
+}
+
+// ManifestChunk is a chunk listed by a "hash size offset" manifest line.
+type ManifestChunk struct {
+	ID     string
+	Size   uint64
+	Offset uint64
+	// RawSize is the size of the original data of a transformed chunk,
+	// zero if the chunk is stored as is.
+	RawSize uint64
+}
+
+// Length is the size of the original data of the chunk.
+func (c ManifestChunk) Length() uint64 {
+	if c.RawSize != 0 {
+		return c.RawSize
+	}
+	return c.Size
+}
+
+// ManifestSection is the chunks of one input file of a manifest.
+type ManifestSection struct {
+	// Path is the input file of the section, empty for a manifest of
+	// a single input without file headers.
+	Path   string
+	Chunks []ManifestChunk
+	// Offset is the offset of the first chunk in its input, non zero
+	// for a manifest of a range made with -offset.
+	Offset uint64
+	// Size is the size of the data of the section, from Offset.
+	Size uint64
+}
+
+// ReadManifest reads all the sections of a manifest, so their data can be
+// read at any offset with Store.OpenSection. A section starts at the
+// offset of its first chunk, so manifests of ranges can be read.
+func ReadManifest(manifest io.Reader) ([]ManifestSection, error) {
+	scanner := bufio.NewScanner(manifest)
+	var sections []ManifestSection
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if path, ok := parseFileHeader(scanner.Text()); ok {
+			sections = append(sections, ManifestSection{Path: path})
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(sections) == 0 {
+			sections = append(sections, ManifestSection{})
+		}
+		section := &sections[len(sections)-1]
+
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("manifest line %d has no chunk size", lineNo)
+		}
+		c, err := parseManifestLine(fields, lineNo)
+		if err != nil {
+			return nil, err
+		}
+		if len(section.Chunks) == 0 && len(fields) >= 3 {
+			section.Offset = c.Offset
+		}
+		if len(fields) < 3 {
+			c.Offset = section.Offset + section.Size
+		}
+		if c.Offset != section.Offset+section.Size {
+			return nil, fmt.Errorf("manifest line %d is at offset %d, expected %d", lineNo, c.Offset, section.Offset+section.Size)
+		}
+		section.Chunks = append(section.Chunks, c)
+		section.Size += c.Length()
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %s", err)
+	}
+	return sections, nil
+}
+
+// parseManifestLine parses the fields of a manifest line, those missing
+// from the line are left zero.
+func parseManifestLine(fields []string, lineNo int) (ManifestChunk, error) {
+	c := ManifestChunk{ID: fields[0]}
+	var err error
+	if len(fields) >= 2 {
+		c.Size, err = strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return c, fmt.Errorf("manifest line %d has an invalid size: %s", lineNo, err)
+		}
+	}
+	if len(fields) >= 3 {
+		c.Offset, err = strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return c, fmt.Errorf("manifest line %d has an invalid offset: %s", lineNo, err)
+		}
+	}
+	if len(fields) >= 4 {
+		c.RawSize, err = strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			return c, fmt.Errorf("manifest line %d has an invalid raw size: %s", lineNo, err)
+		}
+	}
+	return c, nil
+}
+
+// chunkData reads and verifies chunk c, returning its original data.
+// Elided zero chunks are returned as zeros. Chunks with a raw size are decrypted with Key if it is set and
+// decompressed. The stored size is only checked if checkSize is set.
+func (s *Store) chunkData(c ManifestChunk, checkSize bool) ([]byte, error) {
+	if c.ID == ZeroChunkID {
+		if !checkSize {
+			return nil, fmt.Errorf("zero chunk has no size")
+		}
+		return make([]byte, c.Size), nil
+	}
+	data, err := s.Get(c.ID)
+	if err != nil {
+		return nil, err
+	}
+	if checkSize && c.Size != uint64(len(data)) {
+		return nil, fmt.Errorf("chunk %s has size %d, manifest expects %d", c.ID, len(data), c.Size)
+	}
+	if c.RawSize != 0 {
+		compressed := true
+		if s.Key != nil {
+			data, compressed, err = decryptChunk(s.Key, data)
+			if err != nil {
+				return nil, fmt.Errorf("chunk %s: %s", c.ID, err)
+			}
+		}
+		if compressed {
+			data, err = decompressChunk(data, c.RawSize)
+			if err != nil {
+				return nil, fmt.Errorf("chunk %s: %s", c.ID, err)
+			}
+		}
+		if uint64(len(data)) != c.RawSize {
+			return nil, fmt.Errorf("chunk %s has raw size %d, manifest expects %d", c.ID, len(data), c.RawSize)
+		}
+	}
+	return data, nil
+}
+
+// storeFileCacheSize is the number of recently read chunks a StoreFile
+// keeps, so small sequential reads don't fetch a chunk again each time.
+const storeFileCacheSize = 8
+
+// StoreFile reads the data of a manifest section at any offset, fetching
+// and verifying only the chunks covering each read. It is safe for
+// concurrent use.
+type StoreFile struct {
+	store   *Store
+	section ManifestSection
+
+	mu    sync.Mutex
+	cache []storeFileChunk
+}
+
+type storeFileChunk struct {
+	index int
+	data  []byte
+}
+
+// OpenSection returns a StoreFile reading the data of section, offsets
+// are from the start of the section rather than of its input.
+func (s *Store) OpenSection(section ManifestSection) *StoreFile {
+	return &StoreFile{store: s, section: section}
+}
+
+// Size returns the size of the data.
+func (f *StoreFile) Size() int64 {
+	return int64(f.section.Size)
+}
+
+// ReadAt implements io.ReaderAt.
+func (f *StoreFile) ReadAt(buf []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	chunks := f.section.Chunks
+	off += int64(f.section.Offset)
+	// The first chunk ending after off.
+	i := sort.Search(len(chunks), func(i int) bool {
+		return int64(chunks[i].Offset+chunks[i].Length()) > off
+	})
+	n := 0
+	for ; n < len(buf) && i < len(chunks); i += 1 {
+		data, err := f.chunk(i)
+		if err != nil {
+			return n, err
+		}
+		start := off + int64(n) - int64(chunks[i].Offset)
+		n += copy(buf[n:], data[start:])
+	}
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// chunk returns the data of the chunk at index i of the section.
+func (f *StoreFile) chunk(i int) ([]byte, error) {
+	f.mu.Lock()
+	for _, c := range f.cache {
+		if c.index == i {
+			f.mu.Unlock()
+			return c.data, nil
+		}
+	}
+	f.mu.Unlock()
+
+	data, err := f.store.chunkData(f.section.Chunks[i], true)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.cache) == storeFileCacheSize {
+		f.cache = f.cache[1:]
+	}
+	f.cache = append(f.cache, storeFileChunk{i, data})
+	return data, nil
+}
@@ -0,0 +1,143 @@
+package cchunker
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// testData returns n bytes of deterministic random data.
+func testData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+// storeRange stores data, which starts at offset in its stream, returning
+// its manifest, ended with a stream hash trailer by the hash name if it is
+// set.
+func storeRange(t *testing.T, s *Store, data []byte, offset uint64, name string) string {
+	t.Helper()
+	var out bytes.Buffer
+	p := Pipeline{
+		Params:    Params{Algorithm: "fixed", MaxSize: 1000},
+		Processor: s.Processor(),
+		Resume:    Checkpoint{Offset: offset},
+	}
+	if name != "" {
+		h, err := NewHash(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.StreamHash = h
+	}
+	_, err := p.Run(bytes.NewReader(data), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "" {
+		err = WriteStreamHash(&out, name, p.StreamHash.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out.String()
+}
+
+func TestStoreCatStreamHashRanges(t *testing.T) {
+	s := &Store{Dir: t.TempDir(), Hash: "sha256", CheckStreamHash: true}
+	data := testData(10000)
+	manifest := storeRange(t, s, data[:4000], 0, "sha256") +
+		storeRange(t, s, data[4000:], 4000, "blake3")
+
+	var out bytes.Buffer
+	err := s.Cat(strings.NewReader(manifest), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("restored data differs")
+	}
+}
+
+func TestStoreCatStreamHashMismatch(t *testing.T) {
+	s := &Store{Dir: t.TempDir(), Hash: "sha256", CheckStreamHash: true}
+	data := testData(10000)
+	manifest := storeRange(t, s, data, 0, "blake3")
+	tampered := strings.Replace(manifest, "# stream blake3 ", "# stream blake3 00", 1)
+	tampered = tampered[:len(tampered)-3] + "\n"
+
+	err := s.Cat(strings.NewReader(tampered), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "does not match the stream hash") {
+		t.Fatalf("expected a stream hash mismatch, got %v", err)
+	}
+
+	s.CheckStreamHash = false
+	err = s.Cat(strings.NewReader(tampered), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("unchecked cat failed: %s", err)
+	}
+}
+
+func TestStoreCatRange(t *testing.T) {
+	s := &Store{Dir: t.TempDir(), Hash: "sha256"}
+	data := testData(10000)
+	first := storeRange(t, s, data[:3000], 0, "")
+	second := storeRange(t, s, data[3000:7000], 3000, "")
+	third := storeRange(t, s, data[7000:], 7000, "")
+
+	var out bytes.Buffer
+	err := s.Cat(strings.NewReader(second), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data[3000:7000]) {
+		t.Fatal("restored range differs")
+	}
+
+	out.Reset()
+	err = s.Cat(strings.NewReader(second+third), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data[3000:]) {
+		t.Fatal("restored ranges differ")
+	}
+
+	err = s.Cat(strings.NewReader(first+third), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "expected 3000") {
+		t.Fatalf("expected a gap between ranges to fail, got %v", err)
+	}
+}
+
+func TestReadManifestRange(t *testing.T) {
+	s := &Store{Dir: t.TempDir(), Hash: "sha256"}
+	data := testData(10000)
+	manifest := storeRange(t, s, data[3000:7000], 3000, "")
+
+	sections, err := ReadManifest(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sections) != 1 || sections[0].Offset != 3000 || sections[0].Size != 4000 {
+		t.Fatalf("sections %+v, expected one of 4000 bytes at 3000", sections)
+	}
+
+	buf := make([]byte, 1500)
+	_, err = s.OpenSection(sections[0]).ReadAt(buf, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data[3500:5000]) {
+		t.Fatal("read range data differs")
+	}
+
+	res, err := s.Verify(strings.NewReader(manifest), &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.OK() || res.Chunks != 4 {
+		t.Fatalf("verify of a range manifest: %+v", res)
+	}
+}
@@ -0,0 +1,102 @@
+package cchunker
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// streamHashPrefix starts the trailer line of a manifest section holding
+// the digest of its whole input.
+const streamHashPrefix = "# stream "
+
+// WriteStreamHash writes the trailer ending a manifest section, recording
+// sum, the digest of the whole input of the section by the hash name, as a
+// "# stream NAME HEX" line. Store.Cat checks the data it restores against
+// it.
+func WriteStreamHash(out io.Writer, name string, sum []byte) error {
+	_, err := fmt.Fprintf(out, "%s%s %x\n", streamHashPrefix, name, sum)
+	if err != nil {
+		return fmt.Errorf("error writing stream hash: %s", err)
+	}
+	return nil
+}
+
+// WriteJSONStreamHash writes the trailer ending a section of a JSON lines
+// manifest, a {"stream_hash": "NAME:HEX"} object, see WriteStreamHash.
+func WriteJSONStreamHash(out io.Writer, name string, sum []byte) error {
+	line, err := json.Marshal(struct {
+		StreamHash string `json:"stream_hash"`
+	}{name + ":" + hex.EncodeToString(sum)})
+	if err == nil {
+		_, err = out.Write(append(line, '\n'))
+	}
+	if err != nil {
+		return fmt.Errorf("error writing stream hash: %s", err)
+	}
+	return nil
+}
+
+// parseStreamHash returns the hash name and digest of a stream hash
+// trailer line.
+func parseStreamHash(line string) (string, []byte, bool) {
+	if !strings.HasPrefix(line, streamHashPrefix) {
+		return "", nil, false
+	}
+	fields := strings.Fields(line[len(streamHashPrefix):])
+	if len(fields) != 2 {
+		return "", nil, false
+	}
+	sum, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return "", nil, false
+	}
+	return fields[0], sum, true
+}
+
+// streamCheck hashes the data restored for a manifest section with every
+// hash of HashNames, as the hash of the section's stream hash trailer is
+// only known once the trailer is read.
+type streamCheck map[string]hash.Hash
+
+func newStreamCheck() streamCheck {
+	c := make(streamCheck, len(HashNames))
+	for _, name := range HashNames {
+		h, err := NewHash(name)
+		if err != nil {
+			panic(err)
+		}
+		c[name] = h
+	}
+	return c
+}
+
+func (c streamCheck) Write(data []byte) {
+	for _, h := range c {
+		h.Write(data)
+	}
+}
+
+// check returns an error if the data written doesn't match the stream hash
+// trailer on manifest line lineNo, then starts hashing the next section.
+func (c streamCheck) check(name string, sum []byte, lineNo int) error {
+	h, ok := c[name]
+	if !ok {
+		return fmt.Errorf("manifest line %d has an unknown stream hash %q", lineNo, name)
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return fmt.Errorf("restored data does not match the stream hash of manifest line %d", lineNo)
+	}
+	c.reset()
+	return nil
+}
+
+func (c streamCheck) reset() {
+	for _, h := range c {
+		h.Reset()
+	}
+}
@@ -0,0 +1,168 @@
+package cchunker
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// synthMaxEdit bounds the length of each mutation of synthetic data,
+	// mutations average half of it.
+	synthMaxEdit  = 8 * kiB
+	splitmixGamma = 0x9e3779b97f4a7c15
+)
+
+// SynthOptions controls the data of NewSynthReader.
+type SynthOptions struct {
+	// Size is the number of bytes to generate.
+	Size int64
+	// Seed picks the pseudo-random data, the same seed always gives the
+	// same data.
+	Seed uint64
+	// Mutate is the fraction of the data, from 0 to 1, that is changed by
+	// localized overwrites, insertions and deletions of up to 8 KiB, so
+	// it can be compared to the data of the same seed without mutations.
+	Mutate float64
+	// MutateSeed picks the mutations, different seeds give different
+	// versions of the same data.
+	MutateSeed uint64
+}
+
+// splitmix64 returns the output of the splitmix64 generator for the given
+// state, which is advanced by splitmixGamma for each output.
+func splitmix64(state uint64) uint64 {
+	z := state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// synthReader generates the data of SynthOptions. The unmutated data is
+// little endian splitmix64 output, computed from the position so
+// deletions can skip it.
+type synthReader struct {
+	opts      SynthOptions
+	remaining int64
+	pos       int64
+	mutations uint64
+	// untilEdit is the number of unmutated bytes before the next
+	// mutation, insert and overwrite are the bytes left of the current
+	// mutation.
+	untilEdit int64
+	insert    int64
+	overwrite int64
+}
+
+// NewSynthReader returns a reader of reproducible pseudo-random data with
+// optional localized mutations, for benchmarks and tests of deduplication
+// that don't need large fixture files.
+func NewSynthReader(opts SynthOptions) (io.Reader, error) {
+	if opts.Size < 0 {
+		return nil, fmt.Errorf("synthetic data size %d is negative", opts.Size)
+	}
+	if opts.Mutate < 0 || opts.Mutate > 1 || math.IsNaN(opts.Mutate) {
+		return nil, fmt.Errorf("mutated fraction %g is not between 0 and 1", opts.Mutate)
+	}
+	r := &synthReader{
+		opts:      opts,
+		remaining: opts.Size,
+		mutations: opts.MutateSeed,
+	}
+	r.untilEdit = r.nextGap()
+	return r, nil
+}
+
+// random returns the next output of the mutation generator.
+func (r *synthReader) random() uint64 {
+	r.mutations += splitmixGamma
+	return splitmix64(r.mutations)
+}
+
+// nextGap returns a random number of unmutated bytes before a mutation,
+// averaging so the mutated fraction of the data is opts.Mutate.
+func (r *synthReader) nextGap() int64 {
+	if r.opts.Mutate == 0 {
+		return math.MaxInt64
+	}
+	if r.opts.Mutate == 1 {
+		return 0
+	}
+	meanEdit := float64(synthMaxEdit) / 2
+	meanGap := meanEdit * (1 - r.opts.Mutate) / r.opts.Mutate
+	return int64(float64(r.random()>>11) / (1 << 53) * 2 * meanGap)
+}
+
+// startEdit starts a random mutation, an overwrite, insertion or deletion.
+func (r *synthReader) startEdit() {
+	n := int64(r.random()%synthMaxEdit) + 1
+	switch r.random() % 3 {
+	case 0:
+		r.overwrite = n
+	case 1:
+		r.insert = n
+	case 2:
+		r.pos += n
+	}
+	r.untilEdit = r.nextGap()
+}
+
+func (r *synthReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n := 0
+	for n < len(p) {
+		switch {
+		case r.insert > 0:
+			m := r.fillRandom(p[n:min(int64(len(p)), int64(n)+r.insert)])
+			r.insert -= int64(m)
+			n += m
+		case r.overwrite > 0:
+			m := r.fillRandom(p[n:min(int64(len(p)), int64(n)+r.overwrite)])
+			r.overwrite -= int64(m)
+			r.pos += int64(m)
+			n += m
+		case r.untilEdit == 0:
+			r.startEdit()
+		default:
+			end := len(p)
+			if r.untilEdit < int64(end-n) {
+				end = n + int(r.untilEdit)
+			}
+			r.fillBase(p[n:end])
+			if r.untilEdit != math.MaxInt64 {
+				r.untilEdit -= int64(end - n)
+			}
+			n = end
+		}
+	}
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+// fillRandom fills p from the mutation generator.
+func (r *synthReader) fillRandom(p []byte) int {
+	var word [8]byte
+	for i := 0; i < len(p); i += 8 {
+		binary.LittleEndian.PutUint64(word[:], r.random())
+		copy(p[i:], word[:])
+	}
+	return len(p)
+}
+
+// fillBase fills p with the unmutated data at r.pos, advancing it.
+func (r *synthReader) fillBase(p []byte) {
+	var word [8]byte
+	for len(p) > 0 {
+		block := uint64(r.pos / 8)
+		binary.LittleEndian.PutUint64(word[:], splitmix64(r.opts.Seed+(block+1)*splitmixGamma))
+		n := copy(p, word[r.pos%8:])
+		p = p[n:]
+		r.pos += int64(n)
+	}
+}
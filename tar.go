@@ -0,0 +1,167 @@
+package cchunker
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+
+	"github.com/restic/chunker"
+)
+
+const tarBlockSize = 512
+
+// tarSplitter forces chunk cuts at the boundaries of the entries of a tar
+// stream, so renaming or reordering files in a tarball only changes the
+// chunks of the entries involved. The input is split into segments of
+// whole entries, each at least MinSize bytes so small files don't become
+// tiny chunks, and each segment is chunked with a fresh splitter.
+type tarSplitter struct {
+	params   Params
+	segments *tarSegmenter
+	splitter splitter
+	start    uint64
+}
+
+func newTarSplitter(r io.Reader, p Params) *tarSplitter {
+	p.TarAware = false
+	return &tarSplitter{
+		params: p,
+		segments: &tarSegmenter{
+			r:       bufio.NewReader(r),
+			minSize: int64(p.MinSize),
+		},
+	}
+}
+
+func (s *tarSplitter) Next(buf []byte) (chunker.Chunk, error) {
+	for {
+		if s.splitter == nil {
+			s.start = uint64(s.segments.pos)
+			s.segments.segmentStart = s.segments.pos
+			s.segments.ended = false
+			s.splitter = s.params.newChunker(s.segments)
+		}
+		chunk, err := s.splitter.Next(buf)
+		if err == io.EOF && s.segments.ended {
+			s.splitter = nil
+			continue
+		}
+		if err != nil {
+			return chunk, err
+		}
+		chunk.Start += uint(s.start)
+		return chunk, nil
+	}
+}
+
+// tarSegmenter reads a tar stream, ending each segment with io.EOF at the
+// first entry boundary at least minSize bytes into it. If the stream
+// stops looking like tar, the rest of it is a single segment.
+type tarSegmenter struct {
+	r       *bufio.Reader
+	minSize int64
+
+	pos          int64
+	segmentStart int64
+	ended        bool
+	// next is the offset of the next tar header, or -1 once the end of
+	// the archive or something that isn't tar has been seen.
+	next int64
+	// extended is set if the last header was a pax or GNU extension
+	// header for the entry after it, which must not be split from it.
+	extended bool
+}
+
+func (t *tarSegmenter) Read(buf []byte) (int, error) {
+	if t.ended {
+		return 0, io.EOF
+	}
+	if t.next == t.pos {
+		if !t.extended && t.pos-t.segmentStart >= t.minSize && t.pos > t.segmentStart {
+			t.ended = true
+			return 0, io.EOF
+		}
+		t.readHeader()
+	}
+	if t.next > t.pos && int64(len(buf)) > t.next-t.pos {
+		buf = buf[:t.next-t.pos]
+	}
+	n, err := t.r.Read(buf)
+	t.pos += int64(n)
+	return n, err
+}
+
+// readHeader parses the tar header at the current position, finding the
+// position of the next one.
+func (t *tarSegmenter) readHeader() {
+	header, err := t.r.Peek(tarBlockSize)
+	if err != nil {
+		t.next = -1
+		return
+	}
+	size, ok := parseTarHeader(header)
+	if !ok {
+		t.next = -1
+		return
+	}
+	switch header[156] {
+	case 'x', 'g', 'L', 'K':
+		t.extended = true
+	default:
+		t.extended = false
+	}
+	t.next = t.pos + tarBlockSize + (size+tarBlockSize-1)/tarBlockSize*tarBlockSize
+}
+
+// parseTarHeader returns the size of the data following a tar header
+// block, it fails on the zero blocks ending an archive or on blocks with
+// a bad checksum.
+func parseTarHeader(header []byte) (int64, bool) {
+	sum, ok := parseTarNumber(header[148:156])
+	if !ok || sum == 0 {
+		return 0, false
+	}
+	actual := int64(0)
+	for i, b := range header {
+		if i >= 148 && i < 156 {
+			b = ' '
+		}
+		actual += int64(b)
+	}
+	if actual != sum {
+		return 0, false
+	}
+
+	switch header[156] {
+	case '1', '2', '3', '4', '5', '6':
+		// These entries never have data, whatever their size says.
+		return 0, true
+	}
+	size, ok := parseTarNumber(header[124:136])
+	if !ok || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// parseTarNumber parses a numeric header field, octal or the GNU base 256
+// encoding.
+func parseTarNumber(field []byte) (int64, bool) {
+	if len(field) > 0 && field[0]&0x80 != 0 {
+		n := int64(field[0] & 0x3f)
+		for _, b := range field[1:] {
+			if n > (1<<55)-1 {
+				return 0, false
+			}
+			n = n<<8 | int64(b)
+		}
+		return n, true
+	}
+	field = bytes.Trim(field, " \x00")
+	if len(field) == 0 {
+		return 0, true
+	}
+	n, err := strconv.ParseInt(string(field), 8, 64)
+	return n, err == nil
+}
@@ -0,0 +1,31 @@
+package cchunker
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingProcessor returns a Processor that records a span for each chunk
+// p processes, as a child of the span in ctx, so slow chunks and stalled
+// processors show up in tracing tools.
+func TracingProcessor(ctx context.Context, tracer trace.Tracer, p Processor) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		_, span := tracer.Start(ctx, "process chunk", trace.WithAttributes(
+			attribute.Int64("cchunker.chunk.index", c.Index),
+			attribute.Int64("cchunker.chunk.offset", int64(c.Offset)),
+			attribute.Int("cchunker.chunk.length", len(c.Data)),
+		))
+		defer span.End()
+
+		err := p.Process(c, out)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	})
+}
@@ -0,0 +1,434 @@
+package cchunker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	// sendBatchChunks and sendBatchBytes bound the chunks a Sender holds
+	// before offering them to the receiver.
+	sendBatchChunks = 256
+	sendBatchBytes  = 32 << 20
+	// maxTransferChunk bounds the chunk size a Receiver accepts, so a bad
+	// frame length can't make it allocate without limit.
+	maxTransferChunk = 256 << 20
+)
+
+// transferRequest is a line of JSON sent by a Sender.
+type transferRequest struct {
+	Hash  string   `json:"hash,omitempty"`
+	Offer []string `json:"offer,omitempty"`
+	Done  bool     `json:"done,omitempty"`
+}
+
+// transferReply is a line of JSON sent by a Receiver.
+type transferReply struct {
+	Have   []string `json:"have,omitempty"`
+	Stored int      `json:"stored,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// TransferResult counts the chunks a Sender offered and how many of them
+// the receiver was missing, so were sent.
+type TransferResult struct {
+	Chunks     int64
+	Bytes      int64
+	SentChunks int64
+	SentBytes  int64
+}
+
+// Sender sends chunks to a Receiver over a connection, only sending the
+// data of chunks the receiver doesn't already have.
+//
+// The sender first sends {"hash":NAME}, the hash naming the chunks, and the
+// receiver replies {} if its store uses the same hash. Chunks are then
+// offered in batches as {"offer":[ids...]}, the receiver replies
+// {"have":[ids...]} with the offered ids it already has, and the sender
+// sends the data of each of the other ids in offer order, each as a big
+// endian uint64 length followed by the data. The receiver checks and
+// stores them, replying {"stored":N}. The sender ends with {"done":true},
+// the receiver replying {} once the chunks are stored. A receiver that
+// fails replies {"error":"..."} and closes the connection.
+type Sender struct {
+	hashName string
+	in       *bufio.Reader
+	out      *bufio.Writer
+
+	mu         sync.Mutex
+	batch      []transferChunk
+	batchBytes int
+	// offered is every id already offered, so repeated chunks are only
+	// offered once.
+	offered map[string]bool
+	result  TransferResult
+	err     error
+}
+
+type transferChunk struct {
+	id   string
+	data []byte
+}
+
+// NewSender starts sending chunks named by hashName over conn.
+func NewSender(conn io.ReadWriter, hashName string) (*Sender, error) {
+	_, err := NewHash(hashName)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sender{
+		hashName: hashName,
+		in:       bufio.NewReader(conn),
+		out:      bufio.NewWriter(conn),
+		offered:  make(map[string]bool),
+	}
+	err = s.request(transferRequest{Hash: hashName}, &transferReply{})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// request sends req and reads the reply into reply.
+func (s *Sender) request(req transferRequest, reply *transferReply) error {
+	err := writeTransferLine(s.out, req)
+	if err == nil {
+		err = s.out.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("error sending to receiver: %s", err)
+	}
+	return s.readReply(reply)
+}
+
+func (s *Sender) readReply(reply *transferReply) error {
+	err := readTransferLine(s.in, reply)
+	if err != nil {
+		return fmt.Errorf("error reading from receiver: %s", err)
+	}
+	if reply.Error != "" {
+		return fmt.Errorf("receiver failed: %s", reply.Error)
+	}
+	return nil
+}
+
+// Processor returns a Processor that sends each chunk, writing a
+// "hash size offset" manifest line per chunk. The data of a chunk is only
+// known to be at the receiver once Close returns.
+func (s *Sender) Processor() Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		h, _ := NewHash(s.hashName)
+		h.Write(c.Data)
+		id := fmt.Sprintf("%x", h.Sum(nil))
+
+		err := s.add(id, c.Data)
+		if err != nil {
+			return err
+		}
+
+		err = writeManifestLine(out, id, c)
+		if err != nil {
+			return fmt.Errorf("error writing manifest line: %s", err)
+		}
+		return nil
+	})
+}
+
+// add queues the chunk id for the next batch, sending the batch if it is full.
+func (s *Sender) add(id string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.result.Chunks += 1
+	s.result.Bytes += int64(len(data))
+	if s.offered[id] {
+		return nil
+	}
+	s.offered[id] = true
+	// The chunk data is only valid until the processor returns.
+	s.batch = append(s.batch, transferChunk{id: id, data: append([]byte(nil), data...)})
+	s.batchBytes += len(data)
+	if len(s.batch) < sendBatchChunks && s.batchBytes < sendBatchBytes {
+		return nil
+	}
+	s.err = s.sendBatch()
+	return s.err
+}
+
+// sendBatch offers the current batch, sending the chunks the receiver
+// doesn't have. Callers must hold s.mu.
+func (s *Sender) sendBatch() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+	offer := make([]string, len(s.batch))
+	for i, c := range s.batch {
+		offer[i] = c.id
+	}
+	var reply transferReply
+	err := s.request(transferRequest{Offer: offer}, &reply)
+	if err != nil {
+		return err
+	}
+
+	have := make(map[string]bool, len(reply.Have))
+	for _, id := range reply.Have {
+		have[id] = true
+	}
+	sent := 0
+	for _, c := range s.batch {
+		if have[c.id] {
+			continue
+		}
+		var hdr [8]byte
+		binary.BigEndian.PutUint64(hdr[:], uint64(len(c.data)))
+		_, err = s.out.Write(hdr[:])
+		if err == nil {
+			_, err = s.out.Write(c.data)
+		}
+		if err != nil {
+			return fmt.Errorf("error sending chunk %s: %s", c.id, err)
+		}
+		sent += 1
+		s.result.SentChunks += 1
+		s.result.SentBytes += int64(len(c.data))
+	}
+	err = s.out.Flush()
+	if err != nil {
+		return fmt.Errorf("error sending chunks: %s", err)
+	}
+	reply = transferReply{}
+	err = s.readReply(&reply)
+	if err != nil {
+		return err
+	}
+	if reply.Stored != sent {
+		return fmt.Errorf("receiver stored %d of %d sent chunks", reply.Stored, sent)
+	}
+
+	s.batch = s.batch[:0]
+	s.batchBytes = 0
+	return nil
+}
+
+// Close sends any chunks still queued and waits for the receiver to make
+// every sent chunk durable. It doesn't close the connection.
+func (s *Sender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.err = s.sendBatch()
+	if s.err != nil {
+		return s.err
+	}
+	s.err = s.request(transferRequest{Done: true}, &transferReply{})
+	if s.err != nil {
+		return s.err
+	}
+	s.err = errors.New("sender is closed")
+	return nil
+}
+
+// Result returns the chunks offered and sent so far.
+func (s *Sender) Result() TransferResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.result
+}
+
+// Receiver stores the chunks sent to it by Senders, telling each sender
+// which chunks it already has so they aren't sent, see Sender.
+//
+// The protocol is neither authenticated nor encrypted, anyone who can
+// connect can learn which chunks the store has and add chunks to it. Each
+// chunk is checked to hash to the id it was offered as before it is
+// stored, so chunks can't be stored under the wrong id, but the listener
+// should only be reachable by trusted senders, e.g. over an ssh tunnel.
+type Receiver struct {
+	Store *Store
+}
+
+// Serve accepts connections on l, serving each one concurrently.
+func (r *Receiver) Serve(l net.Listener) error {
+	_, err := NewHash(r.Store.Hash)
+	if err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			r.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn receives chunks from a single sender until it is done or
+// closes the connection.
+func (r *Receiver) ServeConn(conn io.ReadWriter) error {
+	in := bufio.NewReader(conn)
+	out := bufio.NewWriter(conn)
+
+	err := r.serve(in, out)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeTransferLine(out, transferReply{Error: err.Error()})
+		out.Flush()
+		return err
+	}
+	return nil
+}
+
+func (r *Receiver) serve(in *bufio.Reader, out *bufio.Writer) error {
+	reply := func(v transferReply) error {
+		err := writeTransferLine(out, v)
+		if err == nil {
+			err = out.Flush()
+		}
+		return err
+	}
+
+	var req transferRequest
+	err := readTransferLine(in, &req)
+	if err != nil {
+		return err
+	}
+	if req.Hash != r.Store.Hash {
+		return fmt.Errorf("chunks are named by %s, the receiver store by %s", req.Hash, r.Store.Hash)
+	}
+	h, err := NewHash(r.Store.Hash)
+	if err != nil {
+		return err
+	}
+	idLen := hex.EncodedLen(h.Size())
+	err = reply(transferReply{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		req = transferRequest{}
+		err = readTransferLine(in, &req)
+		if err != nil {
+			return err
+		}
+		if req.Done {
+			// Packed chunks are only durable once their pack is closed.
+			err = r.Store.Close()
+			if err != nil {
+				return err
+			}
+			return reply(transferReply{})
+		}
+
+		var have, missing []string
+		for _, id := range req.Offer {
+			if !validChunkID(id, idLen) {
+				return fmt.Errorf("invalid chunk id %q", id)
+			}
+			ok, err := r.Store.has(id)
+			if err != nil {
+				return fmt.Errorf("error checking for chunk %s: %s", id, err)
+			}
+			if ok {
+				have = append(have, id)
+			} else {
+				missing = append(missing, id)
+			}
+		}
+		err = reply(transferReply{Have: have})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range missing {
+			data, err := readTransferChunk(in)
+			if err != nil {
+				return fmt.Errorf("error reading chunk %s: %s", id, err)
+			}
+			got, err := r.Store.ID(data)
+			if err != nil {
+				return err
+			}
+			if got != id {
+				return fmt.Errorf("chunk %s was sent with the data of %s", id, got)
+			}
+			_, err = r.Store.Put(data)
+			if err != nil {
+				return err
+			}
+		}
+		err = reply(transferReply{Stored: len(missing)})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// validChunkID reports if id is n lower case hex digits, so an offered id
+// can't name a path outside the store.
+func validChunkID(id string, n int) bool {
+	if len(id) != n {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// readTransferChunk reads a length prefixed chunk, failing if its length
+// is over maxTransferChunk.
+func readTransferChunk(in io.Reader) ([]byte, error) {
+	var hdr [8]byte
+	_, err := io.ReadFull(in, hdr[:])
+	if err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint64(hdr[:])
+	if n > maxTransferChunk {
+		return nil, fmt.Errorf("chunk length %d is over the limit of %d", n, maxTransferChunk)
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(in, data)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+func writeTransferLine(out io.Writer, v any) error {
+	return json.NewEncoder(out).Encode(v)
+}
+
+// readTransferLine reads a line of JSON into v, data follows some lines so
+// the reader must not be read past the end of the line.
+func readTransferLine(in *bufio.Reader, v any) error {
+	line, err := in.ReadBytes('\n')
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(line) != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	err = json.Unmarshal(line, v)
+	if err != nil {
+		return fmt.Errorf("invalid message: %s", err)
+	}
+	return nil
+}
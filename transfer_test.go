@@ -0,0 +1,64 @@
+package cchunker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+)
+
+// receive runs a Receiver on the requests and chunk data of a sender
+// offering ids, sending data for them.
+func receive(t *testing.T, s *Store, ids []string, data [][]byte) error {
+	t.Helper()
+	var in bytes.Buffer
+	writeTransferLine(&in, transferRequest{Hash: s.Hash})
+	writeTransferLine(&in, transferRequest{Offer: ids})
+	for _, d := range data {
+		in.Write(binary.BigEndian.AppendUint64(nil, uint64(len(d))))
+		in.Write(d)
+	}
+	writeTransferLine(&in, transferRequest{Done: true})
+	r := &Receiver{Store: s}
+	return r.serve(bufio.NewReader(&in), bufio.NewWriter(&bytes.Buffer{}))
+}
+
+func TestReceiverChecksChunks(t *testing.T) {
+	s := &Store{Dir: t.TempDir(), Hash: "sha256"}
+	data := testData(1000)
+	id, err := s.ID(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.ID(data[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = receive(t, s, []string{other}, [][]byte{data})
+	if err == nil || !strings.Contains(err.Error(), "was sent with the data of") {
+		t.Fatalf("expected a chunk sent under the wrong id to fail, got %v", err)
+	}
+	if _, err := os.Stat(s.Path(id)); !os.IsNotExist(err) {
+		t.Fatal("a chunk sent under the wrong id was stored")
+	}
+
+	err = receive(t, s, []string{"../../../" + id[9:]}, nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid chunk id") {
+		t.Fatalf("expected an id that isn't hex to fail, got %v", err)
+	}
+
+	err = receive(t, s, []string{id}, [][]byte{data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("received chunk differs")
+	}
+}
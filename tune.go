@@ -0,0 +1,256 @@
+package cchunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+)
+
+// tuneAverages are the average chunk sizes TuneCandidates tries.
+var tuneAverages = []uint{64 * kiB, 256 * kiB, miB, 4 * miB, 16 * miB}
+
+// tuneMinChunks is the fewest chunks a sample must be expected to cut
+// for a candidate to be tried, fewer say little about the distribution.
+const tuneMinChunks = 16
+
+// TuneResult is how a sample chunks with one set of parameters.
+type TuneResult struct {
+	Params Params
+	Chunks int
+	// MeanSize and StdDev are of the chunk sizes.
+	MeanSize float64
+	StdDev   float64
+	Smallest uint
+	Largest  uint
+	// AtMax is the fraction of chunks cut at the max size, forced rather
+	// than found by content.
+	AtMax float64
+	// Duplicate is the fraction of the sample in chunks repeating an
+	// earlier chunk of the sample, the dedup within it.
+	Duplicate float64
+	// EditReuse is the fraction of an edited copy of the sample in chunks
+	// also cut from the sample, how much survives small changes to the
+	// data.
+	EditReuse float64
+}
+
+// TuneOptions controls Tune.
+type TuneOptions struct {
+	// Edits is the number of small insertions, deletions and overwrites
+	// made to the copy of the sample EditReuse is measured with.
+	Edits int
+	// Seed picks the edits.
+	Seed uint64
+	// Jobs is the number of candidates chunked concurrently.
+	Jobs int
+}
+
+// TuneCandidates returns the parameters Tune compares for a sample of
+// sampleSize bytes, combinations of average sizes from 64KiB to 16MiB
+// with min and max sizes a quarter and four times the average, or half
+// and eight times. Averages the sample is too small to cut
+// enough chunks of are left out. The algorithm, polynomial and other
+// settings are those of base.
+func TuneCandidates(base Params, sampleSize int64) []Params {
+	var candidates []Params
+	for _, avg := range tuneAverages {
+		if int64(avg)*tuneMinChunks > sampleSize {
+			break
+		}
+		for _, spread := range [][2]uint{{4, 4}, {2, 8}} {
+			p := base
+			p.MinSize = avg / spread[0]
+			p.MaxSize = avg * spread[1]
+			p.AverageBits = AverageBitsForSize(uint64(avg))
+			candidates = append(candidates, p)
+		}
+	}
+	return candidates
+}
+
+// Tune chunks sample and an edited copy of it with each of candidates,
+// reporting the chunk size distribution and dedup of each.
+func Tune(sample []byte, candidates []Params, opts TuneOptions) ([]TuneResult, error) {
+	for _, p := range candidates {
+		if p.Algorithm == "fixed" {
+			return nil, fmt.Errorf("only content defined chunking can be tuned, not fixed size chunks")
+		}
+		err := p.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	edited := editSample(sample, opts.Edits, opts.Seed)
+
+	jobs := opts.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	results := make([]TuneResult, len(candidates))
+	errs := make([]error, len(candidates))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, p := range candidates {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = tuneCandidate(sample, edited, p)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// RecommendTune returns the index of the result to recommend, the one
+// with the largest average size, so the fewest chunks to store and
+// track, whose EditReuse is within two percentage points of the best
+// and that cuts at most a tenth of its chunks at the max size. It
+// returns -1 if there are no results.
+func RecommendTune(results []TuneResult) int {
+	best := 0.0
+	for _, r := range results {
+		best = math.Max(best, r.EditReuse)
+	}
+	pick := -1
+	for i, r := range results {
+		if r.EditReuse < best-0.02 || r.AtMax > 0.1 {
+			continue
+		}
+		if pick == -1 || r.MeanSize > results[pick].MeanSize {
+			pick = i
+		}
+	}
+	if pick == -1 && len(results) != 0 {
+		// Nothing avoids forced cuts, prefer reuse.
+		for i, r := range results {
+			if pick == -1 || r.EditReuse > results[pick].EditReuse {
+				pick = i
+			}
+		}
+	}
+	return pick
+}
+
+// tuneCandidate measures how sample and edited chunk with params.
+func tuneCandidate(sample, edited []byte, params Params) (TuneResult, error) {
+	r := TuneResult{Params: params}
+	seen := make(map[[sha256.Size]byte]bool)
+	var sum, sumSquares float64
+	var duplicate, atMax int
+	err := tuneChunks(sample, params, func(data []byte) {
+		size := uint(len(data))
+		if r.Chunks == 0 || size < r.Smallest {
+			r.Smallest = size
+		}
+		r.Largest = max(r.Largest, size)
+		if size == params.MaxSize {
+			atMax += 1
+		}
+		sum += float64(size)
+		sumSquares += float64(size) * float64(size)
+		id := sha256.Sum256(data)
+		if seen[id] {
+			duplicate += len(data)
+		}
+		seen[id] = true
+		r.Chunks += 1
+	})
+	if err != nil {
+		return r, err
+	}
+	if r.Chunks == 0 {
+		return r, nil
+	}
+	r.MeanSize = sum / float64(r.Chunks)
+	r.StdDev = math.Sqrt(math.Max(0, sumSquares/float64(r.Chunks)-r.MeanSize*r.MeanSize))
+	r.AtMax = float64(atMax) / float64(r.Chunks)
+	r.Duplicate = float64(duplicate) / float64(len(sample))
+
+	reused := 0
+	err = tuneChunks(edited, params, func(data []byte) {
+		if seen[sha256.Sum256(data)] {
+			reused += len(data)
+		}
+	})
+	if err != nil {
+		return r, err
+	}
+	if len(edited) != 0 {
+		r.EditReuse = float64(reused) / float64(len(edited))
+	}
+	return r, nil
+}
+
+// tuneChunks calls f with the data of each chunk of data.
+func tuneChunks(data []byte, params Params, f func(data []byte)) error {
+	buf := make([]byte, params.MaxSize)
+	splitter := params.newChunker(bytes.NewReader(data))
+	for {
+		c, err := splitter.Next(buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error chunking sample: %s", err)
+		}
+		f(c.Data)
+	}
+}
+
+// editSample returns a copy of data with n edits at pseudo-random places,
+// each inserting, deleting or overwriting up to 64 bytes.
+func editSample(data []byte, n int, seed uint64) []byte {
+	if len(data) == 0 || n <= 0 {
+		return data
+	}
+	state := seed
+	random := func() uint64 {
+		state += splitmixGamma
+		return splitmix64(state)
+	}
+	positions := make([]int, n)
+	for i := range positions {
+		positions[i] = int(random() % uint64(len(data)))
+	}
+	sort.Ints(positions)
+
+	edited := make([]byte, 0, len(data)+n*64)
+	prev := 0
+	for _, pos := range positions {
+		if pos < prev {
+			continue
+		}
+		edited = append(edited, data[prev:pos]...)
+		length := int(random()%64) + 1
+		change := make([]byte, length)
+		for i := range change {
+			change[i] = byte(random())
+		}
+		switch random() % 3 {
+		case 0:
+			// Insert.
+			edited = append(edited, change...)
+			prev = pos
+		case 1:
+			// Delete.
+			prev = min(pos+length, len(data))
+		default:
+			// Overwrite.
+			end := min(pos+length, len(data))
+			edited = append(edited, change[:end-pos]...)
+			prev = end
+		}
+	}
+	return append(edited, data[prev:]...)
+}
@@ -0,0 +1,105 @@
+package cchunker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// VerifyResult counts the chunks checked by Store.Verify.
+type VerifyResult struct {
+	Chunks  int64
+	Missing int64
+	Corrupt int64
+}
+
+// OK reports if every chunk was present and intact.
+func (r VerifyResult) OK() bool {
+	return r.Missing == 0 && r.Corrupt == 0
+}
+
+// Verify checks every chunk referenced by manifest, including parity
+// chunks, is in the store, hashes to its id and has the size in the
+// manifest, writing a "missing ID" or "corrupt ID REASON" line to report
+// for each chunk that doesn't. Chunks
+// referenced more than once are only checked once, and elided zero chunks
+// are not stored so aren't checked. The error is only for failing to read
+// the manifest or write the report.
+func (s *Store) Verify(manifest io.Reader, report io.Writer) (VerifyResult, error) {
+	var result VerifyResult
+	data, err := io.ReadAll(manifest)
+	if err != nil {
+		return result, fmt.Errorf("error reading manifest: %s", err)
+	}
+	sections, err := ReadManifest(bytes.NewReader(data))
+	if err != nil {
+		return result, err
+	}
+	groups, err := readParityGroups(bytes.NewReader(data))
+	if err != nil {
+		return result, err
+	}
+	// Parity chunks are checked like the data chunks.
+	for _, g := range groups {
+		sections = append(sections, ManifestSection{Chunks: g.parity})
+	}
+
+	checked := make(map[string]struct{})
+	for _, section := range sections {
+		for _, c := range section.Chunks {
+			if c.ID == ZeroChunkID {
+				continue
+			}
+			if _, ok := checked[c.ID]; ok {
+				continue
+			}
+			checked[c.ID] = struct{}{}
+			result.Chunks += 1
+
+			var line string
+			data, err := s.read(c.ID)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				result.Missing += 1
+				line = fmt.Sprintf("missing %s\n", c.ID)
+			case err != nil:
+				result.Corrupt += 1
+				line = fmt.Sprintf("corrupt %s unreadable: %s\n", c.ID, err)
+			default:
+				reason, err := s.checkChunk(c, data)
+				if err != nil {
+					return result, err
+				}
+				if reason != "" {
+					result.Corrupt += 1
+					line = fmt.Sprintf("corrupt %s %s\n", c.ID, reason)
+				}
+			}
+			if line != "" {
+				_, err = io.WriteString(report, line)
+				if err != nil {
+					return result, fmt.Errorf("error writing verify report: %s", err)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// checkChunk returns why the stored data of c is corrupt, or "" if it is
+// intact.
+func (s *Store) checkChunk(c ManifestChunk, data []byte) (string, error) {
+	actual, err := s.ID(data)
+	if err != nil {
+		return "", err
+	}
+	if actual != c.ID {
+		return fmt.Sprintf("contents hash to %s", actual), nil
+	}
+	if uint64(len(data)) != c.Size {
+		return fmt.Sprintf("has size %d, manifest expects %d", len(data), c.Size), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,76 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package cchunker
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+
+	"golang.org/x/sys/unix"
+)
+
+// listXattrs returns the extended attributes of the file at p, without
+// following symlinks.
+func listXattrs(p string) (map[string][]byte, error) {
+	names, err := xattrCall(func(buf []byte) (int, error) {
+		return unix.Llistxattr(p, buf)
+	})
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "listxattr", Path: p, Err: err}
+	}
+
+	var xattrs map[string][]byte
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := xattrCall(func(buf []byte) (int, error) {
+			return unix.Lgetxattr(p, string(name), buf)
+		})
+		if err != nil {
+			return nil, &fs.PathError{Op: "getxattr " + string(name), Path: p, Err: err}
+		}
+		if xattrs == nil {
+			xattrs = make(map[string][]byte)
+		}
+		xattrs[string(name)] = value
+	}
+	return xattrs, nil
+}
+
+// xattrCall calls f with a buffer large enough for its result, asking
+// for the size first and retrying if the attribute grew in between.
+func xattrCall(f func(buf []byte) (int, error)) ([]byte, error) {
+	for {
+		n, err := f(nil)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return []byte{}, nil
+		}
+		buf := make([]byte, n)
+		n, err = f(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// setXattr sets an extended attribute of the file at p, without
+// following symlinks.
+func setXattr(p string, name string, value []byte) error {
+	err := unix.Lsetxattr(p, name, value, 0)
+	if err != nil {
+		return &fs.PathError{Op: "setxattr " + name, Path: p, Err: err}
+	}
+	return nil
+}
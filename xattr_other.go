@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || netbsd)
+
+package cchunker
+
+import (
+	"errors"
+)
+
+func listXattrs(p string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+func setXattr(p string, name string, value []byte) error {
+	return errors.New("extended attributes are not supported on this system")
+}
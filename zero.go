@@ -0,0 +1,43 @@
+package cchunker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ZeroChunkID stands in for the hash in the manifest line of an all zero
+// chunk elided by ElideZeroProcessor, such chunks are never stored and
+// are restored as zeros.
+const ZeroChunkID = "zero"
+
+// isZero reports whether data is all zero bytes.
+func isZero(data []byte) bool {
+	// Comparing data with itself shifted by one byte checks every byte
+	// equals the first, at the speed of memcmp.
+	return len(data) == 0 || (data[0] == 0 && bytes.Equal(data[1:], data[:len(data)-1]))
+}
+
+// ElideZeroProcessor returns a Processor running zero instead of p on all
+// zero chunks, such as the unused regions of VM disk images, so they cost
+// nothing to process.
+func ElideZeroProcessor(zero Processor, p Processor) Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		if isZero(c.Data) {
+			return zero.Process(c, out)
+		}
+		return p.Process(c, out)
+	})
+}
+
+// ZeroChunkProcessor returns a Processor writing a "zero size offset"
+// manifest line for each chunk, see ZeroChunkID.
+func ZeroChunkProcessor() Processor {
+	return ProcessorFunc(func(c Chunk, out io.Writer) error {
+		err := writeManifestLine(out, ZeroChunkID, c)
+		if err != nil {
+			return fmt.Errorf("error writing manifest line: %s", err)
+		}
+		return nil
+	})
+}